@@ -2,64 +2,103 @@ package main
 
 import (
 	"context"
-	"log"
+	"database/sql/driver"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/XSAM/otelsql"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 
+	"opspilot-backend/database"
+	"opspilot-backend/internal/agentconfig"
 	"opspilot-backend/internal/cache"
 	"opspilot-backend/internal/handlers"
 	"opspilot-backend/internal/ingest"
+	"opspilot-backend/internal/logging"
+	"opspilot-backend/internal/metrics"
+	rl "opspilot-backend/internal/middleware"
+	"opspilot-backend/internal/migrate"
+	"opspilot-backend/internal/natsauth"
 	"opspilot-backend/internal/natsbus"
 	"opspilot-backend/internal/rpc"
 	"opspilot-backend/internal/services"
 	"opspilot-backend/internal/storage"
+	"opspilot-backend/internal/tracing"
 	"opspilot-backend/internal/workers"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "nats" {
+		runNATS(os.Args[2:])
+		return
+	}
+
+	logging.Setup()
+
 	if os.Getenv("JWT_SECRET") == "" {
-		log.Fatal("JWT_SECRET is required")
+		fatal("JWT_SECRET is required")
 	}
 	if os.Getenv("REDIS_URL") == "" {
-		log.Fatal("REDIS_URL is required")
+		fatal("REDIS_URL is required")
 	}
 
-	// Database connection (with retries)
-	var db *sqlx.DB
-	var err error
-	for i := 0; i < 10; i++ {
-		db, err = sqlx.Connect("postgres", buildDSN())
-		if err == nil {
-			break
-		}
-		log.Printf("DB connection attempt %d failed: %v", i+1, err)
-		time.Sleep(2 * time.Second)
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		fatal("Failed to set up tracing", "err", err)
 	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Warn("Tracing shutdown", "err", err)
+		}
+	}()
+
+	db, err := connectDB()
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		fatal("Failed to connect to database", "err", err)
 	}
 	defer db.Close()
-	log.Println("Connected to database")
+	slog.Info("Connected to database")
+	metrics.RegisterDB(db.DB)
+
+	// Schema migrations (DB_AUTO_MIGRATE=false to run them via `migrate up` only)
+	if getEnv("DB_AUTO_MIGRATE", "true") != "false" {
+		migrator, err := migrate.New(db, database.Migrations, "migrations")
+		if err != nil {
+			fatal("Failed to load migrations", "err", err)
+		}
+		applied, err := migrator.Up(context.Background())
+		if err != nil {
+			fatal("Failed to apply migrations", "err", err)
+		}
+		slog.Info("Database schema up to date", "applied", applied)
+	}
 
 	// NATS connection
 	natsClient, err := natsbus.Connect()
 	if err != nil {
-		log.Fatalf("Failed to connect to NATS: %v", err)
+		fatal("Failed to connect to NATS", "err", err)
 	}
 	defer natsClient.Close()
 
 	// Redis cache
 	redisClient, err := cache.NewRedisClient()
 	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		fatal("Failed to connect to Redis", "err", err)
 	}
 	defer redisClient.Close()
 
@@ -77,34 +116,82 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	eventsConsumer := ingest.NewEventsConsumer(natsClient.JS(), store)
+	deadLetters := natsbus.NewDeadLetters(natsClient.JS())
+
+	eventsConsumer := ingest.NewEventsConsumer(natsClient.JS(), store, deadLetters)
 	if err := eventsConsumer.Start(ctx); err != nil {
-		log.Fatalf("Failed to start events consumer: %v", err)
+		fatal("Failed to start events consumer", "err", err)
 	}
 
-	inventoryConsumer := ingest.NewInventoryConsumer(natsClient.JS(), store)
+	inventoryConsumer := ingest.NewInventoryConsumer(natsClient.JS(), store, deadLetters)
 	if err := inventoryConsumer.Start(ctx); err != nil {
-		log.Fatalf("Failed to start inventory consumer: %v", err)
+		fatal("Failed to start inventory consumer", "err", err)
 	}
 
-	kvWatcher := ingest.NewKVWatcher(natsClient.KV(), store, redisClient)
+	kvWatcher := ingest.NewKVWatcher(natsClient.KV(), store, redisClient, natsauth.NewFingerprintMonitor(store))
 	if err := kvWatcher.Start(ctx); err != nil {
-		log.Fatalf("Failed to start KV watcher: %v", err)
+		fatal("Failed to start KV watcher", "err", err)
 	}
 
+	workers.StartRetentionWorker(ctx, store, 6*time.Hour, getEnv("RETENTION_ARCHIVE", "false") == "true")
+	workers.StartRolloutWorker(ctx, store, rpcClient, 15*time.Second)
+
+	configPublisher := agentconfig.NewPublisher(store, natsClient.ConfigKV())
+	workers.StartAgentConfigWorker(ctx, configPublisher, 5*time.Minute)
+	workers.StartAgentMetricsWorker(ctx, store, 30*time.Second)
+
 	keyEventsActive := workers.StartRedisKeyeventWorker(ctx, redisClient, store)
 	if !keyEventsActive {
-		log.Println("WARN Redis keyspace notifications are not active; fallback reconciler will be used")
+		slog.Warn("Redis keyspace notifications are not active; fallback reconciler will be used")
 		workers.StartHeartbeatReconciler(ctx, redisClient, store)
 	}
 
+	// NATS JWT issuer for agent credentials
+	issuer, err := natsauth.NewJWTIssuer(
+		os.Getenv("NATS_SIGNING_KEY_SEED"),
+		os.Getenv("NATS_AGENTS_ACCOUNT_PUBLIC_KEY"),
+	)
+	if err != nil {
+		slog.Warn("NATS JWT issuer disabled", "err", err)
+		issuer = nil
+	}
+
+	var renewalService *natsauth.RenewalService
+	if issuer != nil {
+		renewalService = natsauth.NewRenewalService(natsClient.NC(), store, issuer, natsauth.NewNonceStore(redisClient))
+		if err := renewalService.Start(); err != nil {
+			fatal("Failed to start JWT renewal service", "err", err)
+		}
+	}
+
+	// NATS account revocations (optional: needs operator signing key + account JWT)
+	claimsConn := natsClient.NC()
+	systemConn, err := natsbus.ConnectSystem()
+	if err != nil {
+		fatal("Failed to connect to NATS system account", "err", err)
+	}
+	if systemConn != nil {
+		defer systemConn.Drain()
+		claimsConn = systemConn
+	}
+	accountManager, err := natsauth.NewAccountManagerFromEnv(claimsConn, store)
+	if err != nil {
+		slog.Warn("NATS account revocations disabled", "err", err)
+		accountManager = nil
+	} else {
+		accountManager.Start(ctx, 5*time.Minute)
+	}
+
 	// HTTP handlers
-	h := handlers.New(store, db, aiClient, slackClient, rpcClient, redisClient)
+	h := handlers.New(store, db, aiClient, slackClient, rpcClient, redisClient, issuer, accountManager, configPublisher, deadLetters)
 
 	// Router
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	r.Use(middleware.RequestID)
+	r.Use(rl.Tracing)
+	r.Use(rl.RequestLogger)
 	r.Use(middleware.Recoverer)
+	r.Use(rl.Metrics)
 	h.RegisterRoutes(r)
 
 	server := &http.Server{
@@ -112,13 +199,27 @@ func main() {
 		Handler: r,
 	}
 
+	// Prometheus metrics on their own listener, kept off the public API
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", promhttp.Handler())
+	metricsServer := &http.Server{
+		Addr:    getEnv("METRICS_ADDR", ":9090"),
+		Handler: metricsMux,
+	}
+	go func() {
+		slog.Info("Metrics server starting", "addr", metricsServer.Addr)
+		if err := metricsServer.ListenAndServe(); err != http.ErrServerClosed {
+			fatal("Metrics server error", "err", err)
+		}
+	}()
+
 	// Graceful shutdown
 	go func() {
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 		<-sigCh
 
-		log.Println("Shutting down...")
+		slog.Info("Shutting down...")
 		cancel()
 
 		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -127,14 +228,66 @@ func main() {
 		_ = eventsConsumer.Stop()
 		_ = inventoryConsumer.Stop()
 		_ = kvWatcher.Stop()
+		if renewalService != nil {
+			_ = renewalService.Stop()
+		}
 		_ = server.Shutdown(shutdownCtx)
+		_ = metricsServer.Shutdown(shutdownCtx)
 	}()
 
-	log.Println("Server starting on :8080")
+	slog.Info("Server starting", "addr", server.Addr)
 	if err := server.ListenAndServe(); err != http.ErrServerClosed {
-		log.Fatalf("Server error: %v", err)
+		fatal("Server error", "err", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// connectDB opens the database, retrying while Postgres starts up.
+func connectDB() (*sqlx.DB, error) {
+	var db *sqlx.DB
+	var err error
+	for i := 0; i < 10; i++ {
+		db, err = openDB()
+		if err == nil {
+			return db, nil
+		}
+		slog.Warn("DB connection attempt failed", "attempt", i+1, "err", err)
+		time.Sleep(2 * time.Second)
+	}
+	return nil, err
+}
+
+// openDB opens Postgres through the otelsql driver wrapper so queries made
+// with a traced context show up as spans; queries outside a trace do not
+// start traces of their own.
+func openDB() (*sqlx.DB, error) {
+	sqlDB, err := otelsql.Open("postgres", buildDSN(),
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitConnPrepare:      true,
+			OmitRows:             true,
+			DisableErrSkip:       true,
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return tracing.HasParent(ctx)
+			},
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	db := sqlx.NewDb(sqlDB, "postgres")
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
 	}
-	log.Println("Server stopped")
+	return db, nil
 }
 
 func buildDSN() string {
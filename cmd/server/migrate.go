@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"opspilot-backend/database"
+	"opspilot-backend/internal/logging"
+	"opspilot-backend/internal/migrate"
+)
+
+const migrateUsage = "usage: server migrate up | down [steps] | status"
+
+// runMigrate implements `server migrate up|down [steps]|status`.
+func runMigrate(args []string) {
+	logging.Setup()
+
+	if len(args) == 0 {
+		usage(migrateUsage)
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		fatal("Failed to connect to database", "err", err)
+	}
+	defer db.Close()
+
+	migrator, err := migrate.New(db, database.Migrations, "migrations")
+	if err != nil {
+		fatal("Failed to load migrations", "err", err)
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			fatal("Migration failed", "err", err)
+		}
+		slog.Info("Migrations applied", "applied", applied)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				usage(migrateUsage)
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			fatal("Migration failed", "err", err)
+		}
+		slog.Info("Migrations reverted", "reverted", reverted)
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			fatal("Failed to load migration status", "err", err)
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format("2006-01-02 15:04:05Z07:00")
+			}
+			fmt.Fprintf(os.Stdout, "%04d  %-32s  %s\n", status.Version, status.Name, applied)
+		}
+
+	default:
+		usage(migrateUsage)
+	}
+}
+
+// usage prints a subcommand's usage line and exits.
+func usage(line string) {
+	fmt.Fprintln(os.Stderr, line)
+	os.Exit(2)
+}
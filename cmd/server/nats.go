@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"opspilot-backend/internal/logging"
+	"opspilot-backend/internal/natsbus"
+)
+
+const natsUsage = "usage: server nats plan | apply"
+
+// runNATS implements `server nats plan|apply`: report or apply the pending
+// stream and KV bucket changes of NATS_INFRA_CONFIG.
+func runNATS(args []string) {
+	logging.Setup()
+
+	if len(args) != 1 || (args[0] != "plan" && args[0] != "apply") {
+		usage(natsUsage)
+	}
+
+	infra, err := natsbus.LoadInfraConfig()
+	if err != nil {
+		fatal("Failed to load NATS infra config", "err", err)
+	}
+
+	nc, err := natsbus.Dial()
+	if err != nil {
+		fatal("Failed to connect to NATS", "err", err)
+	}
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		fatal("Failed to create JetStream context", "err", err)
+	}
+
+	changes, err := natsbus.PlanInfrastructure(js, infra)
+	if err != nil {
+		fatal("Failed to plan NATS infrastructure", "err", err)
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(os.Stdout, "NATS streams and KV buckets are up to date")
+		return
+	}
+	for _, change := range changes {
+		fmt.Fprintln(os.Stdout, change)
+	}
+
+	if args[0] == "apply" {
+		if err := natsbus.ApplyInfrastructure(js, changes); err != nil {
+			fatal("Failed to apply NATS infrastructure", "err", err)
+		}
+		slog.Info("NATS changes applied", "changes", len(changes))
+	}
+}
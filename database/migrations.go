@@ -0,0 +1,10 @@
+// Package database embeds the versioned SQL migrations.
+//
+// Files are named NNNN_description.up.sql / NNNN_description.down.sql and are
+// applied in version order by internal/migrate.
+package database
+
+import "embed"
+
+//go:embed migrations/*.sql
+var Migrations embed.FS
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS agent_conflicts;
+DROP TABLE IF EXISTS agent_connections;
+DROP TABLE IF EXISTS agents_inventory;
+DROP TABLE IF EXISTS incidents;
+DROP TABLE IF EXISTS agent_credentials;
+DROP TABLE IF EXISTS agents;
+DROP TABLE IF EXISTS bootstrap_tokens;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS organizations;
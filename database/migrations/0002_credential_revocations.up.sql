@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS credential_revocations (
+    public_key TEXT PRIMARY KEY,
+    agent_id VARCHAR(12) NOT NULL,
+    revoked_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    jwt_expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_credential_revocations_expires ON credential_revocations(jwt_expires_at);
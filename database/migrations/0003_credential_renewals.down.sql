@@ -0,0 +1,2 @@
+ALTER TABLE agent_credentials DROP COLUMN IF EXISTS last_renewed_at;
+ALTER TABLE agent_credentials DROP COLUMN IF EXISTS renewal_count;
@@ -0,0 +1,2 @@
+ALTER TABLE agent_credentials ADD COLUMN IF NOT EXISTS renewal_count INT NOT NULL DEFAULT 0;
+ALTER TABLE agent_credentials ADD COLUMN IF NOT EXISTS last_renewed_at TIMESTAMPTZ;
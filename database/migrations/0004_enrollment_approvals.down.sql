@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS agent_enrollments;
+ALTER TABLE bootstrap_tokens DROP COLUMN IF EXISTS require_approval;
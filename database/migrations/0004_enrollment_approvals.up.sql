@@ -0,0 +1,24 @@
+ALTER TABLE bootstrap_tokens ADD COLUMN IF NOT EXISTS require_approval BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS agent_enrollments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    agent_id VARCHAR(12) NOT NULL REFERENCES agents(agent_id) ON DELETE CASCADE,
+    org_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    bootstrap_token_id UUID REFERENCES bootstrap_tokens(id) ON DELETE SET NULL,
+    public_key TEXT NOT NULL,
+    hostname VARCHAR(255),
+    hardware_fingerprint TEXT,
+    remote_ip INET,
+    os VARCHAR(64),
+    arch VARCHAR(64),
+    agent_version VARCHAR(64),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    decided_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    decided_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_enrollments_org_status ON agent_enrollments(org_id, status, created_at DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_agent_enrollments_open
+    ON agent_enrollments(agent_id)
+    WHERE status IN ('pending', 'approved');
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS security_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    org_id UUID REFERENCES organizations(id) ON DELETE CASCADE,
+    agent_id VARCHAR(12),
+    bootstrap_token_id UUID REFERENCES bootstrap_tokens(id) ON DELETE SET NULL,
+    type VARCHAR(50) NOT NULL,
+    remote_ip INET,
+    details JSONB,
+    created_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_security_events_org ON security_events(org_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_security_events_token ON security_events(bootstrap_token_id) WHERE bootstrap_token_id IS NOT NULL;
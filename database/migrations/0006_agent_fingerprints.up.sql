@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS agent_fingerprints (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    agent_id VARCHAR(12) NOT NULL REFERENCES agents(agent_id) ON DELETE CASCADE,
+    fingerprint TEXT NOT NULL,
+    source VARCHAR(20) NOT NULL,
+    remote_ip INET,
+    seen_count INT NOT NULL DEFAULT 1,
+    first_seen_at TIMESTAMPTZ DEFAULT now(),
+    last_seen_at TIMESTAMPTZ DEFAULT now(),
+    UNIQUE(agent_id, fingerprint)
+);
@@ -0,0 +1,5 @@
+ALTER TABLE bootstrap_tokens DROP COLUMN IF EXISTS allowed_arch;
+ALTER TABLE bootstrap_tokens DROP COLUMN IF EXISTS allowed_os;
+ALTER TABLE bootstrap_tokens DROP COLUMN IF EXISTS max_active_agents;
+ALTER TABLE bootstrap_tokens DROP COLUMN IF EXISTS tag_rules;
+ALTER TABLE bootstrap_tokens DROP COLUMN IF EXISTS name_template;
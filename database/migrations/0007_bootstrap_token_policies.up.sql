@@ -0,0 +1,5 @@
+ALTER TABLE bootstrap_tokens ADD COLUMN IF NOT EXISTS name_template VARCHAR(255);
+ALTER TABLE bootstrap_tokens ADD COLUMN IF NOT EXISTS tag_rules JSONB NOT NULL DEFAULT '[]'::jsonb;
+ALTER TABLE bootstrap_tokens ADD COLUMN IF NOT EXISTS max_active_agents INT;
+ALTER TABLE bootstrap_tokens ADD COLUMN IF NOT EXISTS allowed_os JSONB DEFAULT NULL;
+ALTER TABLE bootstrap_tokens ADD COLUMN IF NOT EXISTS allowed_arch JSONB DEFAULT NULL;
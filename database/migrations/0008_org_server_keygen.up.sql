@@ -0,0 +1 @@
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS allow_server_keygen BOOLEAN NOT NULL DEFAULT true;
@@ -0,0 +1,58 @@
+DROP TABLE IF EXISTS retention_policies;
+DROP TABLE IF EXISTS agents_inventory_latest;
+
+ALTER TABLE incidents RENAME TO incidents_partitioned;
+ALTER INDEX incidents_pkey RENAME TO incidents_partitioned_pkey;
+ALTER SEQUENCE incidents_id_seq OWNED BY NONE;
+
+CREATE TABLE incidents (
+    id INT PRIMARY KEY DEFAULT nextval('incidents_id_seq'),
+    agent_id VARCHAR(12) REFERENCES agents(agent_id),
+    type VARCHAR(50),
+    source VARCHAR(255),
+    raw_error TEXT,
+    context JSONB,
+    ai_analysis TEXT,
+    is_critical BOOLEAN DEFAULT FALSE,
+    suggested_action JSONB,
+    status VARCHAR(20) DEFAULT 'new',
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+INSERT INTO incidents (id, agent_id, type, source, raw_error, context, ai_analysis,
+    is_critical, suggested_action, status, created_at)
+SELECT id, agent_id, type, source, raw_error, context, ai_analysis,
+    is_critical, suggested_action, status, created_at
+FROM incidents_partitioned;
+
+DROP TABLE incidents_partitioned;
+ALTER SEQUENCE incidents_id_seq OWNED BY incidents.id;
+
+ALTER TABLE agents_inventory RENAME TO agents_inventory_partitioned;
+ALTER INDEX agents_inventory_pkey RENAME TO agents_inventory_partitioned_pkey;
+ALTER SEQUENCE agents_inventory_id_seq OWNED BY NONE;
+
+CREATE TABLE agents_inventory (
+    id BIGINT PRIMARY KEY DEFAULT nextval('agents_inventory_id_seq'),
+    agent_id TEXT NOT NULL REFERENCES agents(agent_id) ON DELETE CASCADE,
+    ts TIMESTAMPTZ NOT NULL DEFAULT now(),
+    hash TEXT NOT NULL,
+    payload JSONB NOT NULL
+);
+
+INSERT INTO agents_inventory (id, agent_id, ts, hash, payload)
+SELECT DISTINCT ON (agent_id, hash) id, agent_id, ts, hash, payload
+FROM agents_inventory_partitioned
+ORDER BY agent_id, hash, ts;
+
+DROP TABLE agents_inventory_partitioned;
+ALTER SEQUENCE agents_inventory_id_seq OWNED BY agents_inventory.id;
+
+CREATE INDEX IF NOT EXISTS idx_incidents_agent_id ON incidents(agent_id);
+CREATE INDEX IF NOT EXISTS idx_incidents_created_at ON incidents(created_at DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_agents_inventory_unique
+    ON agents_inventory(agent_id, hash);
+CREATE INDEX IF NOT EXISTS idx_agents_inventory_agent_ts
+    ON agents_inventory(agent_id, ts DESC);
+
+DROP FUNCTION IF EXISTS ensure_monthly_partition(TEXT, TIMESTAMPTZ);
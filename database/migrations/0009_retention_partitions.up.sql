@@ -0,0 +1,119 @@
+-- Monthly range partitions (UTC) for incidents and agents_inventory, the latest
+-- inventory snapshot per agent, and per-org retention policies.
+
+CREATE OR REPLACE FUNCTION ensure_monthly_partition(parent TEXT, month_of TIMESTAMPTZ) RETURNS TEXT AS $$
+DECLARE
+    start_at TIMESTAMPTZ := date_trunc('month', month_of AT TIME ZONE 'UTC') AT TIME ZONE 'UTC';
+    partition_name TEXT := parent || '_' || to_char(start_at AT TIME ZONE 'UTC', 'YYYYMM');
+BEGIN
+    EXECUTE format(
+        'CREATE TABLE IF NOT EXISTS %I PARTITION OF %I FOR VALUES FROM (%L) TO (%L)',
+        partition_name, parent, start_at, start_at + INTERVAL '1 month'
+    );
+    RETURN partition_name;
+END;
+$$ LANGUAGE plpgsql;
+
+-- incidents
+ALTER TABLE incidents RENAME TO incidents_unpartitioned;
+ALTER INDEX incidents_pkey RENAME TO incidents_unpartitioned_pkey;
+ALTER SEQUENCE incidents_id_seq OWNED BY NONE;
+
+CREATE TABLE incidents (
+    id INT NOT NULL DEFAULT nextval('incidents_id_seq'),
+    agent_id VARCHAR(12) REFERENCES agents(agent_id),
+    type VARCHAR(50),
+    source VARCHAR(255),
+    raw_error TEXT,
+    context JSONB,
+    ai_analysis TEXT,
+    is_critical BOOLEAN DEFAULT FALSE,
+    suggested_action JSONB,
+    status VARCHAR(20) DEFAULT 'new',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMPTZ,
+    PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+
+ALTER SEQUENCE incidents_id_seq OWNED BY incidents.id;
+
+-- agents_inventory
+ALTER TABLE agents_inventory RENAME TO agents_inventory_unpartitioned;
+ALTER INDEX agents_inventory_pkey RENAME TO agents_inventory_unpartitioned_pkey;
+ALTER SEQUENCE agents_inventory_id_seq OWNED BY NONE;
+
+CREATE TABLE agents_inventory (
+    id BIGINT NOT NULL DEFAULT nextval('agents_inventory_id_seq'),
+    agent_id TEXT NOT NULL REFERENCES agents(agent_id) ON DELETE CASCADE,
+    ts TIMESTAMPTZ NOT NULL DEFAULT now(),
+    hash TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    PRIMARY KEY (id, ts)
+) PARTITION BY RANGE (ts);
+
+ALTER SEQUENCE agents_inventory_id_seq OWNED BY agents_inventory.id;
+
+DO $$
+DECLARE
+    month_of TIMESTAMPTZ;
+BEGIN
+    FOR month_of IN
+        SELECT generate_series(
+            date_trunc('month', COALESCE(LEAST(
+                (SELECT MIN(created_at) FROM incidents_unpartitioned),
+                (SELECT MIN(ts) FROM agents_inventory_unpartitioned)
+            ), now()) AT TIME ZONE 'UTC') AT TIME ZONE 'UTC',
+            now() + INTERVAL '1 month',
+            INTERVAL '1 month'
+        )
+    LOOP
+        PERFORM ensure_monthly_partition('incidents', month_of);
+        PERFORM ensure_monthly_partition('agents_inventory', month_of);
+    END LOOP;
+END;
+$$;
+
+-- Catch-all so a missed partition never rejects writes; the retention worker
+-- keeps partitions created ahead of time.
+CREATE TABLE IF NOT EXISTS incidents_default PARTITION OF incidents DEFAULT;
+CREATE TABLE IF NOT EXISTS agents_inventory_default PARTITION OF agents_inventory DEFAULT;
+
+INSERT INTO incidents (id, agent_id, type, source, raw_error, context, ai_analysis,
+    is_critical, suggested_action, status, created_at)
+SELECT id, agent_id, type, source, raw_error, context, ai_analysis,
+    is_critical, suggested_action, status, COALESCE(created_at, now())
+FROM incidents_unpartitioned;
+
+INSERT INTO agents_inventory (id, agent_id, ts, hash, payload)
+SELECT id, agent_id, ts, hash, payload
+FROM agents_inventory_unpartitioned;
+
+DROP TABLE incidents_unpartitioned;
+DROP TABLE agents_inventory_unpartitioned;
+
+CREATE INDEX IF NOT EXISTS idx_incidents_id ON incidents(id);
+CREATE INDEX IF NOT EXISTS idx_incidents_agent_id ON incidents(agent_id);
+CREATE INDEX IF NOT EXISTS idx_incidents_created_at ON incidents(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_agents_inventory_agent_ts ON agents_inventory(agent_id, ts DESC);
+
+-- Latest snapshot per agent: never removed by retention.
+CREATE TABLE IF NOT EXISTS agents_inventory_latest (
+    agent_id TEXT PRIMARY KEY REFERENCES agents(agent_id) ON DELETE CASCADE,
+    ts TIMESTAMPTZ NOT NULL DEFAULT now(),
+    hash TEXT NOT NULL,
+    payload JSONB NOT NULL
+);
+
+INSERT INTO agents_inventory_latest (agent_id, ts, hash, payload)
+SELECT DISTINCT ON (agent_id) agent_id, ts, hash, payload
+FROM agents_inventory
+ORDER BY agent_id, ts DESC
+ON CONFLICT (agent_id) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS retention_policies (
+    org_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    resolved_incident_days INT NOT NULL DEFAULT 90 CHECK (resolved_incident_days > 0),
+    inventory_days INT NOT NULL DEFAULT 30 CHECK (inventory_days > 0),
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,14 @@
+-- Per-org configuration of inventory drift incidents.
+CREATE TABLE IF NOT EXISTS drift_policies (
+    org_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    new_ports BOOLEAN NOT NULL DEFAULT true,
+    removed_services BOOLEAN NOT NULL DEFAULT true,
+    kernel_change BOOLEAN NOT NULL DEFAULT true,
+    new_containers BOOLEAN NOT NULL DEFAULT true,
+    ignored_ports JSONB NOT NULL DEFAULT '[]',
+    allowed_containers JSONB NOT NULL DEFAULT '[]',
+    silenced_tags JSONB NOT NULL DEFAULT '[]',
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_inventory_latest_ram;
+DROP INDEX IF EXISTS idx_inventory_latest_platform;
+DROP INDEX IF EXISTS idx_inventory_latest_kernel;
+DROP INDEX IF EXISTS idx_inventory_latest_candidates;
@@ -0,0 +1,10 @@
+-- Indexes for fleet-wide queries over the latest inventory of each agent.
+-- Payload keys are the Go field names of models.Inventory.
+CREATE INDEX IF NOT EXISTS idx_inventory_latest_candidates
+    ON agents_inventory_latest USING GIN ((payload->'Candidates') jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS idx_inventory_latest_kernel
+    ON agents_inventory_latest ((payload->>'KernelVersion'));
+CREATE INDEX IF NOT EXISTS idx_inventory_latest_platform
+    ON agents_inventory_latest ((payload->>'Platform'));
+CREATE INDEX IF NOT EXISTS idx_inventory_latest_ram
+    ON agents_inventory_latest (((payload->>'RAMTotal')::bigint));
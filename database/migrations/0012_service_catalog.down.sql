@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS service_instances;
+DROP TABLE IF EXISTS services;
@@ -0,0 +1,32 @@
+-- Logical services clustered from inventory candidates across an org's fleet,
+-- and the per-agent instances they were seen on.
+CREATE TABLE IF NOT EXISTS services (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    org_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    key TEXT NOT NULL,
+    name TEXT NOT NULL,
+    type TEXT NOT NULL DEFAULT '',
+    owner TEXT,
+    runbook_url TEXT,
+    description TEXT,
+    first_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ,
+    UNIQUE (org_id, key)
+);
+
+CREATE TABLE IF NOT EXISTS service_instances (
+    service_id UUID NOT NULL REFERENCES services(id) ON DELETE CASCADE,
+    agent_id TEXT NOT NULL REFERENCES agents(agent_id) ON DELETE CASCADE,
+    candidate_key TEXT NOT NULL,
+    source_systemd TEXT NOT NULL DEFAULT '',
+    source_docker TEXT NOT NULL DEFAULT '',
+    listen_ports JSONB NOT NULL DEFAULT '[]',
+    first_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    removed_at TIMESTAMPTZ,
+    PRIMARY KEY (service_id, agent_id, candidate_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_service_instances_agent ON service_instances(agent_id) WHERE removed_at IS NULL;
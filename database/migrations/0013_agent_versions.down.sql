@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS agent_rollout_targets;
+DROP TABLE IF EXISTS agent_rollouts;
+DROP TABLE IF EXISTS agent_version_history;
+ALTER TABLE organizations DROP COLUMN IF EXISTS min_agent_version;
+ALTER TABLE agents DROP COLUMN IF EXISTS agent_version;
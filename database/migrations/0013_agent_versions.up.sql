@@ -0,0 +1,57 @@
+-- Agent version tracking, the org's minimum supported version and staged
+-- agent update rollouts.
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS agent_version TEXT;
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS min_agent_version TEXT;
+
+UPDATE agents SET agent_version = NULLIF(meta->>'agent_version', '')
+WHERE agent_version IS NULL AND meta ? 'agent_version';
+
+CREATE TABLE IF NOT EXISTS agent_version_history (
+    id BIGSERIAL PRIMARY KEY,
+    agent_id TEXT NOT NULL REFERENCES agents(agent_id) ON DELETE CASCADE,
+    version TEXT NOT NULL,
+    previous_version TEXT,
+    source TEXT NOT NULL,
+    seen_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_version_history_agent ON agent_version_history(agent_id, seen_at DESC);
+
+INSERT INTO agent_version_history (agent_id, version, source)
+SELECT agent_id, agent_version, 'migration' FROM agents
+WHERE agent_version IS NOT NULL
+    AND NOT EXISTS (SELECT 1 FROM agent_version_history h WHERE h.agent_id = agents.agent_id);
+
+CREATE TABLE IF NOT EXISTS agent_rollouts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    org_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    target_version TEXT NOT NULL,
+    download_url TEXT,
+    tags JSONB NOT NULL DEFAULT '[]',
+    batch_size INT NOT NULL CHECK (batch_size > 0),
+    wave_interval_seconds INT NOT NULL DEFAULT 300,
+    health_timeout_seconds INT NOT NULL DEFAULT 600,
+    max_failures INT NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'running',
+    current_wave INT NOT NULL DEFAULT -1,
+    wave_completed_at TIMESTAMPTZ,
+    error TEXT,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_rollouts_active ON agent_rollouts(status) WHERE status = 'running';
+
+CREATE TABLE IF NOT EXISTS agent_rollout_targets (
+    rollout_id UUID NOT NULL REFERENCES agent_rollouts(id) ON DELETE CASCADE,
+    agent_id TEXT NOT NULL REFERENCES agents(agent_id) ON DELETE CASCADE,
+    wave INT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    from_version TEXT,
+    sent_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ,
+    error TEXT,
+    PRIMARY KEY (rollout_id, agent_id)
+);
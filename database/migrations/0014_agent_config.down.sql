@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS agent_config_history;
+DROP TABLE IF EXISTS agent_configs;
+DROP TABLE IF EXISTS agent_config_overrides;
+DROP TABLE IF EXISTS config_templates;
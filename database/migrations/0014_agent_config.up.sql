@@ -0,0 +1,41 @@
+-- Desired agent configuration published to the AGENT_CONFIG KV bucket:
+-- org templates matched by tags, per-agent overrides and the versioned
+-- result per agent.
+CREATE TABLE IF NOT EXISTS config_templates (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    org_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    priority INT NOT NULL DEFAULT 0,
+    match_tags JSONB NOT NULL DEFAULT '[]',
+    config JSONB NOT NULL DEFAULT '{}',
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (org_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS agent_config_overrides (
+    agent_id TEXT PRIMARY KEY REFERENCES agents(agent_id) ON DELETE CASCADE,
+    config JSONB NOT NULL DEFAULT '{}',
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS agent_configs (
+    agent_id TEXT PRIMARY KEY REFERENCES agents(agent_id) ON DELETE CASCADE,
+    version BIGINT NOT NULL,
+    hash TEXT NOT NULL,
+    config JSONB NOT NULL,
+    published_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    applied_version BIGINT,
+    applied_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS agent_config_history (
+    agent_id TEXT NOT NULL REFERENCES agents(agent_id) ON DELETE CASCADE,
+    version BIGINT NOT NULL,
+    hash TEXT NOT NULL,
+    config JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (agent_id, version)
+);
@@ -0,0 +1,15 @@
+-- Events from agent IDs that are not enrolled in an organization. They are
+-- held here instead of creating placeholder agents and can be released as
+-- incidents once the agent is enrolled.
+CREATE TABLE IF NOT EXISTS quarantined_events (
+    id BIGSERIAL PRIMARY KEY,
+    agent_id VARCHAR(12) NOT NULL,
+    subject TEXT NOT NULL,
+    alert_type TEXT NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    payload BYTEA NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_quarantined_events_agent ON quarantined_events(agent_id, received_at DESC);
+CREATE INDEX IF NOT EXISTS idx_quarantined_events_received ON quarantined_events(received_at);
@@ -0,0 +1,16 @@
+DROP TRIGGER IF EXISTS incidents_claim_id ON incidents;
+DROP FUNCTION IF EXISTS claim_incident_id();
+DROP TABLE IF EXISTS incident_ids;
+
+CREATE OR REPLACE FUNCTION ensure_monthly_partition(parent TEXT, month_of TIMESTAMPTZ) RETURNS TEXT AS $$
+DECLARE
+    start_at TIMESTAMPTZ := date_trunc('month', month_of AT TIME ZONE 'UTC') AT TIME ZONE 'UTC';
+    partition_name TEXT := parent || '_' || to_char(start_at AT TIME ZONE 'UTC', 'YYYYMM');
+BEGIN
+    EXECUTE format(
+        'CREATE TABLE IF NOT EXISTS %I PARTITION OF %I FOR VALUES FROM (%L) TO (%L)',
+        partition_name, parent, start_at, start_at + INTERVAL '1 month'
+    );
+    RETURN partition_name;
+END;
+$$ LANGUAGE plpgsql;
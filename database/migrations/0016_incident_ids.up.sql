@@ -0,0 +1,69 @@
+-- incidents is partitioned by created_at, so its primary key (id, created_at)
+-- no longer keeps id unique on its own. incident_ids holds the id of every
+-- stored incident and rejects duplicates; the retention worker prunes the ids
+-- of removed incidents.
+CREATE TABLE IF NOT EXISTS incident_ids (
+    id INT PRIMARY KEY
+);
+
+INSERT INTO incident_ids (id)
+SELECT id FROM incidents
+ON CONFLICT (id) DO NOTHING;
+
+CREATE OR REPLACE FUNCTION claim_incident_id() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO incident_ids (id) VALUES (NEW.id);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS incidents_claim_id ON incidents;
+CREATE TRIGGER incidents_claim_id AFTER INSERT ON incidents
+    FOR EACH ROW EXECUTE FUNCTION claim_incident_id();
+
+-- Rows written while a month had no partition land in the default partition,
+-- and CREATE TABLE ... PARTITION OF fails while it holds rows of that month.
+-- Such rows are moved into a standalone table that is then attached.
+CREATE OR REPLACE FUNCTION ensure_monthly_partition(parent TEXT, month_of TIMESTAMPTZ) RETURNS TEXT AS $$
+DECLARE
+    start_at TIMESTAMPTZ := date_trunc('month', month_of AT TIME ZONE 'UTC') AT TIME ZONE 'UTC';
+    end_at TIMESTAMPTZ := start_at + INTERVAL '1 month';
+    partition_name TEXT := parent || '_' || to_char(start_at AT TIME ZONE 'UTC', 'YYYYMM');
+    default_name TEXT := parent || '_default';
+    key_column TEXT;
+    stranded BOOLEAN := false;
+BEGIN
+    IF to_regclass(partition_name) IS NOT NULL THEN
+        RETURN partition_name;
+    END IF;
+
+    SELECT a.attname INTO key_column
+    FROM pg_partitioned_table pt
+    JOIN pg_attribute a ON a.attrelid = pt.partrelid AND a.attnum = pt.partattrs[0]
+    WHERE pt.partrelid = to_regclass(parent);
+
+    IF to_regclass(default_name) IS NOT NULL THEN
+        EXECUTE format('SELECT EXISTS (SELECT 1 FROM %I WHERE %I >= %L AND %I < %L)',
+            default_name, key_column, start_at, key_column, end_at) INTO stranded;
+    END IF;
+
+    IF NOT stranded THEN
+        EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS %I PARTITION OF %I FOR VALUES FROM (%L) TO (%L)',
+            partition_name, parent, start_at, end_at
+        );
+        RETURN partition_name;
+    END IF;
+
+    EXECUTE format('CREATE TABLE %I (LIKE %I INCLUDING DEFAULTS INCLUDING CONSTRAINTS)', partition_name, parent);
+    EXECUTE format(
+        'WITH moved AS (DELETE FROM %I WHERE %I >= %L AND %I < %L RETURNING *) INSERT INTO %I SELECT * FROM moved',
+        default_name, key_column, start_at, key_column, end_at, partition_name
+    );
+    EXECUTE format(
+        'ALTER TABLE %I ATTACH PARTITION %I FOR VALUES FROM (%L) TO (%L)',
+        parent, partition_name, start_at, end_at
+    );
+    RETURN partition_name;
+END;
+$$ LANGUAGE plpgsql;
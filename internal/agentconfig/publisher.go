@@ -0,0 +1,230 @@
+// Package agentconfig derives each agent's desired configuration from org
+// templates and per-agent overrides and publishes it to the AGENT_CONFIG KV
+// bucket.
+package agentconfig
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/nats-io/nats.go"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"opspilot-backend/internal/models"
+	"opspilot-backend/internal/storage"
+)
+
+// ErrNoOrg is returned for agents that do not belong to an org (legacy
+// agents); they have no templates to derive a config from.
+var ErrNoOrg = errors.New("agent has no org")
+
+type Publisher struct {
+	store *storage.Storage
+	kv    nats.KeyValue
+}
+
+func NewPublisher(store *storage.Storage, kv nats.KeyValue) *Publisher {
+	return &Publisher{store: store, kv: kv}
+}
+
+// Publish recomputes the agent's config and, when it changed, stores a new
+// version and writes it to the KV bucket. Unchanged configs are rewritten to
+// the bucket only when the entry is missing.
+func (p *Publisher) Publish(ctx context.Context, agentID string) (*models.AgentConfigState, error) {
+	agent, err := p.store.GetAgentByAgentID(ctx, agentID)
+	if err != nil {
+		return nil, err
+	}
+	if agent == nil || agent.OrgID == "" {
+		return nil, ErrNoOrg
+	}
+
+	templates, err := p.store.ListConfigTemplates(ctx, agent.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	override, err := p.store.GetAgentConfigOverride(ctx, agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.publish(ctx, agentID, Resolve(templates, agent.Tags, override)); err != nil {
+		return nil, err
+	}
+
+	state, err := p.store.GetAgentConfigState(ctx, agentID)
+	if err != nil {
+		return nil, err
+	}
+	state.Override = override
+	return state, nil
+}
+
+// PublishOrg publishes every agent of the org that gets a config (not
+// pending or rejected) and returns how many failed. Templates and agents are
+// loaded once; agents whose config is unchanged and applied are skipped
+// without touching the database or the bucket.
+func (p *Publisher) PublishOrg(ctx context.Context, orgID string) int {
+	templates, err := p.store.ListConfigTemplates(ctx, orgID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Agent config list templates", "org_id", orgID, "err", err)
+		return 0
+	}
+	targets, err := p.store.ListConfigTargets(ctx, orgID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Agent config list agents", "org_id", orgID, "err", err)
+		return 0
+	}
+
+	failed := 0
+	for _, target := range targets {
+		config := Resolve(templates, target.Tags, target.Override)
+		hash, err := Hash(config)
+		if err == nil && hash == target.Hash && target.InSync {
+			continue
+		}
+		if err == nil {
+			err = p.publish(ctx, target.AgentID, config)
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Agent config publish", "agent_id", target.AgentID, "org_id", orgID, "err", err)
+			failed++
+		}
+	}
+	return failed
+}
+
+// PublishAll publishes the agents of every org. Template and override
+// changes are published when they happen; this periodic pass picks up new
+// agents and tag changes from re-enrollment.
+func (p *Publisher) PublishAll(ctx context.Context) int {
+	orgIDs, err := p.store.ListConfigTargetOrgIDs(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Agent config list orgs", "err", err)
+		return 0
+	}
+	failed := 0
+	for _, orgID := range orgIDs {
+		failed += p.PublishOrg(ctx, orgID)
+	}
+	return failed
+}
+
+// publish stores config as the agent's desired config and writes it to the
+// bucket when it changed or the bucket does not hold the current version.
+func (p *Publisher) publish(ctx context.Context, agentID string, config models.AgentConfig) error {
+	hash, err := Hash(config)
+	if err != nil {
+		return err
+	}
+
+	version, changed, err := p.store.SaveAgentConfig(ctx, agentID, hash, config)
+	if err != nil {
+		return err
+	}
+	if !changed && p.published(agentID, version) {
+		return nil
+	}
+
+	payload, err := msgpack.Marshal(&models.DesiredConfig{V: 1, AgentID: agentID, Version: version, Config: config})
+	if err != nil {
+		return err
+	}
+	if _, err := p.kv.Put(agentID, payload); err != nil {
+		return fmt.Errorf("put %s config: %w", agentID, err)
+	}
+	slog.InfoContext(ctx, "Agent config published", "agent_id", agentID, "version", version)
+	return nil
+}
+
+// published reports whether the bucket already holds version for the agent.
+func (p *Publisher) published(agentID string, version int64) bool {
+	entry, err := p.kv.Get(agentID)
+	if err != nil {
+		return false
+	}
+	var current models.DesiredConfig
+	if err := msgpack.Unmarshal(entry.Value(), &current); err != nil {
+		return false
+	}
+	return current.Version == version
+}
+
+// Resolve merges the templates matching tags by ascending priority, then the
+// override. Lists are unioned; thresholds of later layers win.
+func Resolve(templates []models.ConfigTemplate, tags []string, override *models.AgentConfig) models.AgentConfig {
+	ordered := make([]models.ConfigTemplate, len(templates))
+	copy(ordered, templates)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Priority < ordered[j].Priority })
+
+	var config models.AgentConfig
+	for _, t := range ordered {
+		if matches(t.MatchTags, tags) {
+			merge(&config, t.Config)
+		}
+	}
+	if override != nil {
+		merge(&config, *override)
+	}
+	return config
+}
+
+// Hash identifies a resolved config; lists are compared as sets.
+func Hash(config models.AgentConfig) (string, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func matches(required, tags []string) bool {
+	for _, r := range required {
+		found := false
+		for _, t := range tags {
+			if t == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func merge(dst *models.AgentConfig, src models.AgentConfig) {
+	dst.Services = unionSorted(dst.Services, src.Services)
+	dst.Containers = unionSorted(dst.Containers, src.Containers)
+	dst.LogPaths = unionSorted(dst.LogPaths, src.LogPaths)
+	for name, value := range src.Thresholds {
+		if dst.Thresholds == nil {
+			dst.Thresholds = make(map[string]float64)
+		}
+		dst.Thresholds[name] = value
+	}
+}
+
+func unionSorted(a, b []string) []string {
+	if len(b) == 0 {
+		return a
+	}
+	seen := make(map[string]bool, len(a)+len(b))
+	out := make([]string, 0, len(a)+len(b))
+	for _, v := range append(append([]string{}, a...), b...) {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,90 @@
+// Package agentversion compares agent version strings.
+package agentversion
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Compare orders two versions such as "1.4.2", "v1.10.0" or "1.5.0-rc1":
+// numeric dot-separated parts are compared numerically, a missing part
+// counts as 0 and a pre-release suffix sorts before the release. It returns
+// -1, 0 or 1.
+func Compare(a, b string) int {
+	aCore, aPre := split(a)
+	bCore, bPre := split(b)
+
+	for i := 0; i < len(aCore) || i < len(bCore); i++ {
+		x, y := part(aCore, i), part(bCore, i)
+		if c := comparePart(x, y); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	case aPre < bPre:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// Outdated reports whether version is below min. An empty min or version
+// never counts as outdated.
+func Outdated(version, min string) bool {
+	if version == "" || min == "" {
+		return false
+	}
+	return Compare(version, min) < 0
+}
+
+// Valid reports whether v starts with a numeric version core.
+func Valid(v string) bool {
+	core, _ := split(v)
+	for _, p := range core {
+		if _, err := strconv.Atoi(p); err != nil {
+			return false
+		}
+	}
+	return len(core) > 0 && core[0] != ""
+}
+
+func split(v string) ([]string, string) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	pre := ""
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		v, pre = v[:i], v[i+1:]
+	}
+	return strings.Split(v, "."), pre
+}
+
+func part(parts []string, i int) string {
+	if i < len(parts) {
+		return parts[i]
+	}
+	return "0"
+}
+
+func comparePart(x, y string) int {
+	xn, xErr := strconv.Atoi(x)
+	yn, yErr := strconv.Atoi(y)
+	if xErr == nil && yErr == nil {
+		switch {
+		case xn < yn:
+			return -1
+		case xn > yn:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(x, y)
+}
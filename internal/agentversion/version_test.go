@@ -0,0 +1,65 @@
+package agentversion
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.4.2", "1.4.2", 0},
+		{"v1.4.2", "1.4.2", 0},
+		{"1.4", "1.4.0", 0},
+		{"1.4.2+build.7", "1.4.2", 0},
+		{"1.4.2", "1.4.10", -1},
+		{"1.10.0", "1.9.9", 1},
+		{"2", "1.99.99", 1},
+		{"1.5.0-rc1", "1.5.0", -1},
+		{"1.5.0", "1.5.0-rc1", 1},
+		{"1.5.0-rc1", "1.5.0-rc2", -1},
+		{"1.5.0-rc1", "1.4.9", 1},
+		{" 1.0.0 ", "1.0.0", 0},
+	}
+	for _, tt := range tests {
+		if got := Compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestOutdated(t *testing.T) {
+	tests := []struct {
+		version, min string
+		want         bool
+	}{
+		{"1.2.0", "1.3.0", true},
+		{"1.3.0", "1.3.0", false},
+		{"1.4.0", "1.3.0", false},
+		{"", "1.3.0", false},
+		{"1.2.0", "", false},
+	}
+	for _, tt := range tests {
+		if got := Outdated(tt.version, tt.min); got != tt.want {
+			t.Errorf("Outdated(%q, %q) = %v, want %v", tt.version, tt.min, got, tt.want)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		v    string
+		want bool
+	}{
+		{"1.4.2", true},
+		{"v2", true},
+		{"1.5.0-rc1", true},
+		{"", false},
+		{"dev", false},
+		{"1.x", false},
+	}
+	for _, tt := range tests {
+		if got := Valid(tt.v); got != tt.want {
+			t.Errorf("Valid(%q) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
@@ -49,7 +49,7 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 
 	var user models.User
 	query := `SELECT id, org_id, email, password_hash, created_at FROM users WHERE email=$1`
-	if err := h.db.Get(&user, query, req.Email); err != nil {
+	if err := h.db.GetContext(r.Context(), &user, query, req.Email); err != nil {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
@@ -108,7 +108,7 @@ func (h *Handler) Me(w http.ResponseWriter, r *http.Request) {
 
 	var user models.User
 	query := `SELECT id, org_id, email, created_at FROM users WHERE id=$1`
-	if err := h.db.Get(&user, query, userID); err != nil {
+	if err := h.db.GetContext(r.Context(), &user, query, userID); err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
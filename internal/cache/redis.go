@@ -19,6 +19,7 @@ type Client interface {
 	IncrWithTTL(key string, ttl time.Duration) (int64, error)
 	Get(key string) (string, error)
 	Set(key string, value string, ttl time.Duration) error
+	SetNX(key string, value string, ttl time.Duration) (bool, error)
 	Del(key string) error
 	Close() error
 }
@@ -132,6 +133,14 @@ func (c *RedisCache) Set(key string, value string, ttl time.Duration) error {
 	return c.rdb.Set(ctx, key, value, ttl).Err()
 }
 
+// SetNX sets key only if it does not exist. Returns true when the key was set.
+func (c *RedisCache) SetNX(key string, value string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	return c.rdb.SetNX(ctx, key, value, ttl).Result()
+}
+
 func (c *RedisCache) Del(key string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
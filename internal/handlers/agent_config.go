@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"opspilot-backend/internal/models"
+	"opspilot-backend/internal/storage"
+)
+
+// GET /api/v1/config-templates
+func (h *Handler) ListConfigTemplates(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	templates, err := h.storage.ListConfigTemplates(r.Context(), user.OrgID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "config templates: list", "org_id", user.OrgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to list config templates")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, templates)
+}
+
+// POST /api/v1/config-templates
+func (h *Handler) CreateConfigTemplate(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	input, ok := decodeConfigTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	template, err := h.storage.CreateConfigTemplate(r.Context(), user.OrgID, user.ID, input)
+	if errors.Is(err, storage.ErrTemplateNameTaken) {
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "config templates: create", "org_id", user.OrgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to create config template")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Config template created", "id", template.ID, "org_id", user.OrgID, "name", template.Name, "user_id", user.ID)
+	h.republishOrg(r.Context(), user.OrgID)
+	respondJSON(w, http.StatusCreated, template)
+}
+
+// PUT /api/v1/config-templates/{id}
+func (h *Handler) UpdateConfigTemplate(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	templateID := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(templateID); err != nil {
+		respondError(w, http.StatusNotFound, "config template not found")
+		return
+	}
+	input, ok := decodeConfigTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	template, err := h.storage.UpdateConfigTemplate(r.Context(), user.OrgID, templateID, user.ID, input)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusNotFound, "config template not found")
+		return
+	}
+	if errors.Is(err, storage.ErrTemplateNameTaken) {
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "config templates: update", "id", templateID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to update config template")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Config template updated", "id", template.ID, "org_id", user.OrgID, "user_id", user.ID)
+	h.republishOrg(r.Context(), user.OrgID)
+	respondJSON(w, http.StatusOK, template)
+}
+
+// DELETE /api/v1/config-templates/{id}
+func (h *Handler) DeleteConfigTemplate(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	templateID := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(templateID); err != nil {
+		respondError(w, http.StatusNotFound, "config template not found")
+		return
+	}
+
+	err := h.storage.DeleteConfigTemplate(r.Context(), user.OrgID, templateID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusNotFound, "config template not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "config templates: delete", "id", templateID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to delete config template")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Config template deleted", "id", templateID, "org_id", user.OrgID, "user_id", user.ID)
+	h.republishOrg(r.Context(), user.OrgID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /api/v1/agents/{id}/config
+// Returns the desired config, the version the agent applied and whether they match.
+func (h *Handler) GetAgentConfig(w http.ResponseWriter, r *http.Request) {
+	agent, ok := h.orgAgent(w, r)
+	if !ok {
+		return
+	}
+
+	state, err := h.storage.GetAgentConfigState(r.Context(), agent.AgentID)
+	if err == nil && state != nil {
+		state.Override, err = h.storage.GetAgentConfigOverride(r.Context(), agent.AgentID)
+	}
+	if err == nil && state == nil && h.configs != nil {
+		state, err = h.configs.Publish(r.Context(), agent.AgentID)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "agent config: load", "agent_id", agent.AgentID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load agent config")
+		return
+	}
+	if state == nil {
+		respondError(w, http.StatusNotFound, "agent config not published")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, state)
+}
+
+// PUT /api/v1/agents/{id}/config
+// Sets the agent-specific override merged over the templates; a null body
+// removes it.
+func (h *Handler) UpdateAgentConfig(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+	agent, ok := h.orgAgent(w, r)
+	if !ok {
+		return
+	}
+	if h.configs == nil {
+		respondError(w, http.StatusServiceUnavailable, "agent config publishing is not configured")
+		return
+	}
+
+	var override *models.AgentConfig
+	if err := json.NewDecoder(r.Body).Decode(&override); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if override != nil && !validAgentConfig(*override) {
+		respondError(w, http.StatusBadRequest, "threshold names must not be empty")
+		return
+	}
+
+	if err := h.storage.SetAgentConfigOverride(r.Context(), agent.AgentID, user.ID, override); err != nil {
+		slog.ErrorContext(r.Context(), "agent config: override", "agent_id", agent.AgentID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to update agent config")
+		return
+	}
+
+	state, err := h.configs.Publish(r.Context(), agent.AgentID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "agent config: publish", "agent_id", agent.AgentID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to publish agent config")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Agent config override updated", "agent_id", agent.AgentID, "version", state.Version, "user_id", user.ID)
+	respondJSON(w, http.StatusOK, state)
+}
+
+// GET /api/v1/agents/{id}/config/history
+func (h *Handler) ListAgentConfigHistory(w http.ResponseWriter, r *http.Request) {
+	agent, ok := h.orgAgent(w, r)
+	if !ok {
+		return
+	}
+
+	history, err := h.storage.ListAgentConfigHistory(r.Context(), agent.AgentID, 50)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "agent config: history", "agent_id", agent.AgentID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to list agent config history")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, history)
+}
+
+// republishTimeout bounds a background republish of an org's agent configs.
+const republishTimeout = 2 * time.Minute
+
+// republishOrg recomputes the configs of the org's agents in the background
+// after a template change. It keeps the request's trace and log attributes
+// but not its cancellation, and gives up after republishTimeout.
+func (h *Handler) republishOrg(ctx context.Context, orgID string) {
+	if h.configs == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), republishTimeout)
+	go func() {
+		defer cancel()
+		if failed := h.configs.PublishOrg(ctx, orgID); failed > 0 {
+			slog.WarnContext(ctx, "Agent config republish", "org_id", orgID, "failed", failed)
+		}
+	}()
+}
+
+func decodeConfigTemplate(w http.ResponseWriter, r *http.Request) (models.ConfigTemplateInput, bool) {
+	var input models.ConfigTemplateInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request")
+		return input, false
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		respondError(w, http.StatusBadRequest, "name is required")
+		return input, false
+	}
+	if !validAgentConfig(input.Config) {
+		respondError(w, http.StatusBadRequest, "threshold names must not be empty")
+		return input, false
+	}
+	return input, true
+}
+
+func validAgentConfig(config models.AgentConfig) bool {
+	for name := range config.Thresholds {
+		if strings.TrimSpace(name) == "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"opspilot-backend/internal/natsbus"
+)
+
+type deadLetterDetail struct {
+	*natsbus.DeadLetter
+	// Payload is the msgpack body decoded for reading, when it decodes.
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+type deadLettersPage struct {
+	DeadLetters []natsbus.DeadLetter `json:"dead_letters"`
+	// NextBefore is the before of the next page; it is omitted once the
+	// whole DLQ was scanned. A page may be short or empty while it is set.
+	NextBefore uint64 `json:"next_before,omitempty"`
+}
+
+type replayDeadLettersRequest struct {
+	Seqs []uint64 `json:"seqs"`
+}
+
+type replayFailure struct {
+	Seq   uint64 `json:"seq"`
+	Error string `json:"error"`
+}
+
+type replayDeadLettersResponse struct {
+	Replayed []uint64        `json:"replayed"`
+	Failed   []replayFailure `json:"failed"`
+}
+
+// GET /api/v1/dlq?agent_id=&before=&limit=50
+// Lists dead letters of the org's agents, newest first. Pass next_before as
+// before to page.
+func (h *Handler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+	if h.dlq == nil {
+		respondError(w, http.StatusServiceUnavailable, "dead letter queue is not configured")
+		return
+	}
+
+	query := r.URL.Query()
+	limit := 50
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 500 {
+			respondError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = parsed
+	}
+	var before uint64
+	if value := query.Get("before"); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid before")
+			return
+		}
+		before = parsed
+	}
+	agentID := query.Get("agent_id")
+
+	agents, ok := h.orgAgentSet(w, r, user.OrgID)
+	if !ok {
+		return
+	}
+
+	letters, next, err := h.dlq.List(before, limit, func(letter *natsbus.DeadLetter) bool {
+		return agents[letter.AgentID] && (agentID == "" || letter.AgentID == agentID)
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "dlq: list", "org_id", user.OrgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to list dead letters")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, deadLettersPage{DeadLetters: letters, NextBefore: next})
+}
+
+// GET /api/v1/dlq/{seq}
+func (h *Handler) GetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+	if h.dlq == nil {
+		respondError(w, http.StatusServiceUnavailable, "dead letter queue is not configured")
+		return
+	}
+
+	seq, err := strconv.ParseUint(chi.URLParam(r, "seq"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "dead letter not found")
+		return
+	}
+	agents, ok := h.orgAgentSet(w, r, user.OrgID)
+	if !ok {
+		return
+	}
+
+	letter, err := h.dlq.Get(seq)
+	if errors.Is(err, natsbus.ErrDeadLetterNotFound) || (err == nil && !agents[letter.AgentID]) {
+		respondError(w, http.StatusNotFound, "dead letter not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "dlq: get", "seq", seq, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load dead letter")
+		return
+	}
+
+	detail := deadLetterDetail{DeadLetter: letter}
+	var payload interface{}
+	if msgpack.Unmarshal(letter.Data, &payload) == nil {
+		detail.Payload = payload
+	}
+	respondJSON(w, http.StatusOK, detail)
+}
+
+// POST /api/v1/dlq/replay
+// Re-publishes the given dead letters to their original subjects and removes
+// them from the DLQ.
+func (h *Handler) ReplayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+	if h.dlq == nil {
+		respondError(w, http.StatusServiceUnavailable, "dead letter queue is not configured")
+		return
+	}
+
+	var req replayDeadLettersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if len(req.Seqs) == 0 || len(req.Seqs) > 500 {
+		respondError(w, http.StatusBadRequest, "seqs must list 1 to 500 dead letters")
+		return
+	}
+	agents, ok := h.orgAgentSet(w, r, user.OrgID)
+	if !ok {
+		return
+	}
+
+	resp := replayDeadLettersResponse{Replayed: []uint64{}, Failed: []replayFailure{}}
+	for _, seq := range req.Seqs {
+		letter, err := h.dlq.Get(seq)
+		if errors.Is(err, natsbus.ErrDeadLetterNotFound) || (err == nil && !agents[letter.AgentID]) {
+			resp.Failed = append(resp.Failed, replayFailure{Seq: seq, Error: "not found"})
+			continue
+		}
+		if err == nil {
+			err = h.dlq.Replay(letter)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "dlq: replay", "seq", seq, "err", err)
+			resp.Failed = append(resp.Failed, replayFailure{Seq: seq, Error: "replay failed"})
+			continue
+		}
+		resp.Replayed = append(resp.Replayed, seq)
+	}
+
+	slog.InfoContext(r.Context(), "Dead letters replayed", "org_id", user.OrgID, "replayed", len(resp.Replayed), "failed", len(resp.Failed), "user_id", user.ID)
+	respondJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) orgAgentSet(w http.ResponseWriter, r *http.Request, orgID string) (map[string]bool, bool) {
+	ids, err := h.storage.ListOrgAgentIDs(r.Context(), orgID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "dlq: list agents", "org_id", orgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load agents")
+		return nil, false
+	}
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set, true
+}
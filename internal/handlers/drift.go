@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"path"
+
+	"opspilot-backend/internal/models"
+)
+
+// GET /api/v1/drift-policy
+func (h *Handler) GetDriftPolicy(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	policy, err := h.storage.GetDriftPolicy(r.Context(), user.OrgID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "drift: load", "org_id", user.OrgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load drift policy")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, policy)
+}
+
+// PUT /api/v1/drift-policy
+func (h *Handler) UpdateDriftPolicy(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateDriftPolicyInput
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	for _, port := range req.IgnoredPorts {
+		if port < 1 || port > 65535 {
+			respondError(w, http.StatusBadRequest, "ignored_ports must be between 1 and 65535")
+			return
+		}
+	}
+	for _, pattern := range req.AllowedContainers {
+		if _, err := path.Match(pattern, ""); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid allowed_containers pattern: "+pattern)
+			return
+		}
+	}
+
+	policy, err := h.storage.UpsertDriftPolicy(r.Context(), user.OrgID, user.ID, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "drift: update", "org_id", user.OrgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to update drift policy")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Drift policy updated", "org_id", user.OrgID, "enabled", policy.Enabled, "user_id", user.ID)
+	respondJSON(w, http.StatusOK, policy)
+}
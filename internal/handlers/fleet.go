@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"opspilot-backend/internal/models"
+	"opspilot-backend/internal/storage"
+)
+
+// GET /api/v1/inventory/query?port=5432&process=redis&source=docker&max_ram=4GB
+func (h *Handler) QueryFleet(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	q, err := parseFleetQuery(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hosts, err := h.storage.QueryFleet(r.Context(), user.OrgID, q)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "fleet: query", "org_id", user.OrgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to query inventory")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, hosts)
+}
+
+// GET /api/v1/inventory/facets?field=kernel_version
+func (h *Handler) FleetFacets(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	q, err := parseFleetQuery(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	field := strings.TrimSpace(r.URL.Query().Get("field"))
+	facets, err := h.storage.FleetFacets(r.Context(), user.OrgID, field, q)
+	if errors.Is(err, storage.ErrInvalidFacet) {
+		respondError(w, http.StatusBadRequest, "field must be one of platform, platform_version, kernel_version, cpu_model")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "fleet: facets", "org_id", user.OrgID, "field", field, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to query inventory")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, facets)
+}
+
+func parseFleetQuery(values url.Values) (models.FleetQuery, error) {
+	q := models.FleetQuery{
+		Process:         strings.TrimSpace(values.Get("process")),
+		Source:          strings.ToLower(strings.TrimSpace(values.Get("source"))),
+		Platform:        strings.TrimSpace(values.Get("platform")),
+		PlatformVersion: strings.TrimSpace(values.Get("platform_version")),
+		KernelVersion:   strings.TrimSpace(values.Get("kernel_version")),
+		Limit:           100,
+	}
+
+	if v := values.Get("port"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil || port < 1 || port > 65535 {
+			return q, errors.New("invalid port")
+		}
+		q.Port = port
+	}
+	if q.Source != "" && q.Source != models.CandidateSourceDocker && q.Source != models.CandidateSourceSystemd {
+		return q, errors.New("source must be docker or systemd")
+	}
+	var err error
+	if q.MinRAM, err = parseByteSize(values.Get("min_ram")); err != nil {
+		return q, errors.New("invalid min_ram")
+	}
+	if q.MaxRAM, err = parseByteSize(values.Get("max_ram")); err != nil {
+		return q, errors.New("invalid max_ram")
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > 1000 {
+			return q, errors.New("invalid limit")
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+// parseByteSize accepts plain bytes or a KB/MB/GB/TB suffix (powers of 1024).
+func parseByteSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid size")
+	}
+	return int64(n * float64(multiplier)), nil
+}
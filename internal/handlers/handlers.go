@@ -6,9 +6,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,11 +19,15 @@ import (
 	"github.com/nats-io/nats.go"
 	"github.com/swaggo/http-swagger/v2"
 	_ "opspilot-backend/docs" // swagger docs
+	"opspilot-backend/internal/agentconfig"
 	"opspilot-backend/internal/auth"
 	"opspilot-backend/internal/cache"
+	"opspilot-backend/internal/inventory"
 	rl "opspilot-backend/internal/middleware"
 	"opspilot-backend/internal/models"
 	"opspilot-backend/internal/natsauth"
+	"opspilot-backend/internal/natsbus"
+	"opspilot-backend/internal/protocol"
 	"opspilot-backend/internal/rpc"
 	"opspilot-backend/internal/services"
 	"opspilot-backend/internal/storage"
@@ -35,9 +40,13 @@ type Handler struct {
 	slackClient *services.SlackClient
 	rpc         *rpc.Client
 	cache       cache.Client
+	issuer      *natsauth.JWTIssuer
+	accounts    *natsauth.AccountManager
+	configs     *agentconfig.Publisher
+	dlq         *natsbus.DeadLetters
 }
 
-func New(storage *storage.Storage, db *sqlx.DB, ai *services.OpenRouterClient, slack *services.SlackClient, rpcClient *rpc.Client, cacheClient cache.Client) *Handler {
+func New(storage *storage.Storage, db *sqlx.DB, ai *services.OpenRouterClient, slack *services.SlackClient, rpcClient *rpc.Client, cacheClient cache.Client, issuer *natsauth.JWTIssuer, accounts *natsauth.AccountManager, configs *agentconfig.Publisher, dlq *natsbus.DeadLetters) *Handler {
 	return &Handler{
 		storage:     storage,
 		db:          db,
@@ -45,6 +54,10 @@ func New(storage *storage.Storage, db *sqlx.DB, ai *services.OpenRouterClient, s
 		slackClient: slack,
 		rpc:         rpcClient,
 		cache:       cacheClient,
+		issuer:      issuer,
+		accounts:    accounts,
+		configs:     configs,
+		dlq:         dlq,
 	}
 }
 
@@ -69,16 +82,12 @@ func New(storage *storage.Storage, db *sqlx.DB, ai *services.OpenRouterClient, s
 
 func (h *Handler) RegisterRoutes(r chi.Router) {
 	authHandler := auth.NewHandler(h.db)
-	issuer, err := natsauth.NewJWTIssuer(
-		os.Getenv("NATS_SIGNING_KEY_SEED"),
-		os.Getenv("NATS_AGENTS_ACCOUNT_PUBLIC_KEY"),
-	)
-	if err != nil {
-		log.Printf("WARN NATS JWT issuer disabled: %v", err)
-	}
-	credsHandler := natsauth.NewHandler(h.db, h.storage, issuer)
-	enrollmentHandler := natsauth.NewEnrollmentHandler(h.storage, issuer, natsauth.EnrollmentConfig{
-		NATSURLs: getNATSURLs(),
+	natsURLs := getNATSURLs()
+	nonces := natsauth.NewNonceStore(h.cache)
+	credsHandler := natsauth.NewHandler(h.db, h.storage, h.issuer, h.accounts, nonces, natsURLs)
+	enrollmentHandler := natsauth.NewEnrollmentHandler(h.storage, h.issuer, nonces, natsauth.NewFingerprintMonitor(h.storage), natsauth.EnrollmentConfig{
+		NATSURLs:               natsURLs,
+		BlockFingerprintChange: natsauth.BlockFingerprintChangeFromEnv(),
 	})
 
 	// Swagger UI
@@ -95,18 +104,74 @@ func (h *Handler) RegisterRoutes(r chi.Router) {
 		// Slack (stubbed)
 		r.Post("/slack/interactive", h.HandleSlackInteractive)
 
+		// Agent protocol versions (public, read by agents)
+		r.Get("/protocol", h.GetProtocol)
+
 		// Public enrollment endpoint
 		r.With(rl.RateLimitEnrollIP(h.cache), rl.RateLimitEnrollToken(h.cache)).Post("/agents/enroll", enrollmentHandler.EnrollAgent)
+		r.With(rl.RateLimitEnrollIP(h.cache)).Post("/agents/enroll/status", enrollmentHandler.EnrollmentStatus)
 
 		// Protected API
 		r.With(auth.Middleware).Group(func(r chi.Router) {
 			r.Get("/events/stream", credsHandler.EventStream)
+			r.Get("/security-events", credsHandler.ListSecurityEvents)
+			r.Get("/organization", credsHandler.GetOrganization)
+			r.Patch("/organization", credsHandler.UpdateOrganization)
+			r.Get("/retention-policy", h.GetRetentionPolicy)
+			r.Put("/retention-policy", h.UpdateRetentionPolicy)
+			r.Get("/drift-policy", h.GetDriftPolicy)
+			r.Put("/drift-policy", h.UpdateDriftPolicy)
+			r.Get("/inventory/query", h.QueryFleet)
+			r.Get("/inventory/facets", h.FleetFacets)
+
+			r.Get("/agent-versions", h.ListAgentVersions)
+			r.Route("/rollouts", func(r chi.Router) {
+				r.Get("/", h.ListRollouts)
+				r.Post("/", h.CreateRollout)
+				r.Get("/{id}", h.GetRollout)
+				r.Post("/{id}/pause", h.PauseRollout)
+				r.Post("/{id}/resume", h.ResumeRollout)
+				r.Post("/{id}/cancel", h.CancelRollout)
+			})
+
+			r.Route("/services", func(r chi.Router) {
+				r.Get("/", h.ListServices)
+				r.Get("/{id}", h.GetService)
+				r.Patch("/{id}", h.UpdateService)
+				r.Get("/{id}/incidents", h.ListServiceIncidents)
+			})
+
+			r.Route("/dlq", func(r chi.Router) {
+				r.Get("/", h.ListDeadLetters)
+				r.Post("/replay", h.ReplayDeadLetters)
+				r.Get("/{seq}", h.GetDeadLetter)
+			})
+
+			r.Route("/quarantine", func(r chi.Router) {
+				r.Get("/", h.ListQuarantinedEvents)
+				r.Post("/{id}/release", h.ReleaseQuarantinedEvent)
+				r.Delete("/{id}", h.DeleteQuarantinedEvent)
+			})
+
+			r.Route("/config-templates", func(r chi.Router) {
+				r.Get("/", h.ListConfigTemplates)
+				r.Post("/", h.CreateConfigTemplate)
+				r.Put("/{id}", h.UpdateConfigTemplate)
+				r.Delete("/{id}", h.DeleteConfigTemplate)
+			})
 
 			r.Route("/bootstrap-tokens", func(r chi.Router) {
 				r.Get("/", credsHandler.ListBootstrapTokens)
 				r.Post("/", credsHandler.CreateBootstrapToken)
 				r.Get("/{id}", credsHandler.GetBootstrapToken)
 				r.Delete("/{id}", credsHandler.RevokeBootstrapToken)
+				r.Post("/{id}/installer", credsHandler.RenderInstaller)
+			})
+
+			r.Route("/agents/enrollments", func(r chi.Router) {
+				r.Get("/", credsHandler.ListEnrollments)
+				r.Post("/{id}/approve", credsHandler.ApproveEnrollment)
+				r.Post("/{id}/reject", credsHandler.RejectEnrollment)
 			})
 
 			r.Route("/agents/conflicts", func(r chi.Router) {
@@ -120,12 +185,23 @@ func (h *Handler) RegisterRoutes(r chi.Router) {
 			r.Delete("/agents/{id}", credsHandler.DeleteAgent)
 			r.Get("/agents/{id}/credentials", credsHandler.ListCredentials)
 			r.Post("/agents/{id}/rotate-credentials", credsHandler.RotateCredentials)
+			r.Get("/agents/{id}/fingerprints", credsHandler.ListFingerprints)
+			r.Post("/agents/{id}/fingerprints/pin", credsHandler.PinFingerprint)
+			r.Get("/agents/{id}/versions", h.ListAgentVersionHistory)
+			r.Get("/agents/{id}/config", h.GetAgentConfig)
+			r.Put("/agents/{id}/config", h.UpdateAgentConfig)
+			r.Get("/agents/{id}/config/history", h.ListAgentConfigHistory)
 			r.Get("/agents/{id}/incidents", h.GetIncidents)
 			r.Get("/agents/{id}/inventory", h.GetLatestInventory)
+			r.Get("/agents/{id}/inventory/history", h.GetInventoryHistory)
+			r.Get("/agents/{id}/inventory/diff", h.GetInventoryDiff)
+
+			r.Get("/protocol/stats", h.GetProtocolStats)
 
 			// Incidents
 			r.Post("/incidents/{id}/analyze", h.AnalyzeIncident)
 			r.Post("/incidents/{id}/execute", h.ExecuteSuggestedAction)
+			r.Post("/incidents/{id}/resolve", h.ResolveIncident)
 
 			// Agent direct execution (replaces /admin/exec)
 			r.Post("/agents/{id}/execute", h.HandleAgentExec)
@@ -171,17 +247,17 @@ func getNATSURLs() []string {
 func (h *Handler) AnalyzeIncident(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 
-	incident, err := h.storage.GetIncidentByID(idStr)
+	incident, err := h.storage.GetIncidentByID(r.Context(), idStr)
 	if err != nil {
 		http.Error(w, "Incident not found", http.StatusNotFound)
 		return
 	}
 
-	log.Printf("Analyzing incident %d with AI...", incident.ID)
+	slog.InfoContext(r.Context(), "Analyzing incident with AI", "incident_id", incident.ID, "agent_id", incident.AgentID)
 
-	analysis, err := h.aiClient.AnalyzeIncident(incident)
+	analysis, err := h.aiClient.AnalyzeIncident(r.Context(), incident)
 	if err != nil {
-		log.Printf("AI analysis error: %v", err)
+		slog.ErrorContext(r.Context(), "AI analysis error", "incident_id", incident.ID, "err", err)
 		http.Error(w, fmt.Sprintf("AI analysis failed: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -191,17 +267,17 @@ func (h *Handler) AnalyzeIncident(w http.ResponseWriter, r *http.Request) {
 	incident.SuggestedAction = analysis.SuggestedAction
 	incident.Status = "analyzed"
 
-	if err := h.storage.UpdateIncident(incident); err != nil {
-		log.Printf("Error updating incident: %v", err)
+	if err := h.storage.UpdateIncident(r.Context(), incident); err != nil {
+		slog.ErrorContext(r.Context(), "Error updating incident", "incident_id", incident.ID, "err", err)
 		http.Error(w, "Failed to save analysis", http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("Incident %d analyzed successfully", incident.ID)
+	slog.InfoContext(r.Context(), "Incident analyzed", "incident_id", incident.ID, "is_critical", incident.IsCritical)
 
-	agent, _ := h.storage.GetAgentByAgentID(incident.AgentID)
+	agent, _ := h.storage.GetAgentByAgentID(r.Context(), incident.AgentID)
 	if err := h.slackClient.SendAlert(incident, agent); err != nil {
-		log.Printf("Slack notification error: %v", err)
+		slog.WarnContext(r.Context(), "Slack notification error", "incident_id", incident.ID, "err", err)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -224,7 +300,7 @@ func (h *Handler) AnalyzeIncident(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) ExecuteSuggestedAction(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 
-	incident, err := h.storage.GetIncidentByID(idStr)
+	incident, err := h.storage.GetIncidentByID(r.Context(), idStr)
 	if err != nil {
 		http.Error(w, "Incident not found", http.StatusNotFound)
 		return
@@ -235,24 +311,58 @@ func (h *Handler) ExecuteSuggestedAction(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	log.Printf("Executing suggested action for incident %d: cmd=%s args=%v",
-		incident.ID, incident.SuggestedAction.Cmd, incident.SuggestedAction.Args)
+	slog.InfoContext(r.Context(), "Executing suggested action", "incident_id", incident.ID, "agent_id", incident.AgentID,
+		"cmd", incident.SuggestedAction.Cmd, "args", incident.SuggestedAction.Args)
 
-	resp, err := h.rpc.ExecAction(incident.AgentID, incident.SuggestedAction.Cmd, incident.SuggestedAction.Args, 0)
+	resp, err := h.rpc.ExecAction(r.Context(), incident.AgentID, incident.SuggestedAction.Cmd, incident.SuggestedAction.Args, 0)
 	if err != nil {
 		httpErrorFromRPC(w, err)
 		return
 	}
 
 	incident.Status = "action_sent"
-	if err := h.storage.UpdateIncident(incident); err != nil {
-		log.Printf("Error updating incident status: %v", err)
+	if err := h.storage.UpdateIncident(r.Context(), incident); err != nil {
+		slog.ErrorContext(r.Context(), "Error updating incident status", "incident_id", incident.ID, "err", err)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// ResolveIncident marks an incident resolved
+// @Summary Resolve incident
+// @Description Marks the incident resolved; resolved incidents are removed after the org's retention period
+// @Tags incidents
+// @Produce json
+// @Param id path string true "Incident ID"
+// @Success 200 {object} models.Incident
+// @Failure 404 {string} string "Incident not found or already resolved"
+// @Failure 500 {string} string "Internal server error"
+// @Security BearerAuth
+// @Router /incidents/{id}/resolve [post]
+func (h *Handler) ResolveIncident(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+
+	if err := h.storage.ResolveIncident(r.Context(), idStr); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Incident not found or already resolved", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error resolving incident", "incident_id", idStr, "err", err)
+		http.Error(w, "Failed to resolve incident", http.StatusInternalServerError)
+		return
+	}
+
+	incident, err := h.storage.GetIncidentByID(r.Context(), idStr)
+	if err != nil {
+		http.Error(w, "Incident not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(incident)
+}
+
 // HandleAgentExec executes a command on a specific agent via RPC
 // @Summary Execute command on agent
 // @Description Sends a command to be executed on the specified agent
@@ -284,7 +394,7 @@ func (h *Handler) HandleAgentExec(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := h.rpc.ExecAction(agentID, req.Command, req.Params, 0)
+	resp, err := h.rpc.ExecAction(r.Context(), agentID, req.Command, req.Params, 0)
 	if err != nil {
 		httpErrorFromRPC(w, err)
 		return
@@ -336,7 +446,7 @@ func (h *Handler) GetAgents(w http.ResponseWriter, r *http.Request) {
 	`
 
 	var rows []agentRow
-	if err := h.db.Select(&rows, query); err != nil {
+	if err := h.db.SelectContext(r.Context(), &rows, query); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -397,7 +507,7 @@ func (h *Handler) GetAgents(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param agent body models.Agent true "Agent data"
 // @Success 201 {object} models.Agent
-// @Failure 400 {string} string "Invalid request body"
+// @Failure 400 {string} string "Invalid request body or agent_id"
 // @Failure 500 {string} string "Internal server error"
 // @Security BearerAuth
 // @Router /agents [post]
@@ -408,6 +518,10 @@ func (h *Handler) CreateAgent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !protocol.ValidAgentID(agent.AgentID) {
+		http.Error(w, "Invalid agent_id: must be 12 lowercase hex characters", http.StatusBadRequest)
+		return
+	}
 	if agent.ID == "" {
 		agent.ID = generateUUID()
 	}
@@ -415,7 +529,7 @@ func (h *Handler) CreateAgent(w http.ResponseWriter, r *http.Request) {
 		agent.Status = "offline"
 	}
 
-	if err := h.storage.CreateAgent(&agent); err != nil {
+	if err := h.storage.CreateAgent(r.Context(), &agent); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -436,7 +550,7 @@ func (h *Handler) CreateAgent(w http.ResponseWriter, r *http.Request) {
 // @Router /agents/{id}/incidents [get]
 func (h *Handler) GetIncidents(w http.ResponseWriter, r *http.Request) {
 	agentID := chi.URLParam(r, "id")
-	incidents, err := h.storage.GetIncidents(agentID, 100)
+	incidents, err := h.storage.GetIncidents(r.Context(), agentID, 100)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -462,7 +576,7 @@ func (h *Handler) GetLatestInventory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ts, payload, err := h.storage.GetLatestInventory(agentID)
+	ts, payload, err := h.storage.GetLatestInventory(r.Context(), agentID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			http.Error(w, "Inventory not found", http.StatusNotFound)
@@ -480,6 +594,148 @@ func (h *Handler) GetLatestInventory(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// GetInventoryHistory lists stored inventory snapshots for an agent.
+// @Summary Get agent inventory history
+// @Description Returns stored inventory snapshots (without payload), newest first
+// @Tags agents
+// @Produce json
+// @Param id path string true "Agent ID"
+// @Param limit query int false "Max snapshots (default 50, max 500)"
+// @Success 200 {array} models.InventorySnapshot
+// @Failure 500 {string} string "Internal server error"
+// @Security bearerAuth
+// @Router /agents/{id}/inventory/history [get]
+func (h *Handler) GetInventoryHistory(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+	if agentID == "" {
+		http.Error(w, "Missing agent id", http.StatusBadRequest)
+		return
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 500)
+	}
+
+	snapshots, err := h.storage.ListInventorySnapshots(r.Context(), agentID, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshots)
+}
+
+// GetInventoryDiff compares two inventory snapshots of an agent.
+// @Summary Diff agent inventory snapshots
+// @Description Reports added/removed/changed process candidates, port changes, platform/kernel and RAM changes between two snapshots. Defaults: to = latest, from = the snapshot before "to".
+// @Tags agents
+// @Produce json
+// @Param id path string true "Agent ID"
+// @Param from query int false "Older snapshot id"
+// @Param to query int false "Newer snapshot id"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {string} string "Invalid snapshot id"
+// @Failure 404 {string} string "Snapshot not found"
+// @Failure 500 {string} string "Internal server error"
+// @Security bearerAuth
+// @Router /agents/{id}/inventory/diff [get]
+func (h *Handler) GetInventoryDiff(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+	if agentID == "" {
+		http.Error(w, "Missing agent id", http.StatusBadRequest)
+		return
+	}
+
+	to, ok := h.loadSnapshot(w, r, agentID, r.URL.Query().Get("to"), nil)
+	if !ok {
+		return
+	}
+	from, ok := h.loadSnapshot(w, r, agentID, r.URL.Query().Get("from"), to)
+	if !ok {
+		return
+	}
+
+	var fromInv, toInv models.Inventory
+	if err := json.Unmarshal(from.Payload, &fromInv); err != nil {
+		http.Error(w, "Invalid snapshot payload", http.StatusInternalServerError)
+		return
+	}
+	if err := json.Unmarshal(to.Payload, &toInv); err != nil {
+		http.Error(w, "Invalid snapshot payload", http.StatusInternalServerError)
+		return
+	}
+
+	from.Payload, to.Payload = nil, nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"agent_id": agentID,
+		"from":     from,
+		"to":       to,
+		"diff":     inventory.Compare(&fromInv, &toInv),
+	})
+}
+
+// loadSnapshot resolves a snapshot id query value. An empty value means the
+// latest snapshot, or the one before next when next is set.
+func (h *Handler) loadSnapshot(w http.ResponseWriter, r *http.Request, agentID, value string, next *models.InventorySnapshot) (*models.InventorySnapshot, bool) {
+	var snapshot *models.InventorySnapshot
+	var err error
+	switch {
+	case value != "":
+		id, parseErr := strconv.ParseInt(value, 10, 64)
+		if parseErr != nil {
+			http.Error(w, "Invalid snapshot id", http.StatusBadRequest)
+			return nil, false
+		}
+		snapshot, err = h.storage.GetInventorySnapshot(r.Context(), agentID, id)
+	case next != nil:
+		snapshot, err = h.storage.GetPreviousInventorySnapshot(r.Context(), next)
+	default:
+		snapshot, err = h.storage.GetLatestInventorySnapshot(r.Context(), agentID)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if snapshot == nil {
+		http.Error(w, "Snapshot not found", http.StatusNotFound)
+		return nil, false
+	}
+	return snapshot, true
+}
+
+// GetProtocol returns the agent protocol versions the backend accepts.
+// @Summary Supported agent protocol versions
+// @Description Message versions (msgpack "v") the backend decodes per message kind. Agents send the newest version both sides support.
+// @Tags protocol
+// @Produce json
+// @Success 200 {object} models.ProtocolVersions
+// @Router /protocol [get]
+func (h *Handler) GetProtocol(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(protocol.Supported())
+}
+
+// GetProtocolStats returns the agent message decode counters.
+// @Summary Agent protocol counters
+// @Description Messages decoded by this backend instance since start, per kind, version and result (accepted, invalid, unsupported).
+// @Tags protocol
+// @Produce json
+// @Success 200 {array} models.ProtocolStat
+// @Security BearerAuth
+// @Router /protocol/stats [get]
+func (h *Handler) GetProtocolStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(protocol.Stats())
+}
+
 func (h *Handler) HandleSlackInteractive(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Slack integration disabled", http.StatusServiceUnavailable)
 }
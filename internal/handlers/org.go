@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"opspilot-backend/internal/auth"
+	"opspilot-backend/internal/models"
+)
+
+// orgUser loads the authenticated user and requires an org. It writes the
+// error response itself and returns false when the request must stop.
+func (h *Handler) orgUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "unauthorized")
+		return nil, false
+	}
+
+	user, err := h.storage.GetUser(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "load user", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load user")
+		return nil, false
+	}
+	if user == nil || user.OrgID == "" {
+		respondError(w, http.StatusUnauthorized, "unauthorized")
+		return nil, false
+	}
+
+	return user, true
+}
+
+// orgAgent loads the agent from the {id} URL param and requires it to belong
+// to the caller's org.
+func (h *Handler) orgAgent(w http.ResponseWriter, r *http.Request) (*models.Agent, bool) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	agentID := chi.URLParam(r, "id")
+	agent, err := h.storage.GetAgentByAgentID(r.Context(), agentID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load agent")
+		return nil, false
+	}
+	if agent == nil || agent.OrgID != user.OrgID {
+		respondError(w, http.StatusNotFound, "agent not found")
+		return nil, false
+	}
+
+	return agent, true
+}
+
+func respondJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+func respondError(w http.ResponseWriter, status int, message string) {
+	respondJSON(w, status, map[string]any{"error": message})
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"opspilot-backend/internal/protocol"
+)
+
+// GET /api/v1/quarantine?agent_id=&limit=50
+// Lists events that arrived from the org's agents before they were enrolled.
+func (h *Handler) ListQuarantinedEvents(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 500 {
+			respondError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = parsed
+	}
+
+	events, err := h.storage.ListQuarantinedEvents(r.Context(), user.OrgID, r.URL.Query().Get("agent_id"), limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "quarantine: list", "org_id", user.OrgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to list quarantined events")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, events)
+}
+
+// POST /api/v1/quarantine/{id}/release
+// Raises the incident the quarantined event would have raised.
+func (h *Handler) ReleaseQuarantinedEvent(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "quarantined event not found")
+		return
+	}
+
+	quarantined, err := h.storage.GetQuarantinedEvent(r.Context(), user.OrgID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusNotFound, "quarantined event not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "quarantine: get", "id", id, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load quarantined event")
+		return
+	}
+
+	event, err := protocol.DecodeEvent(quarantined.Payload)
+	if err != nil {
+		respondError(w, http.StatusUnprocessableEntity, "quarantined event cannot be decoded: "+err.Error())
+		return
+	}
+
+	incident := event.ToIncident()
+	err = h.storage.ReleaseQuarantinedEvent(r.Context(), id, incident)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusNotFound, "quarantined event not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "quarantine: release", "id", id, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to release quarantined event")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Quarantined event released", "id", id, "agent_id", quarantined.AgentID, "incident_id", incident.ID, "user_id", user.ID)
+	respondJSON(w, http.StatusCreated, incident)
+}
+
+// DELETE /api/v1/quarantine/{id}
+func (h *Handler) DeleteQuarantinedEvent(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "quarantined event not found")
+		return
+	}
+
+	err = h.storage.DeleteQuarantinedEvent(r.Context(), user.OrgID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusNotFound, "quarantined event not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "quarantine: delete", "id", id, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to delete quarantined event")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"opspilot-backend/internal/models"
+)
+
+// GET /api/v1/retention-policy
+func (h *Handler) GetRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	policy, err := h.storage.GetRetentionPolicy(r.Context(), user.OrgID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "retention: load", "org_id", user.OrgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load retention policy")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, policy)
+}
+
+// PUT /api/v1/retention-policy
+func (h *Handler) UpdateRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateRetentionPolicyInput
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if req.ResolvedIncidentDays < 1 || req.InventoryDays < 1 {
+		respondError(w, http.StatusBadRequest, "resolved_incident_days and inventory_days must be at least 1")
+		return
+	}
+
+	policy, err := h.storage.UpsertRetentionPolicy(r.Context(), user.OrgID, user.ID, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "retention: update", "org_id", user.OrgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to update retention policy")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Retention policy updated", "org_id", user.OrgID, "incident_days", policy.ResolvedIncidentDays, "inventory_days", policy.InventoryDays, "user_id", user.ID)
+	respondJSON(w, http.StatusOK, policy)
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"opspilot-backend/internal/agentversion"
+	"opspilot-backend/internal/models"
+	"opspilot-backend/internal/storage"
+)
+
+// Rollout defaults.
+const (
+	defaultWaveIntervalSeconds  = 300
+	defaultHealthTimeoutSeconds = 600
+)
+
+// POST /api/v1/rollouts
+func (h *Handler) CreateRollout(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.CreateRolloutInput
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	req.TargetVersion = strings.TrimSpace(req.TargetVersion)
+	req.DownloadURL = strings.TrimSpace(req.DownloadURL)
+	if !agentversion.Valid(req.TargetVersion) {
+		respondError(w, http.StatusBadRequest, "invalid target_version")
+		return
+	}
+	if req.BatchSize < 1 {
+		respondError(w, http.StatusBadRequest, "batch_size must be at least 1")
+		return
+	}
+	if req.WaveIntervalSeconds < 0 || req.HealthTimeoutSeconds < 0 || req.MaxFailures < 0 {
+		respondError(w, http.StatusBadRequest, "wave_interval_seconds, health_timeout_seconds and max_failures must not be negative")
+		return
+	}
+	if req.WaveIntervalSeconds == 0 {
+		req.WaveIntervalSeconds = defaultWaveIntervalSeconds
+	}
+	if req.HealthTimeoutSeconds == 0 {
+		req.HealthTimeoutSeconds = defaultHealthTimeoutSeconds
+	}
+
+	rollout, err := h.storage.CreateRollout(r.Context(), user.OrgID, user.ID, req)
+	if errors.Is(err, storage.ErrRolloutNoTargets) {
+		respondError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "rollouts: create", "org_id", user.OrgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to create rollout")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Rollout created", "id", rollout.ID, "org_id", user.OrgID, "version", rollout.TargetVersion, "tags", rollout.Tags, "batch", rollout.BatchSize, "user_id", user.ID)
+	respondJSON(w, http.StatusCreated, rollout)
+}
+
+// GET /api/v1/rollouts
+func (h *Handler) ListRollouts(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	rollouts, err := h.storage.ListRollouts(r.Context(), user.OrgID, 100)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "rollouts: list", "org_id", user.OrgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to list rollouts")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, rollouts)
+}
+
+// GET /api/v1/rollouts/{id}
+func (h *Handler) GetRollout(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	rolloutID := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(rolloutID); err != nil {
+		respondError(w, http.StatusNotFound, "rollout not found")
+		return
+	}
+
+	rollout, err := h.storage.GetRollout(r.Context(), user.OrgID, rolloutID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusNotFound, "rollout not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "rollouts: load", "id", rolloutID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load rollout")
+		return
+	}
+
+	targets, err := h.storage.ListRolloutTargets(r.Context(), rollout.ID, -1)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "rollouts: targets", "id", rolloutID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load rollout targets")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"rollout": rollout,
+		"targets": targets,
+	})
+}
+
+// POST /api/v1/rollouts/{id}/pause
+func (h *Handler) PauseRollout(w http.ResponseWriter, r *http.Request) {
+	h.setRolloutStatus(w, r, models.RolloutPaused, models.RolloutRunning)
+}
+
+// POST /api/v1/rollouts/{id}/resume
+func (h *Handler) ResumeRollout(w http.ResponseWriter, r *http.Request) {
+	h.setRolloutStatus(w, r, models.RolloutRunning, models.RolloutPaused)
+}
+
+// POST /api/v1/rollouts/{id}/cancel
+func (h *Handler) CancelRollout(w http.ResponseWriter, r *http.Request) {
+	h.setRolloutStatus(w, r, models.RolloutCancelled, models.RolloutRunning, models.RolloutPaused)
+}
+
+func (h *Handler) setRolloutStatus(w http.ResponseWriter, r *http.Request, status string, from ...string) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	rolloutID := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(rolloutID); err != nil {
+		respondError(w, http.StatusNotFound, "rollout not found")
+		return
+	}
+
+	rollout, err := h.storage.SetRolloutStatus(r.Context(), user.OrgID, rolloutID, status, from...)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusNotFound, "rollout not found")
+		return
+	}
+	if errors.Is(err, storage.ErrRolloutState) {
+		respondError(w, http.StatusConflict, "rollout must be "+strings.Join(from, " or "))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "rollouts: set status", "id", rolloutID, "status", status, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to update rollout")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Rollout status changed", "id", rollout.ID, "org_id", user.OrgID, "status", status, "user_id", user.ID)
+	respondJSON(w, http.StatusOK, rollout)
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"opspilot-backend/internal/models"
+)
+
+// GET /api/v1/services
+func (h *Handler) ListServices(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	services, err := h.storage.ListServices(r.Context(), user.OrgID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "services: list", "org_id", user.OrgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to list services")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, services)
+}
+
+// GET /api/v1/services/{id}?include_removed=true
+func (h *Handler) GetService(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+	service, ok := h.orgService(w, r, user.OrgID)
+	if !ok {
+		return
+	}
+
+	includeRemoved := r.URL.Query().Get("include_removed") == "true"
+	instances, err := h.storage.ListServiceInstances(r.Context(), service.ID, includeRemoved)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "services: instances", "id", service.ID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load service instances")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"service":   service,
+		"instances": instances,
+	})
+}
+
+// PATCH /api/v1/services/{id}
+func (h *Handler) UpdateService(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+	service, ok := h.orgService(w, r, user.OrgID)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateServiceInput
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if req.RunbookURL != nil && *req.RunbookURL != "" &&
+		!strings.HasPrefix(*req.RunbookURL, "https://") && !strings.HasPrefix(*req.RunbookURL, "http://") {
+		respondError(w, http.StatusBadRequest, "runbook_url must be an http(s) URL")
+		return
+	}
+
+	updated, err := h.storage.UpdateService(r.Context(), user.OrgID, service.ID, user.ID, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "services: update", "id", service.ID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to update service")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Service annotated", "service", updated.Key, "org_id", user.OrgID, "user_id", user.ID)
+	respondJSON(w, http.StatusOK, updated)
+}
+
+// GET /api/v1/services/{id}/incidents?limit=50
+func (h *Handler) ListServiceIncidents(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+	service, ok := h.orgService(w, r, user.OrgID)
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 500 {
+			respondError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = parsed
+	}
+
+	incidents, err := h.storage.ListServiceIncidents(r.Context(), service.ID, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "services: incidents", "id", service.ID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to list incidents")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, incidents)
+}
+
+// orgService loads the service from the URL and requires it to belong to the
+// org. It writes the error response itself and returns false when the request
+// must stop.
+func (h *Handler) orgService(w http.ResponseWriter, r *http.Request, orgID string) (*models.Service, bool) {
+	serviceID := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(serviceID); err != nil {
+		respondError(w, http.StatusNotFound, "service not found")
+		return nil, false
+	}
+
+	service, err := h.storage.GetService(r.Context(), orgID, serviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusNotFound, "service not found")
+		return nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "services: load", "id", serviceID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load service")
+		return nil, false
+	}
+	return service, true
+}
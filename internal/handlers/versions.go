@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"sort"
+
+	"opspilot-backend/internal/agentversion"
+	"opspilot-backend/internal/models"
+)
+
+// GET /api/v1/agent-versions
+// Fleet view: agent count per version and the agents below the org's
+// minimum supported version.
+func (h *Handler) ListAgentVersions(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	org, err := h.storage.GetOrganization(r.Context(), user.OrgID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "versions: load org", "org_id", user.OrgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load organization")
+		return
+	}
+
+	agents, err := h.storage.ListAgentVersions(r.Context(), user.OrgID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "versions: list", "org_id", user.OrgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to list agent versions")
+		return
+	}
+
+	counts := make(map[string]int)
+	outdated := make([]models.AgentVersionInfo, 0)
+	for _, agent := range agents {
+		counts[agent.Version]++
+		if agentversion.Outdated(agent.Version, org.MinAgentVersion) {
+			outdated = append(outdated, agent)
+		}
+	}
+
+	versions := make([]models.VersionCount, 0, len(counts))
+	for version, count := range counts {
+		versions = append(versions, models.VersionCount{
+			Version:  version,
+			Count:    count,
+			Outdated: agentversion.Outdated(version, org.MinAgentVersion),
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return agentversion.Compare(versions[i].Version, versions[j].Version) > 0
+	})
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"min_agent_version": org.MinAgentVersion,
+		"versions":          versions,
+		"outdated_agents":   outdated,
+	})
+}
+
+// GET /api/v1/agents/{id}/versions
+func (h *Handler) ListAgentVersionHistory(w http.ResponseWriter, r *http.Request) {
+	agent, ok := h.orgAgent(w, r)
+	if !ok {
+		return
+	}
+
+	history, err := h.storage.ListAgentVersionHistory(r.Context(), agent.AgentID, 100)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "versions: history", "agent_id", agent.AgentID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to list agent versions")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, history)
+}
@@ -0,0 +1,270 @@
+package ingest
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"opspilot-backend/internal/metrics"
+	"opspilot-backend/internal/natsbus"
+	"opspilot-backend/internal/tracing"
+)
+
+// batchHandler processes messages in fetch order and returns one error per
+// message; nil means the message's writes are committed and it can be acked.
+type batchHandler func(ctx context.Context, msgs []*nats.Msg) []error
+
+// lagInterval is how often consumer lag is read for metrics.
+const lagInterval = 15 * time.Second
+
+// consumerConfig configures a pullConsumer.
+type consumerConfig struct {
+	Name    string // durable consumer name
+	Stream  string // stream the subject belongs to, for metrics
+	Subject string
+	// Fetch batch size adapts between MinFetch and MaxFetch.
+	MinFetch int
+	MaxFetch int
+	// Workers process a fetch in parallel. Messages of one agent always go
+	// to the same worker, in order.
+	Workers int
+}
+
+// pullConsumer is the JetStream fetch loop shared by the ingest consumers:
+// it fetches a batch, splits it by agent across the workers, hands each
+// worker's messages to the handler as one batch and acks once the handler
+// has committed them.
+type pullConsumer struct {
+	js     nats.JetStreamContext
+	cfg    consumerConfig
+	dlq    *natsbus.DeadLetters
+	handle batchHandler
+	sub    *nats.Subscription
+}
+
+func newPullConsumer(js nats.JetStreamContext, cfg consumerConfig, dlq *natsbus.DeadLetters, handle batchHandler) *pullConsumer {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	return &pullConsumer{js: js, cfg: cfg, dlq: dlq, handle: handle}
+}
+
+func (c *pullConsumer) start(ctx context.Context) error {
+	sub, err := c.js.PullSubscribe(
+		c.cfg.Subject,
+		c.cfg.Name,
+		nats.ManualAck(),
+		nats.AckWait(30*time.Second),
+		nats.MaxDeliver(consumerMaxDeliver),
+		nats.MaxAckPending(1000),
+	)
+	if err != nil {
+		return err
+	}
+	c.sub = sub
+
+	go c.consumeLoop(ctx)
+	go c.lagLoop(ctx)
+	return nil
+}
+
+func (c *pullConsumer) stop() error {
+	if c.sub != nil {
+		return c.sub.Drain()
+	}
+	return nil
+}
+
+func (c *pullConsumer) consumeLoop(ctx context.Context) {
+	sizer := newFetchSizer(64, c.cfg.MinFetch, c.cfg.MaxFetch)
+	fetchSize := metrics.ConsumerFetchSize.WithLabelValues(c.cfg.Name)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		msgs, err := c.sub.Fetch(sizer.size, nats.MaxWait(5*time.Second))
+		if err != nil && err != nats.ErrTimeout {
+			slog.Warn("Consumer fetch error", "consumer", c.cfg.Name, "err", err)
+		}
+		sizer.observe(len(msgs))
+		fetchSize.Set(float64(sizer.size))
+		if len(msgs) == 0 {
+			continue
+		}
+
+		c.process(ctx, msgs)
+	}
+}
+
+// process runs one fetch through the workers and acks or fails each message
+// once its batch returned.
+func (c *pullConsumer) process(ctx context.Context, msgs []*nats.Msg) {
+	processed := metrics.Messages.WithLabelValues(c.cfg.Stream, c.cfg.Name, metrics.ResultProcessed)
+	failed := metrics.Messages.WithLabelValues(c.cfg.Stream, c.cfg.Name, metrics.ResultFailed)
+	terminated := metrics.Messages.WithLabelValues(c.cfg.Stream, c.cfg.Name, metrics.ResultTerminated)
+
+	batches := make([][]*nats.Msg, c.cfg.Workers)
+	for _, msg := range msgs {
+		w := partition(msg.Subject, c.cfg.Workers)
+		batches[w] = append(batches[w], msg)
+	}
+
+	var wg sync.WaitGroup
+	for _, batch := range batches {
+		if len(batch) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(batch []*nats.Msg) {
+			defer wg.Done()
+			batchCtx, span := c.startBatchSpan(ctx, batch)
+			defer span.End()
+
+			errs := c.handle(batchCtx, batch)
+			for i, msg := range batch {
+				var err error
+				if i < len(errs) {
+					err = errs[i]
+				}
+				if err != nil {
+					span.AddEvent("message failed", trace.WithAttributes(
+						semconv.MessagingDestinationName(msg.Subject),
+						attribute.String("error", err.Error()),
+					))
+					slog.WarnContext(batchCtx, "Consumer process error", "consumer", c.cfg.Name,
+						"agent_id", subjectAgentID(msg.Subject), "subject", msg.Subject, "err", err)
+					if handleFailure(c.dlq, c.cfg.Name, msg, err) {
+						terminated.Inc()
+					} else {
+						failed.Inc()
+					}
+					continue
+				}
+				msg.Ack()
+				processed.Inc()
+			}
+		}(batch)
+	}
+	wg.Wait()
+}
+
+// startBatchSpan starts the span of one worker batch. Each message's span
+// context (from the publisher's traceparent header) is linked to it, since a
+// batch has many parents.
+func (c *pullConsumer) startBatchSpan(ctx context.Context, batch []*nats.Msg) (context.Context, trace.Span) {
+	links := make([]trace.Link, 0, len(batch))
+	for _, msg := range batch {
+		if sc := trace.SpanContextFromContext(tracing.ExtractNATS(ctx, msg)); sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	return tracing.Tracer.Start(ctx, "process "+c.cfg.Stream,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("nats"),
+			semconv.MessagingOperationTypeDeliver,
+			attribute.String("messaging.consumer", c.cfg.Name),
+			semconv.MessagingBatchMessageCount(len(batch)),
+		),
+	)
+}
+
+// lagLoop exports the consumer's pending, ack-pending and redelivered
+// counts until ctx is done.
+func (c *pullConsumer) lagLoop(ctx context.Context) {
+	ticker := time.NewTicker(lagInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := c.sub.ConsumerInfo()
+			if err != nil {
+				slog.Warn("Consumer info error", "consumer", c.cfg.Name, "err", err)
+				continue
+			}
+			metrics.ConsumerPending.WithLabelValues(c.cfg.Name).Set(float64(info.NumPending))
+			metrics.ConsumerAckPending.WithLabelValues(c.cfg.Name).Set(float64(info.NumAckPending))
+			metrics.ConsumerRedelivered.WithLabelValues(c.cfg.Name).Set(float64(info.NumRedelivered))
+		}
+	}
+}
+
+// partition maps the agent of an ops.{agent_id}.… subject to a worker.
+func partition(subject string, workers int) int {
+	if workers == 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(subjectAgentID(subject)))
+	return int(h.Sum32() % uint32(workers))
+}
+
+// subjectAgentID returns the agent token of an ops.{agent_id}.… subject, or
+// the whole subject when it has another shape.
+func subjectAgentID(subject string) string {
+	if parts := strings.SplitN(subject, ".", 3); len(parts) == 3 && parts[0] == "ops" {
+		return parts[1]
+	}
+	return subject
+}
+
+// fetchSizer doubles the fetch size after three full fetches and halves it
+// after three empty ones.
+type fetchSizer struct {
+	size, min, max int
+	full, empty    int
+}
+
+func newFetchSizer(size, min, max int) *fetchSizer {
+	if size < min {
+		size = min
+	}
+	if size > max {
+		size = max
+	}
+	return &fetchSizer{size: size, min: min, max: max}
+}
+
+func (s *fetchSizer) observe(n int) {
+	switch {
+	case n == 0:
+		s.empty++
+		s.full = 0
+		if s.empty >= 3 && s.size > s.min {
+			s.size /= 2
+			if s.size < s.min {
+				s.size = s.min
+			}
+			s.empty = 0
+		}
+	case n == s.size:
+		s.full++
+		s.empty = 0
+		if s.full >= 3 && s.size < s.max {
+			s.size *= 2
+			if s.size > s.max {
+				s.size = s.max
+			}
+			s.full = 0
+		}
+	default:
+		s.full = 0
+		s.empty = 0
+	}
+}
@@ -0,0 +1,74 @@
+package ingest
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"opspilot-backend/internal/metrics"
+	"opspilot-backend/internal/natsbus"
+)
+
+// consumerMaxDeliver is the delivery limit of the JetStream consumers; the
+// last failed delivery goes to the DLQ instead of being dropped.
+const consumerMaxDeliver = 3
+
+// poisonError marks a message that can never be processed (e.g. it does not
+// decode), so it goes to the DLQ without redelivery.
+type poisonError struct {
+	err error
+}
+
+func (e *poisonError) Error() string { return e.err.Error() }
+func (e *poisonError) Unwrap() error { return e.err }
+
+func poison(err error) error {
+	return &poisonError{err: err}
+}
+
+// rejectedError marks a message that must not be processed nor kept for
+// replay (e.g. a spoofed agent_id); it is terminated.
+type rejectedError struct {
+	err error
+}
+
+func (e *rejectedError) Error() string { return e.err.Error() }
+func (e *rejectedError) Unwrap() error { return e.err }
+
+func reject(err error) error {
+	return &rejectedError{err: err}
+}
+
+// handleFailure NAKs msg for redelivery, or copies it to the DLQ and
+// terminates it when it is poison or this was its last delivery. If the DLQ
+// write fails the message is NAKed so it is not lost silently. It reports
+// whether the message was terminated.
+func handleFailure(dlq *natsbus.DeadLetters, consumer string, msg *nats.Msg, err error) bool {
+	var rerr *rejectedError
+	if errors.As(err, &rerr) {
+		msg.Term()
+		return true
+	}
+
+	var perr *poisonError
+	exhausted := errors.As(err, &perr)
+	if meta, mErr := msg.Metadata(); mErr == nil && meta.NumDelivered >= consumerMaxDeliver {
+		exhausted = true
+	}
+	if !exhausted || dlq == nil {
+		msg.NakWithDelay(5 * time.Second)
+		return false
+	}
+
+	if dErr := dlq.Publish(msg, consumer, err.Error()); dErr != nil {
+		slog.Error("DLQ write failed", "consumer", consumer, "agent_id", subjectAgentID(msg.Subject), "subject", msg.Subject, "err", dErr)
+		msg.NakWithDelay(5 * time.Second)
+		return false
+	}
+	metrics.DeadLetters.WithLabelValues(consumer).Inc()
+	slog.Warn("Message moved to DLQ", "consumer", consumer, "agent_id", subjectAgentID(msg.Subject), "subject", msg.Subject, "reason", err)
+	msg.Term()
+	return true
+}
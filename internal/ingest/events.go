@@ -2,179 +2,177 @@ package ingest
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"strings"
-	"time"
 
-	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
-	"github.com/vmihailenco/msgpack/v5"
 
 	"opspilot-backend/internal/models"
+	"opspilot-backend/internal/natsbus"
+	"opspilot-backend/internal/protocol"
 	"opspilot-backend/internal/storage"
 )
 
+const eventsConsumerName = "backend-processor"
+
 type EventsConsumer struct {
-	js      nats.JetStreamContext
-	storage *storage.Storage
-	sub     *nats.Subscription
+	storage  *storage.Storage
+	consumer *pullConsumer
 }
 
-func NewEventsConsumer(js nats.JetStreamContext, storage *storage.Storage) *EventsConsumer {
-	return &EventsConsumer{js: js, storage: storage}
+func NewEventsConsumer(js nats.JetStreamContext, storage *storage.Storage, dlq *natsbus.DeadLetters) *EventsConsumer {
+	c := &EventsConsumer{storage: storage}
+	c.consumer = newPullConsumer(js, consumerConfig{
+		Name:     eventsConsumerName,
+		Stream:   "OPS_EVENTS",
+		Subject:  "ops.*.events.>",
+		MinFetch: 8,
+		MaxFetch: 512,
+		Workers:  4,
+	}, dlq, c.processBatch)
+	return c
 }
 
 // Start begins consuming events from JetStream.
 func (c *EventsConsumer) Start(ctx context.Context) error {
-	sub, err := c.js.PullSubscribe(
-		"ops.*.events.>",
-		"backend-processor",
-		nats.ManualAck(),
-		nats.AckWait(30*time.Second),
-		nats.MaxDeliver(3),
-		nats.MaxAckPending(1000),
-	)
-	if err != nil {
+	if err := c.consumer.start(ctx); err != nil {
 		return err
 	}
-	c.sub = sub
-
-	go c.consumeLoop(ctx)
-	log.Println("INFO Events consumer started")
+	slog.InfoContext(ctx, "Events consumer started")
 	return nil
 }
 
-func (c *EventsConsumer) consumeLoop(ctx context.Context) {
-	fetchSize := 64
-	minFetch := 8
-	maxFetch := 512
-	fullCount := 0
-	emptyCount := 0
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-		}
-
-		msgs, err := c.sub.Fetch(fetchSize, nats.MaxWait(5*time.Second))
+// processBatch turns the events into incidents with a single transaction,
+// falling back to one transaction per event when the batch fails.
+// Undecodable events are poison; events whose agent_id differs from the
+// subject are rejected and recorded as security events; events of agents
+// that are not enrolled are quarantined.
+func (c *EventsConsumer) processBatch(ctx context.Context, msgs []*nats.Msg) []error {
+	errs := make([]error, len(msgs))
+	events := make([]*models.Event, len(msgs))
+	var mismatches []models.SecurityEvent
+	agentIDs := make([]string, 0, len(msgs))
+	seen := make(map[string]bool)
+
+	for i, msg := range msgs {
+		// Undecodable, invalid and unknown-version events go to the DLQ so
+		// they can be replayed once the backend understands them.
+		event, err := protocol.DecodeEvent(msg.Data)
 		if err != nil {
-			if err != nats.ErrTimeout {
-				log.Printf("WARN Fetch error: %v", err)
-			}
-			emptyCount++
-			fullCount = 0
-			if emptyCount >= 3 && fetchSize > minFetch {
-				fetchSize /= 2
-				if fetchSize < minFetch {
-					fetchSize = minFetch
-				}
-				emptyCount = 0
-			}
+			errs[i] = poison(fmt.Errorf("decode event: %w", err))
 			continue
 		}
 
-		if len(msgs) == 0 {
-			emptyCount++
-			fullCount = 0
-			if emptyCount >= 3 && fetchSize > minFetch {
-				fetchSize /= 2
-				if fetchSize < minFetch {
-					fetchSize = minFetch
-				}
-				emptyCount = 0
-			}
+		// Agent JWTs only allow publishing on their own subject, so the
+		// subject identifies the sender.
+		subjectAgent := subjectAgentID(msg.Subject)
+		if !seen[subjectAgent] {
+			seen[subjectAgent] = true
+			agentIDs = append(agentIDs, subjectAgent)
+		}
+		if event.AgentID != subjectAgent {
+			errs[i] = reject(fmt.Errorf("payload agent_id %q does not match subject agent %q", event.AgentID, subjectAgent))
+			mismatches = append(mismatches, models.SecurityEvent{
+				AgentID: subjectAgent,
+				Type:    models.SecurityEventAgentIDMismatch,
+				Details: map[string]interface{}{
+					"subject":          msg.Subject,
+					"payload_agent_id": event.AgentID,
+					"alert_type":       event.AlertType,
+				},
+			})
 			continue
 		}
 
-		if len(msgs) == fetchSize {
-			fullCount++
-			emptyCount = 0
-			if fullCount >= 3 && fetchSize < maxFetch {
-				fetchSize *= 2
-				if fetchSize > maxFetch {
-					fetchSize = maxFetch
-				}
-				fullCount = 0
-			}
-		} else {
-			fullCount = 0
-			emptyCount = 0
-		}
+		slog.DebugContext(ctx, "Event received", "agent_id", event.AgentID, "type", event.AlertType, "v", event.V)
+		events[i] = event
+	}
+	if len(agentIDs) == 0 {
+		return errs
+	}
 
-		for _, msg := range msgs {
-			if err := c.processMessage(msg); err != nil {
-				log.Printf("WARN Process error: %v", err)
-				msg.NakWithDelay(5 * time.Second)
-				continue
+	orgs, err := c.storage.EnrolledAgentOrgs(ctx, agentIDs)
+	if err != nil {
+		for i, event := range events {
+			if event != nil {
+				errs[i] = err
 			}
-			msg.Ack()
 		}
+	} else if err := c.saveEvents(ctx, msgs, events, orgs); err != nil {
+		// One bad row fails the whole insert; retry each event on its own so
+		// only the bad ones are redelivered or dead-lettered.
+		slog.WarnContext(ctx, "Event batch insert failed, retrying per event", "events", len(events), "err", err)
+		c.saveEach(ctx, msgs, events, orgs, errs)
 	}
-}
 
-func (c *EventsConsumer) processMessage(msg *nats.Msg) error {
-	var event models.Event
-	if err := msgpack.Unmarshal(msg.Data, &event); err != nil {
-		log.Printf("ERROR Unmarshal error (terminating): %v", err)
-		msg.Term()
-		return nil
+	for _, mismatch := range mismatches {
+		mismatch.OrgID = orgs[mismatch.AgentID]
+		if err := c.storage.RecordSecurityEvent(ctx, mismatch); err != nil {
+			slog.ErrorContext(ctx, "Security event record failed", "type", mismatch.Type, "agent_id", mismatch.AgentID, "err", err)
+		}
+		slog.WarnContext(ctx, "Event rejected: agent_id mismatch", "agent_id", mismatch.AgentID, "org_id", mismatch.OrgID, "payload_agent_id", mismatch.Details["payload_agent_id"])
 	}
+	return errs
+}
 
-	log.Printf("INFO Event received: agent=%s type=%s", event.AgentID, event.AlertType)
-
-	agent, err := c.storage.GetAgentByAgentID(event.AgentID)
-	if err != nil {
-		return err
-	}
-	if agent == nil {
-		agent = &models.Agent{
-			ID:       uuid.New().String(),
-			AgentID:  event.AgentID,
-			Hostname: "unknown",
-			Status:   "online",
+// saveEvents stores incidents for enrolled agents and quarantines the events
+// of the others.
+func (c *EventsConsumer) saveEvents(ctx context.Context, msgs []*nats.Msg, events []*models.Event, orgs map[string]string) error {
+	incidents := make([]*models.Incident, 0, len(events))
+	var quarantined []models.QuarantinedEvent
+	for i, event := range events {
+		if event == nil {
+			continue
 		}
-		if err := c.storage.CreateAgent(agent); err != nil {
-			return err
+		if _, enrolled := orgs[event.AgentID]; enrolled {
+			incidents = append(incidents, event.ToIncident())
+			continue
 		}
+		quarantined = append(quarantined, models.QuarantinedEvent{
+			AgentID:   event.AgentID,
+			Subject:   msgs[i].Subject,
+			AlertType: event.AlertType,
+			Message:   strings.ReplaceAll(event.Message, "\x00", ""),
+			Payload:   msgs[i].Data,
+		})
 	}
 
-	source := event.GetSource()
-	logs := event.GetLogs()
-
-	rawError := event.Message
-	if logs != "" {
-		rawError = event.Message + "\n\n" + logs
+	if err := c.storage.SaveEvents(ctx, incidents, quarantined); err != nil {
+		return err
 	}
-	rawError = strings.ReplaceAll(rawError, "\x00", "")
 
-	contextJSON, _ := json.Marshal(event.Details)
-
-	incident := &models.Incident{
-		AgentID:     event.AgentID,
-		Type:        event.AlertType,
-		Source:      source,
-		RawError:    rawError,
-		ContextJSON: contextJSON,
-		Status:      "new",
+	for _, incident := range incidents {
+		slog.InfoContext(ctx, "Incident created", "incident_id", incident.ID, "agent_id", incident.AgentID, "type", incident.Type, "source", incident.Source)
 	}
-
-	if err := c.storage.CreateIncident(incident); err != nil {
-		return err
+	for _, event := range quarantined {
+		slog.WarnContext(ctx, "Event quarantined: agent not enrolled", "agent_id", event.AgentID, "type", event.AlertType)
 	}
-
-	log.Printf("INFO Incident created: id=%d agent=%s type=%s source=%s",
-		incident.ID, event.AgentID, event.AlertType, source)
-
 	return nil
 }
 
+// saveEach stores the events one by one after a failed batch insert. Events
+// the database rejects as invalid are poison; other errors are retried.
+func (c *EventsConsumer) saveEach(ctx context.Context, msgs []*nats.Msg, events []*models.Event, orgs map[string]string, errs []error) {
+	one := make([]*models.Event, len(events))
+	for i, event := range events {
+		if event == nil {
+			continue
+		}
+		one[i] = event
+		err := c.saveEvents(ctx, msgs, one, orgs)
+		one[i] = nil
+		if err == nil {
+			continue
+		}
+		if storage.IsDataError(err) {
+			err = poison(fmt.Errorf("store event: %w", err))
+		}
+		errs[i] = err
+	}
+}
+
 // Stop gracefully stops the consumer.
 func (c *EventsConsumer) Stop() error {
-	if c.sub != nil {
-		return c.sub.Drain()
-	}
-	return nil
+	return c.consumer.stop()
 }
@@ -6,152 +6,264 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"sort"
 	"strings"
-	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/vmihailenco/msgpack/v5"
 
+	"opspilot-backend/internal/inventory"
 	"opspilot-backend/internal/models"
+	"opspilot-backend/internal/natsbus"
 	"opspilot-backend/internal/storage"
 )
 
+const inventoryConsumerName = "backend-inventory"
+
 type InventoryConsumer struct {
-	js      nats.JetStreamContext
-	storage *storage.Storage
-	sub     *nats.Subscription
+	storage  *storage.Storage
+	consumer *pullConsumer
 }
 
-func NewInventoryConsumer(js nats.JetStreamContext, storage *storage.Storage) *InventoryConsumer {
-	return &InventoryConsumer{js: js, storage: storage}
+func NewInventoryConsumer(js nats.JetStreamContext, storage *storage.Storage, dlq *natsbus.DeadLetters) *InventoryConsumer {
+	c := &InventoryConsumer{storage: storage}
+	c.consumer = newPullConsumer(js, consumerConfig{
+		Name:     inventoryConsumerName,
+		Stream:   "OPS_INVENTORY",
+		Subject:  "ops.*.inventory",
+		MinFetch: 32,
+		MaxFetch: 256,
+		Workers:  8,
+	}, dlq, c.processBatch)
+	return c
 }
 
 // Start begins consuming inventory snapshots from JetStream.
 func (c *InventoryConsumer) Start(ctx context.Context) error {
-	sub, err := c.js.PullSubscribe(
-		"ops.*.inventory",
-		"backend-inventory",
-		nats.ManualAck(),
-		nats.AckWait(30*time.Second),
-		nats.MaxDeliver(3),
-		nats.MaxAckPending(1000),
-	)
-	if err != nil {
+	if err := c.consumer.start(ctx); err != nil {
 		return err
 	}
-	c.sub = sub
-
-	go c.consumeLoop(ctx)
-	log.Println("INFO Inventory consumer started")
+	slog.InfoContext(ctx, "Inventory consumer started")
 	return nil
 }
 
-func (c *InventoryConsumer) consumeLoop(ctx context.Context) {
-	fetchSize := 64
-	minFetch := 32
-	maxFetch := 256
-	fullCount := 0
-	emptyCount := 0
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-		}
+// processBatch stores the batch's snapshots with a single statement, falling
+// back to one statement per snapshot when the batch fails. Only the newest
+// snapshot of each agent is stored: the ones it supersedes are acked, and
+// drift is detected against the agent's previously stored snapshot.
+func (c *InventoryConsumer) processBatch(ctx context.Context, msgs []*nats.Msg) []error {
+	errs := make([]error, len(msgs))
+	invs := make([]*models.Inventory, len(msgs))
+	snapshots := make([]storage.InventorySnapshot, len(msgs))
+	newest := make(map[string]int)
 
-		msgs, err := c.sub.Fetch(fetchSize, nats.MaxWait(5*time.Second))
+	for i, msg := range msgs {
+		inv, snapshot, err := decodeInventory(msg)
 		if err != nil {
-			if err != nats.ErrTimeout {
-				log.Printf("WARN Inventory fetch error: %v", err)
-			}
-			emptyCount++
-			fullCount = 0
-			if emptyCount >= 3 && fetchSize > minFetch {
-				fetchSize /= 2
-				if fetchSize < minFetch {
-					fetchSize = minFetch
-				}
-				emptyCount = 0
-			}
+			errs[i] = err
 			continue
 		}
+		invs[i], snapshots[i] = inv, snapshot
+		newest[snapshot.AgentID] = i
+	}
+	if len(newest) == 0 {
+		return errs
+	}
 
-		if len(msgs) == 0 {
-			emptyCount++
-			fullCount = 0
-			if emptyCount >= 3 && fetchSize > minFetch {
-				fetchSize /= 2
-				if fetchSize < minFetch {
-					fetchSize = minFetch
-				}
-				emptyCount = 0
-			}
-			continue
-		}
+	indexes := make([]int, 0, len(newest))
+	batch := make([]storage.InventorySnapshot, 0, len(newest))
+	for _, i := range newest {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		batch = append(batch, snapshots[i])
+	}
 
-		if len(msgs) == fetchSize {
-			fullCount++
-			emptyCount = 0
-			if fullCount >= 3 && fetchSize < maxFetch {
-				fetchSize *= 2
-				if fetchSize > maxFetch {
-					fetchSize = maxFetch
-				}
-				fullCount = 0
-			}
-		} else {
-			fullCount = 0
-			emptyCount = 0
+	stored, err := c.storage.InsertInventorySnapshots(ctx, batch)
+	if err != nil {
+		// One bad row fails the whole insert; retry each snapshot on its own
+		// so only the bad ones are redelivered or dead-lettered.
+		slog.WarnContext(ctx, "Inventory batch insert failed, retrying per snapshot", "snapshots", len(batch), "err", err)
+		for _, i := range indexes {
+			snapshot := snapshots[i]
+			errs[i] = storeInventory(ctx, c.storage, snapshot.AgentID, invs[i], snapshot.Payload, snapshot.Hash)
 		}
+		return errs
+	}
 
-		for _, msg := range msgs {
-			if err := c.processMessage(msg); err != nil {
-				log.Printf("WARN Inventory process error: %v", err)
-				msg.NakWithDelay(5 * time.Second)
-				continue
-			}
-			msg.Ack()
+	for _, i := range indexes {
+		snapshot := snapshots[i]
+		previous, ok := stored[snapshot.AgentID]
+		if !ok {
+			continue
+		}
+		if err := inventoryStored(ctx, c.storage, snapshot.AgentID, invs[i], previous, snapshot.Hash); err != nil {
+			errs[i] = err
 		}
 	}
+	return errs
 }
 
-func (c *InventoryConsumer) processMessage(msg *nats.Msg) error {
+// decodeInventory decodes an inventory message into the snapshot to store.
+func decodeInventory(msg *nats.Msg) (*models.Inventory, storage.InventorySnapshot, error) {
 	var inv models.Inventory
 	if err := msgpack.Unmarshal(msg.Data, &inv); err != nil {
-		log.Printf("ERROR Inventory unmarshal error: %v", err)
-		msg.Term()
-		return nil
+		return nil, storage.InventorySnapshot{}, poison(fmt.Errorf("unmarshal inventory: %w", err))
 	}
 
-	payload, err := json.Marshal(inv)
+	agentID, err := agentIDFromSubject(msg.Subject)
 	if err != nil {
-		return err
+		return nil, storage.InventorySnapshot{}, poison(err)
 	}
 
-	agentID, err := agentIDFromSubject(msg.Subject)
+	payload, hash, err := encodeInventory(&inv)
 	if err != nil {
-		return err
+		return nil, storage.InventorySnapshot{}, err
 	}
+	return &inv, storage.InventorySnapshot{AgentID: agentID, Hash: hash, Payload: payload}, nil
+}
 
+// encodeInventory returns the JSON payload stored for a snapshot and its hash.
+func encodeInventory(inv *models.Inventory) ([]byte, string, error) {
+	payload, err := json.Marshal(inv)
+	if err != nil {
+		return nil, "", err
+	}
 	sum := sha256.Sum256(payload)
-	hash := hex.EncodeToString(sum[:])
+	return payload, hex.EncodeToString(sum[:]), nil
+}
 
-	if err := c.storage.InsertInventorySnapshot(agentID, hash, payload); err != nil {
+// storeInventory is the shared ingest path for single snapshots from
+// JetStream and from heartbeats: when the snapshot changed it stores it,
+// raises drift incidents and syncs the service catalog.
+func storeInventory(ctx context.Context, store *storage.Storage, agentID string, inv *models.Inventory, payload []byte, hash string) error {
+	previous, stored, err := store.InsertInventorySnapshot(ctx, agentID, hash, payload)
+	if err != nil {
 		return err
 	}
+	if !stored {
+		return nil
+	}
+	return inventoryStored(ctx, store, agentID, inv, previous, hash)
+}
 
-	log.Printf("INFO Inventory snapshot stored: agent=%s hash=%s", agentID, hash[:8])
+// inventoryStored raises drift incidents and syncs the service catalog for a
+// snapshot that replaced previous (nil for the agent's first snapshot).
+func inventoryStored(ctx context.Context, store *storage.Storage, agentID string, inv *models.Inventory, previous []byte, hash string) error {
+	agent, err := store.GetAgentByAgentID(ctx, agentID)
+	if err != nil {
+		return err
+	}
+	if agent == nil || agent.OrgID == "" {
+		return nil
+	}
+
+	slog.InfoContext(ctx, "Inventory snapshot stored", "agent_id", agentID, "hash", hash[:8])
+	if previous != nil {
+		detectDrift(ctx, store, agent, previous, inv)
+	}
+	// An unchanged snapshot cannot change the catalog, so only stored ones
+	// are synced. A failed sync is only logged, like drift detection: a
+	// redelivery would find the hash unchanged and skip it anyway.
+	if err := store.SyncServiceInstances(ctx, agent.OrgID, agentID, serviceObservations(inv)); err != nil {
+		slog.ErrorContext(ctx, "Inventory service sync", "agent_id", agentID, "org_id", agent.OrgID, "err", err)
+	}
 	return nil
 }
 
+// serviceObservations clusters the inventory's candidates into services.
+func serviceObservations(inv *models.Inventory) []storage.ServiceObservation {
+	candidates := inventory.Candidates(inv)
+	observed := make([]storage.ServiceObservation, 0, len(candidates))
+	for key, c := range candidates {
+		serviceKey := inventory.ServiceKey(c)
+		if serviceKey == "" {
+			continue
+		}
+		name := c.Name
+		if name == "" {
+			name = serviceKey
+		}
+		observed = append(observed, storage.ServiceObservation{
+			Key:           serviceKey,
+			Name:          name,
+			Type:          c.Type,
+			CandidateKey:  key,
+			SourceSystemd: c.SourceSystemd,
+			SourceDocker:  c.SourceDocker,
+			ListenPorts:   c.ListenPorts,
+		})
+	}
+	// A stable order keeps concurrent syncs of different agents from
+	// deadlocking on shared service rows.
+	sort.Slice(observed, func(i, j int) bool {
+		if observed[i].Key != observed[j].Key {
+			return observed[i].Key < observed[j].Key
+		}
+		return observed[i].CandidateKey < observed[j].CandidateKey
+	})
+	return observed
+}
+
+// detectDrift compares the snapshot with the one it replaced and raises an
+// inventory_drift incident for the changes the org's drift policy flags.
+// Failures are only logged: the snapshot is already stored and a redelivery
+// would not be compared again.
+func detectDrift(ctx context.Context, store *storage.Storage, agent *models.Agent, previous []byte, inv *models.Inventory) {
+	agentID := agent.AgentID
+	policy, err := store.GetDriftPolicy(ctx, agent.OrgID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Inventory drift: load policy", "org_id", agent.OrgID, "err", err)
+		return
+	}
+	if !policy.Enabled || inventory.Silenced(policy, agent.Tags) {
+		return
+	}
+
+	var prev models.Inventory
+	if err := json.Unmarshal(previous, &prev); err != nil {
+		slog.ErrorContext(ctx, "Inventory drift: decode previous snapshot", "agent_id", agentID, "err", err)
+		return
+	}
+
+	diff := inventory.Compare(&prev, inv)
+	findings := inventory.Drift(diff, policy)
+	if len(findings) == 0 {
+		return
+	}
+
+	messages := make([]string, 0, len(findings))
+	rules := make([]string, 0, len(findings))
+	for _, f := range findings {
+		messages = append(messages, f.Message)
+		rules = append(rules, f.Rule)
+	}
+
+	incident := &models.Incident{
+		AgentID:  agentID,
+		Type:     models.IncidentTypeInventoryDrift,
+		Source:   "inventory",
+		RawError: "Inventory drift detected:\n- " + strings.Join(messages, "\n- "),
+		Context: map[string]interface{}{
+			"rules":    rules,
+			"findings": findings,
+			"diff":     diff,
+		},
+		Status: "new",
+	}
+	if err := store.CreateIncident(ctx, incident); err != nil {
+		slog.ErrorContext(ctx, "Inventory drift incident create", "agent_id", agentID, "err", err)
+		return
+	}
+
+	slog.InfoContext(ctx, "Inventory drift incident created", "incident_id", incident.ID, "agent_id", agentID, "findings", len(findings))
+}
+
 // Stop gracefully stops the consumer.
 func (c *InventoryConsumer) Stop() error {
-	if c.sub != nil {
-		return c.sub.Drain()
-	}
-	return nil
+	return c.consumer.stop()
 }
 
 func agentIDFromSubject(subject string) (string, error) {
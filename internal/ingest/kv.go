@@ -2,26 +2,48 @@ package ingest
 
 import (
 	"context"
-	"log"
+	"encoding/json"
+	"log/slog"
+	"strconv"
 	"time"
 
 	"github.com/nats-io/nats.go"
-	"github.com/vmihailenco/msgpack/v5"
 
 	"opspilot-backend/internal/cache"
+	"opspilot-backend/internal/logging"
+	"opspilot-backend/internal/metrics"
 	"opspilot-backend/internal/models"
+	"opspilot-backend/internal/natsauth"
+	"opspilot-backend/internal/protocol"
 	"opspilot-backend/internal/storage"
 )
 
+const (
+	fingerprintCacheTTL = time.Hour
+	metaCacheTTL        = time.Hour
+
+	// heartbeatLogInterval is how often a heartbeat is logged per agent at
+	// info level; the others are logged at debug.
+	heartbeatLogInterval = 5 * time.Minute
+)
+
 type KVWatcher struct {
-	kv      nats.KeyValue
-	storage *storage.Storage
-	cache   cache.Client
-	watcher nats.KeyWatcher
+	kv           nats.KeyValue
+	storage      *storage.Storage
+	cache        cache.Client
+	fingerprints *natsauth.FingerprintMonitor
+	watcher      nats.KeyWatcher
+	heartbeats   *logging.Sampler
 }
 
-func NewKVWatcher(kv nats.KeyValue, storage *storage.Storage, cache cache.Client) *KVWatcher {
-	return &KVWatcher{kv: kv, storage: storage, cache: cache}
+func NewKVWatcher(kv nats.KeyValue, storage *storage.Storage, cache cache.Client, fingerprints *natsauth.FingerprintMonitor) *KVWatcher {
+	return &KVWatcher{
+		kv:           kv,
+		storage:      storage,
+		cache:        cache,
+		fingerprints: fingerprints,
+		heartbeats:   logging.NewSampler(heartbeatLogInterval),
+	}
 }
 
 // Start begins watching the AGENTS KV bucket.
@@ -34,7 +56,7 @@ func (w *KVWatcher) Start(ctx context.Context) error {
 
 	go w.watchLoop(ctx)
 
-	log.Println("INFO KV watcher started")
+	slog.Info("KV watcher started")
 	return nil
 }
 
@@ -57,35 +79,205 @@ func (w *KVWatcher) handleEntry(entry nats.KeyValueEntry) {
 
 	switch entry.Operation() {
 	case nats.KeyValuePut:
-		var hb models.Heartbeat
-		if err := msgpack.Unmarshal(entry.Value(), &hb); err != nil {
-			log.Printf("ERROR KV unmarshal error for %s: %v", agentID, err)
+		metrics.KVUpdates.WithLabelValues("put").Inc()
+		hb, err := protocol.DecodeHeartbeat(entry.Value())
+		if err != nil {
+			slog.Warn("KV heartbeat rejected", "agent_id", agentID, "err", err)
 			return
 		}
 
 		now := time.Now().UnixMilli()
 		if err := w.cache.SetLastSeen(agentID, now, 150); err != nil {
-			log.Printf("ERROR KV last_seen cache error: %v", err)
+			slog.Error("KV last_seen cache error", "agent_id", agentID, "err", err)
 			return
 		}
 		if status, err := w.cache.GetStatus(agentID); err == nil && status == "offline" {
 			if err := w.cache.SetStatus(agentID, "online"); err != nil {
-				log.Printf("WARN KV status update error: %v", err)
+				slog.Warn("KV status update error", "agent_id", agentID, "err", err)
 			}
 		}
-		log.Printf("INFO Agent heartbeat: %s (%s) cpu=%.1f%% mem=%.1f%%",
-			agentID, hb.Hostname, hb.CPUPercent, hb.MemPercent)
+		if hb.HardwareFingerprint != "" {
+			w.checkFingerprint(agentID, hb.HardwareFingerprint)
+		}
+		w.syncAgentMeta(agentID, hb)
+		if hb.ConfigVersion > 0 {
+			w.recordConfigVersion(agentID, hb.ConfigVersion)
+		}
+		if hb.Inventory != nil {
+			w.storeInventory(agentID, hb.Inventory)
+		}
+		w.logHeartbeat(agentID, hb)
 
 	case nats.KeyValueDelete:
-		if err := w.storage.UpdateAgentStatus(agentID, "offline"); err != nil {
-			log.Printf("ERROR KV delete agent error: %v", err)
+		metrics.KVUpdates.WithLabelValues("delete").Inc()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := w.storage.UpdateAgentStatus(ctx, agentID, "offline"); err != nil {
+			slog.Error("KV delete agent error", "agent_id", agentID, "err", err)
 			return
 		}
-		log.Printf("INFO Agent offline (graceful): %s", agentID)
+		slog.Info("Agent offline (graceful)", "agent_id", agentID)
 
 	case nats.KeyValuePurge:
-		log.Printf("INFO Agent purged: %s", agentID)
+		metrics.KVUpdates.WithLabelValues("purge").Inc()
+		slog.Info("Agent purged", "agent_id", agentID)
+	}
+}
+
+// logHeartbeat logs one heartbeat per agent per heartbeatLogInterval at info
+// level, with the number skipped since; the rest go to debug.
+func (w *KVWatcher) logHeartbeat(agentID string, hb *models.Heartbeat) {
+	level := slog.LevelDebug
+	attrs := []any{"agent_id", agentID, "hostname", hb.Hostname, "cpu_percent", hb.CPUPercent, "mem_percent", hb.MemPercent}
+	if ok, suppressed := w.heartbeats.Allow(agentID); ok {
+		level = slog.LevelInfo
+		attrs = append(attrs, "suppressed", suppressed)
+	}
+	slog.Log(context.Background(), level, "Agent heartbeat", attrs...)
+}
+
+// checkFingerprint compares a heartbeat fingerprint with the pinned one. The
+// last checked value is cached so unchanged heartbeats skip the database.
+func (w *KVWatcher) checkFingerprint(agentID, fingerprint string) {
+	cacheKey := storage.FingerprintCacheKey(agentID)
+	if cached, err := w.cache.Get(cacheKey); err == nil && cached == fingerprint {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	agent, err := w.storage.GetAgentByAgentID(ctx, agentID)
+	if err != nil || agent == nil {
+		return
+	}
+
+	if _, err := w.fingerprints.Observe(ctx, agent, fingerprint, natsauth.FingerprintSourceHeartbeat, ""); err != nil {
+		slog.Error("KV fingerprint check", "agent_id", agentID, "err", err)
+		return
+	}
+	if err := w.cache.Set(cacheKey, fingerprint, fingerprintCacheTTL); err != nil {
+		slog.Warn("KV fingerprint cache error", "agent_id", agentID, "err", err)
+	}
+}
+
+// syncAgentMeta updates the agent's hostname and meta (os, arch,
+// agent_version) when the heartbeat reports different values. Fields the
+// heartbeat leaves empty keep their stored values. The last applied values are
+// cached so unchanged heartbeats skip the database.
+func (w *KVWatcher) syncAgentMeta(agentID string, hb *models.Heartbeat) {
+	if hb.Hostname == "" && hb.OS == "" && hb.Arch == "" && hb.AgentVersion == "" {
+		return
+	}
+
+	fields := make(map[string]string, 3)
+	for key, value := range map[string]string{"os": hb.OS, "arch": hb.Arch, "agent_version": hb.AgentVersion} {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+	meta, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+	current := hb.Hostname + "|" + string(meta)
+
+	cacheKey := "ops:agent:meta:" + agentID
+	if cached, err := w.cache.Get(cacheKey); err == nil && cached == current {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	agent, err := w.storage.GetAgentByAgentID(ctx, agentID)
+	if err != nil || agent == nil {
+		return
+	}
+	hostname := hb.Hostname
+	if hostname == "" {
+		hostname = agent.Hostname
+	}
+	if agent.Hostname != hostname || !sameMeta(agent.Meta, meta) {
+		if err := w.storage.UpdateAgentMetaAndHostname(ctx, agentID, meta, hostname); err != nil {
+			slog.Error("KV agent meta update", "agent_id", agentID, "err", err)
+			return
+		}
+		slog.Info("Agent meta updated", "agent_id", agentID, "hostname", hostname,
+			"os", hb.OS, "arch", hb.Arch, "version", hb.AgentVersion)
+	}
+	if hb.AgentVersion != "" {
+		if err := w.storage.RecordAgentVersion(ctx, agentID, hb.AgentVersion, models.VersionSourceHeartbeat); err != nil {
+			slog.Error("KV agent version update", "agent_id", agentID, "err", err)
+			return
+		}
+	}
+	if err := w.cache.Set(cacheKey, current, metaCacheTTL); err != nil {
+		slog.Warn("KV meta cache error", "agent_id", agentID, "err", err)
+	}
+}
+
+// storeInventory stores inventory carried by a heartbeat through the same
+// path as the JetStream inventory consumer. The last stored hash is cached so
+// repeated heartbeats with the same inventory skip the database.
+func (w *KVWatcher) storeInventory(agentID string, inv *models.Inventory) {
+	payload, hash, err := encodeInventory(inv)
+	if err != nil {
+		slog.Error("KV inventory encode", "agent_id", agentID, "err", err)
+		return
+	}
+
+	cacheKey := "ops:agent:inventory_hash:" + agentID
+	if cached, err := w.cache.Get(cacheKey); err == nil && cached == hash {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := storeInventory(ctx, w.storage, agentID, inv, payload, hash); err != nil {
+		slog.Error("KV inventory store", "agent_id", agentID, "err", err)
+		return
+	}
+	if err := w.cache.Set(cacheKey, hash, metaCacheTTL); err != nil {
+		slog.Warn("KV inventory cache error", "agent_id", agentID, "err", err)
+	}
+}
+
+// recordConfigVersion stores the AGENT_CONFIG version the agent reports as
+// applied. The last stored value is cached so unchanged heartbeats skip the
+// database.
+func (w *KVWatcher) recordConfigVersion(agentID string, version int64) {
+	value := strconv.FormatInt(version, 10)
+	cacheKey := "ops:agent:config_version:" + agentID
+	if cached, err := w.cache.Get(cacheKey); err == nil && cached == value {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := w.storage.SetAppliedConfigVersion(ctx, agentID, version); err != nil {
+		slog.Error("KV config version update", "agent_id", agentID, "err", err)
+		return
+	}
+	if err := w.cache.Set(cacheKey, value, metaCacheTTL); err != nil {
+		slog.Warn("KV config version cache error", "agent_id", agentID, "err", err)
+	}
+}
+
+// sameMeta reports whether every field of next already has that value in stored.
+func sameMeta(stored, next []byte) bool {
+	var a, b map[string]string
+	if json.Unmarshal(stored, &a) != nil || json.Unmarshal(next, &b) != nil {
+		return false
+	}
+	for key, value := range b {
+		if a[key] != value {
+			return false
+		}
 	}
+	return true
 }
 
 // Stop gracefully stops the watcher.
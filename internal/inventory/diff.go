@@ -0,0 +1,241 @@
+// Package inventory compares agent inventory snapshots.
+package inventory
+
+import (
+	"sort"
+
+	"opspilot-backend/internal/models"
+)
+
+// Change is a scalar field that differs between two snapshots.
+type Change struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// RAMChange reports a change of total RAM in bytes.
+type RAMChange struct {
+	From  int64 `json:"from"`
+	To    int64 `json:"to"`
+	Delta int64 `json:"delta"`
+}
+
+// Candidate identifies a process candidate across snapshots.
+type Candidate struct {
+	Key           string `json:"key"`
+	Name          string `json:"name"`
+	Type          string `json:"type,omitempty"`
+	SourceSystemd string `json:"source_systemd,omitempty"`
+	SourceDocker  string `json:"source_docker,omitempty"`
+	ListenPorts   []int  `json:"listen_ports,omitempty"`
+}
+
+// CandidateChange is a candidate present in both snapshots with different
+// type, command line or ports.
+type CandidateChange struct {
+	Key          string  `json:"key"`
+	Name         string  `json:"name"`
+	Type         *Change `json:"type,omitempty"`
+	Cmdline      *Change `json:"cmdline,omitempty"`
+	PortsAdded   []int   `json:"ports_added,omitempty"`
+	PortsRemoved []int   `json:"ports_removed,omitempty"`
+}
+
+// PortChange is a listening port that appeared or disappeared on the host.
+type PortChange struct {
+	Port      int    `json:"port"`
+	Candidate string `json:"candidate"`
+}
+
+// Diff is the structured difference between two inventory snapshots.
+type Diff struct {
+	Platform          *Change           `json:"platform,omitempty"`
+	PlatformVersion   *Change           `json:"platform_version,omitempty"`
+	KernelVersion     *Change           `json:"kernel_version,omitempty"`
+	CPUModel          *Change           `json:"cpu_model,omitempty"`
+	RAMTotal          *RAMChange        `json:"ram_total,omitempty"`
+	AddedCandidates   []Candidate       `json:"added_candidates"`
+	RemovedCandidates []Candidate       `json:"removed_candidates"`
+	ChangedCandidates []CandidateChange `json:"changed_candidates"`
+	PortsOpened       []PortChange      `json:"ports_opened"`
+	PortsClosed       []PortChange      `json:"ports_closed"`
+}
+
+// Empty reports whether the snapshots are equivalent.
+func (d *Diff) Empty() bool {
+	return d.Platform == nil && d.PlatformVersion == nil && d.KernelVersion == nil &&
+		d.CPUModel == nil && d.RAMTotal == nil &&
+		len(d.AddedCandidates) == 0 && len(d.RemovedCandidates) == 0 &&
+		len(d.ChangedCandidates) == 0 && len(d.PortsOpened) == 0 && len(d.PortsClosed) == 0
+}
+
+// Compare returns what changed from one snapshot to the next. Candidates are
+// matched by CandidateKey, so PID changes alone are not reported.
+func Compare(from, to *models.Inventory) *Diff {
+	d := &Diff{
+		Platform:          changed(from.Platform, to.Platform),
+		PlatformVersion:   changed(from.PlatformVersion, to.PlatformVersion),
+		KernelVersion:     changed(from.KernelVersion, to.KernelVersion),
+		CPUModel:          changed(from.CPUModel, to.CPUModel),
+		AddedCandidates:   []Candidate{},
+		RemovedCandidates: []Candidate{},
+		ChangedCandidates: []CandidateChange{},
+		PortsOpened:       []PortChange{},
+		PortsClosed:       []PortChange{},
+	}
+	if from.RAMTotal != to.RAMTotal {
+		d.RAMTotal = &RAMChange{From: from.RAMTotal, To: to.RAMTotal, Delta: to.RAMTotal - from.RAMTotal}
+	}
+
+	before := Candidates(from)
+	after := Candidates(to)
+
+	for _, key := range sortedKeys(after) {
+		next := after[key]
+		prev, ok := before[key]
+		if !ok {
+			d.AddedCandidates = append(d.AddedCandidates, next)
+			continue
+		}
+
+		change := CandidateChange{Key: key, Name: next.Name}
+		change.Type = changed(prev.Type, next.Type)
+		change.PortsAdded = subtract(next.ListenPorts, prev.ListenPorts)
+		change.PortsRemoved = subtract(prev.ListenPorts, next.ListenPorts)
+		if cmdFrom, cmdTo := cmdlineOf(from, key), cmdlineOf(to, key); cmdFrom != cmdTo {
+			change.Cmdline = &Change{From: cmdFrom, To: cmdTo}
+		}
+		if change.Type != nil || change.Cmdline != nil || len(change.PortsAdded) > 0 || len(change.PortsRemoved) > 0 {
+			d.ChangedCandidates = append(d.ChangedCandidates, change)
+		}
+	}
+	for _, key := range sortedKeys(before) {
+		if _, ok := after[key]; !ok {
+			d.RemovedCandidates = append(d.RemovedCandidates, before[key])
+		}
+	}
+
+	portsBefore := listeningPorts(before)
+	portsAfter := listeningPorts(after)
+	for _, port := range sortedPorts(portsAfter) {
+		if _, ok := portsBefore[port]; !ok {
+			d.PortsOpened = append(d.PortsOpened, PortChange{Port: port, Candidate: portsAfter[port]})
+		}
+	}
+	for _, port := range sortedPorts(portsBefore) {
+		if _, ok := portsAfter[port]; !ok {
+			d.PortsClosed = append(d.PortsClosed, PortChange{Port: port, Candidate: portsBefore[port]})
+		}
+	}
+
+	return d
+}
+
+// CandidateKey is the stable identity of a candidate: its systemd unit, else
+// its docker container, else type and name.
+func CandidateKey(c models.ProcessCandidate) string {
+	switch {
+	case c.SourceSystemd != "":
+		return "systemd:" + c.SourceSystemd
+	case c.SourceDocker != "":
+		return "docker:" + c.SourceDocker
+	default:
+		return "process:" + c.Type + ":" + c.Name
+	}
+}
+
+// Candidates groups an inventory's candidates by key; ports of candidates
+// sharing a key (e.g. worker processes) are merged.
+func Candidates(inv *models.Inventory) map[string]Candidate {
+	out := make(map[string]Candidate, len(inv.Candidates))
+	for _, c := range inv.Candidates {
+		key := CandidateKey(c)
+		existing, ok := out[key]
+		if !ok {
+			existing = Candidate{
+				Key:           key,
+				Name:          c.Name,
+				Type:          c.Type,
+				SourceSystemd: c.SourceSystemd,
+				SourceDocker:  c.SourceDocker,
+			}
+		}
+		existing.ListenPorts = union(existing.ListenPorts, c.ListenPorts)
+		out[key] = existing
+	}
+	return out
+}
+
+func cmdlineOf(inv *models.Inventory, key string) string {
+	for _, c := range inv.Candidates {
+		if CandidateKey(c) == key {
+			return c.Cmdline
+		}
+	}
+	return ""
+}
+
+func listeningPorts(candidates map[string]Candidate) map[int]string {
+	ports := make(map[int]string)
+	for _, key := range sortedKeys(candidates) {
+		for _, port := range candidates[key].ListenPorts {
+			if _, ok := ports[port]; !ok {
+				ports[port] = candidates[key].Name
+			}
+		}
+	}
+	return ports
+}
+
+func changed(from, to string) *Change {
+	if from == to {
+		return nil
+	}
+	return &Change{From: from, To: to}
+}
+
+func union(a, b []int) []int {
+	seen := make(map[int]bool, len(a)+len(b))
+	out := make([]int, 0, len(a)+len(b))
+	for _, v := range append(append([]int{}, a...), b...) {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+// subtract returns the values of a that are not in b.
+func subtract(a, b []int) []int {
+	skip := make(map[int]bool, len(b))
+	for _, v := range b {
+		skip[v] = true
+	}
+	var out []int
+	for _, v := range a {
+		if !skip[v] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func sortedKeys(m map[string]Candidate) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedPorts(m map[int]string) []int {
+	ports := make([]int, 0, len(m))
+	for p := range m {
+		ports = append(ports, p)
+	}
+	sort.Ints(ports)
+	return ports
+}
@@ -0,0 +1,128 @@
+package inventory
+
+import (
+	"reflect"
+	"testing"
+
+	"opspilot-backend/internal/models"
+)
+
+func TestCompare(t *testing.T) {
+	base := models.Inventory{
+		Platform:      "ubuntu",
+		KernelVersion: "6.1.0",
+		RAMTotal:      4 << 30,
+		Candidates: []models.ProcessCandidate{
+			{Name: "nginx", Type: "web", PID: 10, SourceSystemd: "nginx.service", ListenPorts: []int{80}},
+			{Name: "redis", Type: "cache", PID: 20, SourceDocker: "redis", ListenPorts: []int{6379}},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		to    func(inv models.Inventory) models.Inventory
+		check func(t *testing.T, d *Diff)
+	}{
+		{
+			name: "pid change only",
+			to: func(inv models.Inventory) models.Inventory {
+				inv.Candidates = []models.ProcessCandidate{inv.Candidates[0], inv.Candidates[1]}
+				inv.Candidates[0].PID = 11
+				return inv
+			},
+			check: func(t *testing.T, d *Diff) {
+				if !d.Empty() {
+					t.Fatalf("diff = %+v, want empty", d)
+				}
+			},
+		},
+		{
+			name: "kernel and ram",
+			to: func(inv models.Inventory) models.Inventory {
+				inv.KernelVersion = "6.2.0"
+				inv.RAMTotal = 8 << 30
+				return inv
+			},
+			check: func(t *testing.T, d *Diff) {
+				if d.KernelVersion == nil || *d.KernelVersion != (Change{From: "6.1.0", To: "6.2.0"}) {
+					t.Errorf("kernel = %+v", d.KernelVersion)
+				}
+				if d.RAMTotal == nil || d.RAMTotal.Delta != 4<<30 {
+					t.Errorf("ram = %+v", d.RAMTotal)
+				}
+				if d.Platform != nil {
+					t.Errorf("platform = %+v, want nil", d.Platform)
+				}
+			},
+		},
+		{
+			name: "added and removed candidates",
+			to: func(inv models.Inventory) models.Inventory {
+				inv.Candidates = []models.ProcessCandidate{
+					inv.Candidates[0],
+					{Name: "postgres", Type: "db", SourceSystemd: "postgresql.service", ListenPorts: []int{5432}},
+				}
+				return inv
+			},
+			check: func(t *testing.T, d *Diff) {
+				if len(d.AddedCandidates) != 1 || d.AddedCandidates[0].Key != "systemd:postgresql.service" {
+					t.Errorf("added = %+v", d.AddedCandidates)
+				}
+				if len(d.RemovedCandidates) != 1 || d.RemovedCandidates[0].Key != "docker:redis" {
+					t.Errorf("removed = %+v", d.RemovedCandidates)
+				}
+				if want := []PortChange{{Port: 5432, Candidate: "postgres"}}; !reflect.DeepEqual(d.PortsOpened, want) {
+					t.Errorf("opened = %+v, want %+v", d.PortsOpened, want)
+				}
+				if want := []PortChange{{Port: 6379, Candidate: "redis"}}; !reflect.DeepEqual(d.PortsClosed, want) {
+					t.Errorf("closed = %+v, want %+v", d.PortsClosed, want)
+				}
+			},
+		},
+		{
+			name: "changed candidate",
+			to: func(inv models.Inventory) models.Inventory {
+				nginx := inv.Candidates[0]
+				nginx.Cmdline = "nginx -g daemon off;"
+				nginx.ListenPorts = []int{443}
+				inv.Candidates = []models.ProcessCandidate{nginx, inv.Candidates[1]}
+				return inv
+			},
+			check: func(t *testing.T, d *Diff) {
+				if len(d.ChangedCandidates) != 1 {
+					t.Fatalf("changed = %+v", d.ChangedCandidates)
+				}
+				c := d.ChangedCandidates[0]
+				if c.Key != "systemd:nginx.service" || c.Cmdline == nil || c.Type != nil {
+					t.Errorf("change = %+v", c)
+				}
+				if !reflect.DeepEqual(c.PortsAdded, []int{443}) || !reflect.DeepEqual(c.PortsRemoved, []int{80}) {
+					t.Errorf("ports added %v, removed %v", c.PortsAdded, c.PortsRemoved)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			to := tt.to(base)
+			tt.check(t, Compare(&base, &to))
+		})
+	}
+}
+
+func TestCandidateKey(t *testing.T) {
+	tests := []struct {
+		c    models.ProcessCandidate
+		want string
+	}{
+		{models.ProcessCandidate{Name: "nginx", Type: "web", SourceSystemd: "nginx.service", SourceDocker: "web"}, "systemd:nginx.service"},
+		{models.ProcessCandidate{Name: "redis", Type: "cache", SourceDocker: "redis"}, "docker:redis"},
+		{models.ProcessCandidate{Name: "java", Type: "app"}, "process:app:java"},
+	}
+	for _, tt := range tests {
+		if got := CandidateKey(tt.c); got != tt.want {
+			t.Errorf("CandidateKey(%+v) = %q, want %q", tt.c, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package inventory
+
+import (
+	"fmt"
+	"path"
+
+	"opspilot-backend/internal/models"
+)
+
+// Drift returns the changes in d that the policy considers notable: newly
+// listening ports, disappeared systemd/docker services, kernel changes and
+// docker containers not matching the allowed patterns.
+func Drift(d *Diff, policy *models.DriftPolicy) []models.DriftFinding {
+	findings := make([]models.DriftFinding, 0)
+	if !policy.Enabled {
+		return findings
+	}
+
+	if policy.NewPorts {
+		ignored := make(map[int]bool, len(policy.IgnoredPorts))
+		for _, port := range policy.IgnoredPorts {
+			ignored[port] = true
+		}
+		for _, p := range d.PortsOpened {
+			if ignored[p.Port] {
+				continue
+			}
+			findings = append(findings, models.DriftFinding{
+				Rule:    models.DriftNewPort,
+				Message: fmt.Sprintf("port %d is now listening (%s)", p.Port, p.Candidate),
+			})
+		}
+	}
+
+	if policy.RemovedServices {
+		for _, c := range d.RemovedCandidates {
+			if c.SourceSystemd == "" && c.SourceDocker == "" {
+				continue
+			}
+			findings = append(findings, models.DriftFinding{
+				Rule:    models.DriftRemovedService,
+				Message: fmt.Sprintf("service %s disappeared", serviceName(c)),
+			})
+		}
+	}
+
+	if policy.KernelChange && d.KernelVersion != nil {
+		findings = append(findings, models.DriftFinding{
+			Rule:    models.DriftKernelChange,
+			Message: fmt.Sprintf("kernel changed from %s to %s", d.KernelVersion.From, d.KernelVersion.To),
+		})
+	}
+
+	if policy.NewContainers {
+		for _, c := range d.AddedCandidates {
+			if c.SourceDocker == "" || containerAllowed(c, policy.AllowedContainers) {
+				continue
+			}
+			findings = append(findings, models.DriftFinding{
+				Rule:    models.DriftNewContainer,
+				Message: fmt.Sprintf("unexpected docker container %s started", c.SourceDocker),
+			})
+		}
+	}
+
+	return findings
+}
+
+// Silenced reports whether an agent with the given tags is exempt from drift
+// incidents under the policy.
+func Silenced(policy *models.DriftPolicy, tags []string) bool {
+	for _, silenced := range policy.SilencedTags {
+		for _, tag := range tags {
+			if tag == silenced {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func serviceName(c Candidate) string {
+	if c.SourceSystemd != "" {
+		return c.SourceSystemd
+	}
+	return c.SourceDocker
+}
+
+func containerAllowed(c Candidate, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, c.SourceDocker); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, c.Name); ok {
+			return true
+		}
+	}
+	return false
+}
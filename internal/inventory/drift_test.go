@@ -0,0 +1,100 @@
+package inventory
+
+import (
+	"testing"
+
+	"opspilot-backend/internal/models"
+)
+
+func TestDrift(t *testing.T) {
+	diff := &Diff{
+		KernelVersion: &Change{From: "6.1.0", To: "6.2.0"},
+		AddedCandidates: []Candidate{
+			{Key: "docker:web-1", Name: "web", SourceDocker: "web-1"},
+			{Key: "docker:miner", Name: "xmrig", SourceDocker: "miner"},
+			{Key: "process:app:java", Name: "java"},
+		},
+		RemovedCandidates: []Candidate{
+			{Key: "systemd:cron.service", Name: "cron", SourceSystemd: "cron.service"},
+			{Key: "process:app:python", Name: "python"},
+		},
+		PortsOpened: []PortChange{{Port: 22, Candidate: "sshd"}, {Port: 4444, Candidate: "nc"}},
+	}
+	all := models.DriftPolicy{
+		Enabled:           true,
+		NewPorts:          true,
+		RemovedServices:   true,
+		KernelChange:      true,
+		NewContainers:     true,
+		IgnoredPorts:      []int{22},
+		AllowedContainers: []string{"web-*"},
+	}
+
+	tests := []struct {
+		name   string
+		policy func(p models.DriftPolicy) models.DriftPolicy
+		want   []string
+	}{
+		{
+			name:   "all rules",
+			policy: func(p models.DriftPolicy) models.DriftPolicy { return p },
+			want:   []string{models.DriftNewPort, models.DriftRemovedService, models.DriftKernelChange, models.DriftNewContainer},
+		},
+		{
+			name:   "disabled",
+			policy: func(p models.DriftPolicy) models.DriftPolicy { p.Enabled = false; return p },
+			want:   []string{},
+		},
+		{
+			name: "kernel only",
+			policy: func(p models.DriftPolicy) models.DriftPolicy {
+				return models.DriftPolicy{Enabled: true, KernelChange: true}
+			},
+			want: []string{models.DriftKernelChange},
+		},
+		{
+			name: "no ignored ports or allowed containers",
+			policy: func(p models.DriftPolicy) models.DriftPolicy {
+				p.IgnoredPorts, p.AllowedContainers = nil, nil
+				return p
+			},
+			want: []string{models.DriftNewPort, models.DriftNewPort, models.DriftRemovedService, models.DriftKernelChange, models.DriftNewContainer, models.DriftNewContainer},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := tt.policy(all)
+			findings := Drift(diff, &policy)
+			got := make([]string, 0, len(findings))
+			for _, f := range findings {
+				got = append(got, f.Rule)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("rules = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("rules = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSilenced(t *testing.T) {
+	policy := &models.DriftPolicy{SilencedTags: []string{"lab"}}
+	tests := []struct {
+		tags []string
+		want bool
+	}{
+		{[]string{"prod", "lab"}, true},
+		{[]string{"prod"}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := Silenced(policy, tt.tags); got != tt.want {
+			t.Errorf("Silenced(%v) = %v, want %v", tt.tags, got, tt.want)
+		}
+	}
+}
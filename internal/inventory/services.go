@@ -0,0 +1,18 @@
+package inventory
+
+import "strings"
+
+// ServiceKey clusters candidates from different hosts into one logical
+// service: the systemd unit without its suffix, else the container name, else
+// the lowercased process name. Units and containers name what an operator
+// deployed; process names are shared by unrelated services (java, python).
+func ServiceKey(c Candidate) string {
+	switch {
+	case c.SourceSystemd != "":
+		return strings.ToLower(strings.TrimSuffix(c.SourceSystemd, ".service"))
+	case c.SourceDocker != "":
+		return strings.ToLower(c.SourceDocker)
+	default:
+		return strings.ToLower(c.Name)
+	}
+}
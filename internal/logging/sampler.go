@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"sync"
+	"time"
+)
+
+// Sampler lets one record per key through per interval, for lines that
+// would otherwise be logged on every heartbeat.
+type Sampler struct {
+	interval time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]*sampledKey
+	lastSweep time.Time
+}
+
+type sampledKey struct {
+	allowed    time.Time
+	seen       time.Time
+	suppressed int
+}
+
+func NewSampler(interval time.Duration) *Sampler {
+	return &Sampler{interval: interval, keys: make(map[string]*sampledKey), lastSweep: time.Now()}
+}
+
+// Allow reports whether a record for key should be logged now, and how many
+// were suppressed since the last one that was.
+func (s *Sampler) Allow(key string) (bool, int) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > s.interval {
+		// Keys not seen for an interval (agent gone) are dropped.
+		for k, v := range s.keys {
+			if now.Sub(v.seen) > s.interval {
+				delete(s.keys, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	entry, ok := s.keys[key]
+	if !ok {
+		s.keys[key] = &sampledKey{allowed: now, seen: now}
+		return true, 0
+	}
+	entry.seen = now
+	if now.Sub(entry.allowed) < s.interval {
+		entry.suppressed++
+		return false, 0
+	}
+	suppressed := entry.suppressed
+	entry.allowed, entry.suppressed = now, 0
+	return true, suppressed
+}
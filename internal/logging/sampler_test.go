@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSampler(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	s := NewSampler(interval)
+
+	steps := []struct {
+		key            string
+		sleep          time.Duration
+		wantAllowed    bool
+		wantSuppressed int
+	}{
+		{key: "a", wantAllowed: true},
+		{key: "a", wantAllowed: false},
+		{key: "b", wantAllowed: true},
+		{key: "a", sleep: 60 * time.Millisecond, wantAllowed: false},
+		{key: "a", sleep: 60 * time.Millisecond, wantAllowed: true, wantSuppressed: 2},
+		{key: "a", wantAllowed: false},
+	}
+
+	for i, step := range steps {
+		time.Sleep(step.sleep)
+		allowed, suppressed := s.Allow(step.key)
+		if allowed != step.wantAllowed || suppressed != step.wantSuppressed {
+			t.Fatalf("step %d: Allow(%q) = %v, %d, want %v, %d", i, step.key, allowed, suppressed, step.wantAllowed, step.wantSuppressed)
+		}
+	}
+}
+
+func TestSamplerDropsIdleKeys(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	s := NewSampler(interval)
+
+	s.Allow("gone")
+	s.Allow("gone")
+	time.Sleep(2*interval + 10*time.Millisecond)
+	s.Allow("other")
+
+	s.mu.Lock()
+	_, ok := s.keys["gone"]
+	s.mu.Unlock()
+	if ok {
+		t.Fatal("idle key was not dropped")
+	}
+}
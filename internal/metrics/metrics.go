@@ -0,0 +1,115 @@
+// Package metrics holds the Prometheus collectors exposed on /metrics of the
+// internal metrics listener. Labels never carry tenant identifiers.
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "opspilot"
+
+// Message results counted per stream and consumer.
+const (
+	ResultProcessed  = "processed"
+	ResultFailed     = "failed"     // NAKed for redelivery
+	ResultTerminated = "terminated" // rejected or moved to the DLQ
+)
+
+var (
+	// JetStream consumers
+	ConsumerFetchSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consumer_fetch_size",
+		Help:      "Current adaptive fetch batch size per consumer.",
+	}, []string{"consumer"})
+	ConsumerPending = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consumer_pending_messages",
+		Help:      "Messages in the stream not yet delivered to the consumer (lag).",
+	}, []string{"consumer"})
+	ConsumerAckPending = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consumer_ack_pending_messages",
+		Help:      "Messages delivered to the consumer and not yet acknowledged.",
+	}, []string{"consumer"})
+	ConsumerRedelivered = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consumer_redelivered_messages",
+		Help:      "Messages currently being redelivered to the consumer.",
+	}, []string{"consumer"})
+	Messages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_total",
+		Help:      "Messages handled per stream, consumer and result (processed, failed, terminated).",
+	}, []string{"stream", "consumer", "result"})
+	DeadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dead_letters_total",
+		Help:      "Messages copied to the DLQ per consumer.",
+	}, []string{"consumer"})
+
+	// KV watcher
+	KVUpdates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kv_updates_total",
+		Help:      "AGENTS KV updates seen by the watcher per operation.",
+	}, []string{"operation"})
+
+	// RPC
+	RPCDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Agent RPC round-trip latency per action.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"action"})
+	RPCRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_requests_total",
+		Help:      "Agent RPC requests per action and result code (ok, known agent error_code or other, failed, offline, timeout, error).",
+	}, []string{"action", "code"})
+
+	// AI analysis
+	AIDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ai_request_duration_seconds",
+		Help:      "Incident analysis latency, including fallbacks.",
+		Buckets:   []float64{0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60},
+	})
+	AIRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ai_requests_total",
+		Help:      "Incident analyses per result (ok, fallback, error).",
+	}, []string{"result"})
+
+	// Agent protocol
+	ProtocolMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "protocol_messages_total",
+		Help:      "Decoded agent messages per kind, protocol version and result (accepted, invalid, unsupported). Version 0 counts messages rejected before their version was known.",
+	}, []string{"kind", "version", "result"})
+
+	// Agents
+	OnlineAgents = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "agents_online",
+		Help:      "Online agents across all organizations.",
+	})
+
+	// HTTP
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency per method, chi route pattern and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// RegisterDB exports the connection pool stats of db as go_sql_* metrics
+// with db_name="opspilot".
+func RegisterDB(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, namespace))
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// AgentConfig is what an agent should watch. It is stored as JSON and
+// published to the AGENT_CONFIG KV bucket as msgpack.
+type AgentConfig struct {
+	Services   []string           `json:"services,omitempty" msgpack:"services,omitempty"`
+	Containers []string           `json:"containers,omitempty" msgpack:"containers,omitempty"`
+	LogPaths   []string           `json:"log_paths,omitempty" msgpack:"log_paths,omitempty"`
+	Thresholds map[string]float64 `json:"thresholds,omitempty" msgpack:"thresholds,omitempty"`
+}
+
+// ConfigTemplate applies Config to the org's agents carrying all MatchTags
+// (every agent when empty). Templates are merged by ascending Priority, so a
+// higher priority wins on conflicting thresholds.
+type ConfigTemplate struct {
+	ID        string      `json:"id"`
+	OrgID     string      `json:"org_id"`
+	Name      string      `json:"name"`
+	Priority  int         `json:"priority"`
+	MatchTags []string    `json:"match_tags"`
+	Config    AgentConfig `json:"config"`
+	UpdatedBy *string     `json:"updated_by,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+type ConfigTemplateInput struct {
+	Name      string      `json:"name" validate:"required"`
+	Priority  int         `json:"priority"`
+	MatchTags []string    `json:"match_tags"`
+	Config    AgentConfig `json:"config"`
+}
+
+// AgentConfigState is the desired config published for an agent and the
+// version the agent last reported as applied.
+type AgentConfigState struct {
+	AgentID        string      `json:"agent_id"`
+	Version        int64       `json:"version"`
+	Hash           string      `json:"hash"`
+	Config         AgentConfig `json:"config"`
+	PublishedAt    time.Time   `json:"published_at"`
+	AppliedVersion *int64      `json:"applied_version,omitempty"`
+	AppliedAt      *time.Time  `json:"applied_at,omitempty"`
+	InSync         bool        `json:"in_sync"`
+	// Override is the agent-specific part merged over the templates.
+	Override *AgentConfig `json:"override,omitempty"`
+}
+
+// AgentConfigVersion is one published config of an agent.
+type AgentConfigVersion struct {
+	Version   int64       `json:"version"`
+	Hash      string      `json:"hash"`
+	Config    AgentConfig `json:"config"`
+	CreatedAt time.Time   `json:"created_at"`
+}
@@ -3,27 +3,47 @@ package models
 import "time"
 
 type BootstrapToken struct {
-	ID           string     `db:"id" json:"id"`
-	OrgID        string     `db:"org_id" json:"org_id"`
-	TokenPrefix  string     `db:"token_prefix" json:"token_prefix"`
-	Description  string     `db:"description" json:"description"`
-	Tags         []string   `db:"tags" json:"tags"`
-	AllowedCIDRs []string   `db:"allowed_cidrs" json:"allowed_cidrs,omitempty"`
-	ExpiresAt    *time.Time `db:"expires_at" json:"expires_at,omitempty"`
-	MaxUses      *int       `db:"max_uses" json:"max_uses,omitempty"`
-	UseCount     int        `db:"use_count" json:"use_count"`
-	CreatedBy    string     `db:"created_by" json:"created_by"`
-	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
-	LastUsedAt   *time.Time `db:"last_used_at" json:"last_used_at,omitempty"`
-	RevokedAt    *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+	ID              string     `db:"id" json:"id"`
+	OrgID           string     `db:"org_id" json:"org_id"`
+	TokenPrefix     string     `db:"token_prefix" json:"token_prefix"`
+	Description     string     `db:"description" json:"description"`
+	Tags            []string   `db:"tags" json:"tags"`
+	AllowedCIDRs    []string   `db:"allowed_cidrs" json:"allowed_cidrs,omitempty"`
+	ExpiresAt       *time.Time `db:"expires_at" json:"expires_at,omitempty"`
+	MaxUses         *int       `db:"max_uses" json:"max_uses,omitempty"`
+	UseCount        int        `db:"use_count" json:"use_count"`
+	RequireApproval bool       `db:"require_approval" json:"require_approval"`
+	NameTemplate    string     `db:"name_template" json:"name_template,omitempty"`
+	TagRules        []TagRule  `db:"tag_rules" json:"tag_rules"`
+	MaxActiveAgents *int       `db:"max_active_agents" json:"max_active_agents,omitempty"`
+	AllowedOS       []string   `db:"allowed_os" json:"allowed_os,omitempty"`
+	AllowedArch     []string   `db:"allowed_arch" json:"allowed_arch,omitempty"`
+	CreatedBy       string     `db:"created_by" json:"created_by"`
+	CreatedAt       time.Time  `db:"created_at" json:"created_at"`
+	LastUsedAt      *time.Time `db:"last_used_at" json:"last_used_at,omitempty"`
+	RevokedAt       *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
 }
 
 type CreateBootstrapTokenInput struct {
-	Description  string     `json:"description" validate:"max=255"`
-	Tags         []string   `json:"tags"`
-	AllowedCIDRs []string   `json:"allowed_cidrs" validate:"dive,cidr"`
-	ExpiresAt    *time.Time `json:"expires_at"`
-	MaxUses      *int       `json:"max_uses" validate:"omitempty,min=1"`
+	Description     string     `json:"description" validate:"max=255"`
+	Tags            []string   `json:"tags"`
+	AllowedCIDRs    []string   `json:"allowed_cidrs" validate:"dive,cidr"`
+	ExpiresAt       *time.Time `json:"expires_at"`
+	MaxUses         *int       `json:"max_uses" validate:"omitempty,min=1"`
+	RequireApproval bool       `json:"require_approval"`
+	NameTemplate    string     `json:"name_template" validate:"max=255"`
+	TagRules        []TagRule  `json:"tag_rules"`
+	MaxActiveAgents *int       `json:"max_active_agents" validate:"omitempty,min=1"`
+	AllowedOS       []string   `json:"allowed_os"`
+	AllowedArch     []string   `json:"allowed_arch"`
+}
+
+// TagRule adds Tag to an enrolling agent when Field ("os", "arch" or
+// "hostname") matches the Match regex. Tag may reference capture groups ($1).
+type TagRule struct {
+	Field string `json:"field" validate:"required,oneof=os arch hostname"`
+	Match string `json:"match" validate:"required"`
+	Tag   string `json:"tag" validate:"required"`
 }
 
 type CreateBootstrapTokenResponse struct {
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// AgentCredential is a row of agent_credentials.
+type AgentCredential struct {
+	ID            string     `db:"id" json:"id"`
+	AgentID       string     `db:"agent_id" json:"agent_id"`
+	PublicKey     string     `db:"public_key" json:"public_key"`
+	IsPinned      bool       `db:"is_pinned" json:"is_pinned"`
+	JWTExpiresAt  time.Time  `db:"jwt_expires_at" json:"jwt_expires_at"`
+	RenewalCount  int        `db:"renewal_count" json:"renewal_count"`
+	LastRenewedAt *time.Time `db:"last_renewed_at" json:"last_renewed_at,omitempty"`
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
+	RevokedAt     *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+}
+
+// CredentialRevocation is a revoked agent public key that must stay in the
+// agents account JWT until every JWT issued for it has expired.
+type CredentialRevocation struct {
+	PublicKey    string    `db:"public_key" json:"public_key"`
+	AgentID      string    `db:"agent_id" json:"agent_id"`
+	RevokedAt    time.Time `db:"revoked_at" json:"revoked_at"`
+	JWTExpiresAt time.Time `db:"jwt_expires_at" json:"jwt_expires_at"`
+}
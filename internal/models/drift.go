@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+// IncidentTypeInventoryDrift is the incident type raised for notable changes
+// between consecutive inventory snapshots.
+const IncidentTypeInventoryDrift = "inventory_drift"
+
+// Drift rules.
+const (
+	DriftNewPort        = "new_port"
+	DriftRemovedService = "removed_service"
+	DriftKernelChange   = "kernel_change"
+	DriftNewContainer   = "new_container"
+)
+
+// DriftPolicy controls which inventory changes raise drift incidents for an
+// org. Agents carrying any of SilencedTags never raise them.
+type DriftPolicy struct {
+	OrgID             string     `json:"org_id"`
+	Enabled           bool       `json:"enabled"`
+	NewPorts          bool       `json:"new_ports"`
+	RemovedServices   bool       `json:"removed_services"`
+	KernelChange      bool       `json:"kernel_change"`
+	NewContainers     bool       `json:"new_containers"`
+	IgnoredPorts      []int      `json:"ignored_ports"`
+	AllowedContainers []string   `json:"allowed_containers"`
+	SilencedTags      []string   `json:"silenced_tags"`
+	UpdatedBy         *string    `json:"updated_by,omitempty"`
+	UpdatedAt         *time.Time `json:"updated_at,omitempty"`
+}
+
+// DefaultDriftPolicy enables every rule.
+func DefaultDriftPolicy(orgID string) *DriftPolicy {
+	return &DriftPolicy{
+		OrgID:             orgID,
+		Enabled:           true,
+		NewPorts:          true,
+		RemovedServices:   true,
+		KernelChange:      true,
+		NewContainers:     true,
+		IgnoredPorts:      []int{},
+		AllowedContainers: []string{},
+		SilencedTags:      []string{},
+	}
+}
+
+// UpdateDriftPolicyInput replaces an org's drift policy. AllowedContainers
+// are glob patterns (path.Match) matched against container names.
+type UpdateDriftPolicyInput struct {
+	Enabled           bool     `json:"enabled"`
+	NewPorts          bool     `json:"new_ports"`
+	RemovedServices   bool     `json:"removed_services"`
+	KernelChange      bool     `json:"kernel_change"`
+	NewContainers     bool     `json:"new_containers"`
+	IgnoredPorts      []int    `json:"ignored_ports"`
+	AllowedContainers []string `json:"allowed_containers"`
+	SilencedTags      []string `json:"silenced_tags"`
+}
+
+// DriftFinding is one notable change between two inventory snapshots.
+type DriftFinding struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
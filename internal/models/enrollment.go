@@ -26,6 +26,46 @@ type EnrollAgentResponse struct {
 	NATSURLs  []string `json:"nats_urls"`
 	Tags      []string `json:"tags"`
 	ExpiresAt string   `json:"expires_at"`
+	// Protocol lists the message versions the backend accepts.
+	Protocol ProtocolVersions `json:"protocol"`
+}
+
+// POST /api/v1/agents/enroll response when the bootstrap token requires approval.
+type EnrollmentPendingResponse struct {
+	AgentID      string `json:"agent_id"`
+	OrgID        string `json:"org_id"`
+	EnrollmentID string `json:"enrollment_id"`
+	Status       string `json:"status"`
+}
+
+// POST /api/v1/agents/enroll/status request (agent polling for approval).
+// Signature is over nonce + timestamp (Unix ms), signed with the enrolled key.
+type EnrollmentStatusRequest struct {
+	AgentID   string `json:"agent_id" validate:"required,len=12,hexadecimal,lowercase"`
+	PublicKey string `json:"public_key" validate:"required,startswith=U"`
+	Nonce     string `json:"nonce" validate:"required"`
+	Timestamp int64  `json:"timestamp" validate:"required"`
+	Signature string `json:"signature" validate:"required"`
+}
+
+// AgentEnrollment is an enrollment request waiting for (or past) admin approval.
+// Status: pending -> approved -> issued, or pending -> rejected.
+type AgentEnrollment struct {
+	ID                  string     `db:"id" json:"id"`
+	AgentID             string     `db:"agent_id" json:"agent_id"`
+	OrgID               string     `db:"org_id" json:"org_id"`
+	BootstrapTokenID    *string    `db:"bootstrap_token_id" json:"bootstrap_token_id,omitempty"`
+	PublicKey           string     `db:"public_key" json:"public_key"`
+	Hostname            string     `db:"hostname" json:"hostname"`
+	HardwareFingerprint string     `db:"hardware_fingerprint" json:"hardware_fingerprint"`
+	RemoteIP            string     `db:"remote_ip" json:"remote_ip"`
+	OS                  string     `db:"os" json:"os"`
+	Arch                string     `db:"arch" json:"arch"`
+	AgentVersion        string     `db:"agent_version" json:"agent_version"`
+	Status              string     `db:"status" json:"status"`
+	DecidedBy           *string    `db:"decided_by" json:"decided_by,omitempty"`
+	DecidedAt           *time.Time `db:"decided_at" json:"decided_at,omitempty"`
+	CreatedAt           time.Time  `db:"created_at" json:"created_at"`
 }
 
 type AgentConnection struct {
@@ -51,3 +91,17 @@ type AgentConflict struct {
 	CreatedAt        time.Time  `db:"created_at" json:"created_at"`
 	ResolvedAt       *time.Time `db:"resolved_at" json:"resolved_at,omitempty"`
 }
+
+// AgentFingerprint is one hardware fingerprint observed for an agent
+// (source: enrollment or heartbeat).
+type AgentFingerprint struct {
+	ID          string    `db:"id" json:"id"`
+	AgentID     string    `db:"agent_id" json:"agent_id"`
+	Fingerprint string    `db:"fingerprint" json:"fingerprint"`
+	Source      string    `db:"source" json:"source"`
+	RemoteIP    string    `db:"remote_ip" json:"remote_ip,omitempty"`
+	SeenCount   int       `db:"seen_count" json:"seen_count"`
+	FirstSeenAt time.Time `db:"first_seen_at" json:"first_seen_at"`
+	LastSeenAt  time.Time `db:"last_seen_at" json:"last_seen_at"`
+	Pinned      bool      `db:"pinned" json:"pinned"`
+}
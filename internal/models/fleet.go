@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+// FleetQuery filters the latest inventory of an org's agents. Zero values
+// are ignored. Port, Process and Source select candidates; a host matches
+// when at least one candidate satisfies all of them.
+type FleetQuery struct {
+	Port            int
+	Process         string
+	Source          string
+	Platform        string
+	PlatformVersion string
+	KernelVersion   string
+	MinRAM          int64
+	MaxRAM          int64
+	Limit           int
+}
+
+// Candidate sources accepted by FleetQuery.Source.
+const (
+	CandidateSourceDocker  = "docker"
+	CandidateSourceSystemd = "systemd"
+)
+
+// FleetHost is an agent matching a FleetQuery, with the candidates that
+// matched the candidate filters (all candidates when there are none).
+type FleetHost struct {
+	AgentID         string           `json:"agent_id"`
+	Name            string           `json:"name"`
+	Hostname        string           `json:"hostname"`
+	Status          string           `json:"status"`
+	InventoryAt     time.Time        `json:"inventory_at"`
+	Platform        string           `json:"platform"`
+	PlatformVersion string           `json:"platform_version"`
+	KernelVersion   string           `json:"kernel_version"`
+	CPUModel        string           `json:"cpu_model"`
+	RAMTotal        int64            `json:"ram_total"`
+	Candidates      []FleetCandidate `json:"candidates"`
+}
+
+type FleetCandidate struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	Cmdline       string `json:"cmdline"`
+	PID           int    `json:"pid"`
+	ListenPorts   []int  `json:"listen_ports"`
+	SourceSystemd string `json:"source_systemd,omitempty"`
+	SourceDocker  string `json:"source_docker,omitempty"`
+}
+
+// FacetCount is the number of matching agents sharing a field value.
+type FacetCount struct {
+	Value string `json:"value" db:"value"`
+	Count int    `json:"count" db:"count"`
+}
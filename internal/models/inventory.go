@@ -0,0 +1,17 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// InventorySnapshot is a stored inventory row; Payload is the JSON encoding
+// of Inventory and is omitted from history listings. ID is 0 for the latest
+// snapshot once retention purged its history row.
+type InventorySnapshot struct {
+	ID      int64           `db:"id" json:"id"`
+	AgentID string          `db:"agent_id" json:"agent_id"`
+	TS      time.Time       `db:"ts" json:"ts"`
+	Hash    string          `db:"hash" json:"hash"`
+	Payload json.RawMessage `db:"payload" json:"payload,omitempty"`
+}
@@ -32,6 +32,7 @@ type Incident struct {
 	SuggestedActionJSON []byte                 `json:"-" db:"suggested_action"`
 	Status              string                 `json:"status" db:"status"`
 	CreatedAt           time.Time              `json:"created_at" db:"created_at"`
+	ResolvedAt          *time.Time             `json:"resolved_at,omitempty" db:"resolved_at"`
 }
 
 type CommandPayload struct {
@@ -3,13 +3,24 @@ package models
 import "time"
 
 type Organization struct {
-	ID        string    `db:"id" json:"id"`
-	Name      string    `db:"name" json:"name"`
-	Slug      string    `db:"slug" json:"slug"`
-	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	ID   string `db:"id" json:"id"`
+	Name string `db:"name" json:"name"`
+	Slug string `db:"slug" json:"slug"`
+	// AllowServerKeygen permits the legacy flows that generate agent nkeys on
+	// the server and return the seed.
+	AllowServerKeygen bool `db:"allow_server_keygen" json:"allow_server_keygen"`
+	// MinAgentVersion flags agents running an older version as outdated.
+	MinAgentVersion string    `db:"min_agent_version" json:"min_agent_version"`
+	CreatedAt       time.Time `db:"created_at" json:"created_at"`
 }
 
 type CreateOrganizationInput struct {
 	Name string `json:"name" validate:"required,min=2,max=255"`
 	Slug string `json:"slug" validate:"required,min=2,max=63,slug"`
 }
+
+type UpdateOrganizationInput struct {
+	AllowServerKeygen *bool `json:"allow_server_keygen"`
+	// MinAgentVersion of "" clears the policy.
+	MinAgentVersion *string `json:"min_agent_version"`
+}
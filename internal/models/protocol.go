@@ -1,5 +1,10 @@
 package models
 
+import (
+	"encoding/json"
+	"strings"
+)
+
 // Event is the wire format for JetStream events from agents.
 type Event struct {
 	V         int                    `msgpack:"v"`
@@ -27,6 +32,18 @@ type Heartbeat struct {
 	Watchers       int        `msgpack:"watchers"`
 	Actions        []string   `msgpack:"actions"`
 	Inventory      *Inventory `msgpack:"inventory,omitempty"`
+	// HardwareFingerprint is optional; when present it is checked against the pinned one.
+	HardwareFingerprint string `msgpack:"hardware_fingerprint,omitempty"`
+	// ConfigVersion is the AGENT_CONFIG version the agent has applied.
+	ConfigVersion int64 `msgpack:"config_version,omitempty"`
+}
+
+// DesiredConfig is the wire format of AGENT_CONFIG KV entries (key {agent_id}).
+type DesiredConfig struct {
+	V       int         `msgpack:"v"`
+	AgentID string      `msgpack:"agent_id"`
+	Version int64       `msgpack:"version"`
+	Config  AgentConfig `msgpack:"config"`
 }
 
 // Inventory is the discovery data sent on first heartbeat.
@@ -77,6 +94,42 @@ type ActionResponseV3 struct {
 	Truncated  bool   `msgpack:"truncated"`
 }
 
+// RenewRequest is sent by agents on ops.{agent_id}.auth.renew.
+// Signature is over nonce + timestamp (Unix ms), same as enrollment.
+type RenewRequest struct {
+	AgentID   string `msgpack:"agent_id"`
+	PublicKey string `msgpack:"public_key"`
+	Nonce     string `msgpack:"nonce"`
+	Timestamp int64  `msgpack:"timestamp"`
+	Signature string `msgpack:"signature"`
+}
+
+// RenewResponse is the reply to a RenewRequest.
+type RenewResponse struct {
+	Success   bool   `msgpack:"success"`
+	JWT       string `msgpack:"jwt,omitempty"`
+	ExpiresAt int64  `msgpack:"expires_at,omitempty"`
+	Error     string `msgpack:"error,omitempty"`
+	ErrorCode string `msgpack:"error_code,omitempty"`
+	// Protocol is set on success so agents can pick the newest version
+	// both sides support.
+	Protocol *ProtocolVersions `msgpack:"protocol,omitempty"`
+}
+
+// ProtocolVersions lists the message versions the backend accepts.
+type ProtocolVersions struct {
+	Events     []int `json:"events" msgpack:"events"`
+	Heartbeats []int `json:"heartbeats" msgpack:"heartbeats"`
+}
+
+// ProtocolStat counts decoded agent messages by kind, version and result.
+type ProtocolStat struct {
+	Kind    string `json:"kind"`
+	Version int    `json:"version"`
+	Result  string `json:"result"`
+	Count   uint64 `json:"count"`
+}
+
 // Helper method to extract source from event details (same logic as v2).
 func (e *Event) GetSource() string {
 	for _, key := range []string{"source", "container_name", "service", "path"} {
@@ -98,3 +151,23 @@ func (e *Event) GetLogs() string {
 	}
 	return ""
 }
+
+// ToIncident builds the new incident an event raises.
+func (e *Event) ToIncident() *Incident {
+	rawError := e.Message
+	if logs := e.GetLogs(); logs != "" {
+		rawError = e.Message + "\n\n" + logs
+	}
+	rawError = strings.ReplaceAll(rawError, "\x00", "")
+
+	contextJSON, _ := json.Marshal(e.Details)
+
+	return &Incident{
+		AgentID:     e.AgentID,
+		Type:        e.AlertType,
+		Source:      e.GetSource(),
+		RawError:    rawError,
+		ContextJSON: contextJSON,
+		Status:      "new",
+	}
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// QuarantinedEvent is an event from an agent ID that was not enrolled in an
+// organization when it arrived. Payload is the raw msgpack message.
+type QuarantinedEvent struct {
+	ID         int64     `json:"id"`
+	AgentID    string    `json:"agent_id"`
+	Subject    string    `json:"subject"`
+	AlertType  string    `json:"alert_type"`
+	Message    string    `json:"message"`
+	Payload    []byte    `json:"-"`
+	ReceivedAt time.Time `json:"received_at"`
+}
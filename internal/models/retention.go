@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Retention defaults for orgs without a stored policy.
+const (
+	DefaultResolvedIncidentDays = 90
+	DefaultInventoryDays        = 30
+)
+
+// RetentionPolicy controls how long an org's resolved incidents and inventory
+// snapshots are kept. The latest snapshot per agent is always kept.
+type RetentionPolicy struct {
+	OrgID                string     `db:"org_id" json:"org_id"`
+	ResolvedIncidentDays int        `db:"resolved_incident_days" json:"resolved_incident_days"`
+	InventoryDays        int        `db:"inventory_days" json:"inventory_days"`
+	UpdatedBy            *string    `db:"updated_by" json:"updated_by,omitempty"`
+	UpdatedAt            *time.Time `db:"updated_at" json:"updated_at,omitempty"`
+}
+
+type UpdateRetentionPolicyInput struct {
+	ResolvedIncidentDays int `json:"resolved_incident_days" validate:"min=1"`
+	InventoryDays        int `json:"inventory_days" validate:"min=1"`
+}
+
+// Partition is a monthly partition of incidents or agents_inventory.
+type Partition struct {
+	Name string    `json:"name"`
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
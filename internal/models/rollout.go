@@ -0,0 +1,104 @@
+package models
+
+import "time"
+
+// AgentVersionRecord is one version change of an agent.
+type AgentVersionRecord struct {
+	AgentID         string    `json:"agent_id" db:"agent_id"`
+	Version         string    `json:"version" db:"version"`
+	PreviousVersion *string   `json:"previous_version,omitempty" db:"previous_version"`
+	Source          string    `json:"source" db:"source"`
+	SeenAt          time.Time `json:"seen_at" db:"seen_at"`
+}
+
+// Version sources.
+const (
+	VersionSourceEnrollment = "enrollment"
+	VersionSourceHeartbeat  = "heartbeat"
+)
+
+// VersionCount is the number of an org's agents running a version.
+type VersionCount struct {
+	Version  string `json:"version" db:"version"`
+	Count    int    `json:"count" db:"count"`
+	Outdated bool   `json:"outdated" db:"-"`
+}
+
+// AgentVersionInfo is an agent with its current version.
+type AgentVersionInfo struct {
+	AgentID  string `json:"agent_id" db:"agent_id"`
+	Hostname string `json:"hostname" db:"hostname"`
+	Status   string `json:"status" db:"status"`
+	Version  string `json:"version" db:"version"`
+}
+
+// Rollout statuses.
+const (
+	RolloutRunning   = "running"
+	RolloutPaused    = "paused"
+	RolloutCompleted = "completed"
+	RolloutFailed    = "failed"
+	RolloutCancelled = "cancelled"
+)
+
+// Rollout target statuses.
+const (
+	RolloutTargetPending   = "pending"
+	RolloutTargetSent      = "sent"
+	RolloutTargetSucceeded = "succeeded"
+	RolloutTargetFailed    = "failed"
+	RolloutTargetSkipped   = "skipped"
+)
+
+// UpdateAgentAction is the RPC action sent to agents during a rollout.
+const UpdateAgentAction = "update_agent"
+
+// Rollout sends update_agent to an org's agents matching Tags in waves of
+// BatchSize. A wave is healthy once its agents report TargetVersion and are
+// online; the next wave starts WaveIntervalSeconds later. More than
+// MaxFailures failed agents stop the rollout.
+type Rollout struct {
+	ID                   string     `json:"id" db:"id"`
+	OrgID                string     `json:"org_id" db:"org_id"`
+	TargetVersion        string     `json:"target_version" db:"target_version"`
+	DownloadURL          *string    `json:"download_url,omitempty" db:"download_url"`
+	Tags                 []string   `json:"tags" db:"-"`
+	TagsJSON             []byte     `json:"-" db:"tags"`
+	BatchSize            int        `json:"batch_size" db:"batch_size"`
+	WaveIntervalSeconds  int        `json:"wave_interval_seconds" db:"wave_interval_seconds"`
+	HealthTimeoutSeconds int        `json:"health_timeout_seconds" db:"health_timeout_seconds"`
+	MaxFailures          int        `json:"max_failures" db:"max_failures"`
+	Status               string     `json:"status" db:"status"`
+	CurrentWave          int        `json:"current_wave" db:"current_wave"`
+	WaveCompletedAt      *time.Time `json:"wave_completed_at,omitempty" db:"wave_completed_at"`
+	Error                *string    `json:"error,omitempty" db:"error"`
+	CreatedBy            *string    `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt            time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt            time.Time  `json:"updated_at" db:"updated_at"`
+	FinishedAt           *time.Time `json:"finished_at,omitempty" db:"finished_at"`
+}
+
+// RolloutTarget is one agent of a rollout.
+type RolloutTarget struct {
+	RolloutID   string     `json:"rollout_id" db:"rollout_id"`
+	AgentID     string     `json:"agent_id" db:"agent_id"`
+	Wave        int        `json:"wave" db:"wave"`
+	Status      string     `json:"status" db:"status"`
+	FromVersion *string    `json:"from_version,omitempty" db:"from_version"`
+	SentAt      *time.Time `json:"sent_at,omitempty" db:"sent_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty" db:"finished_at"`
+	Error       *string    `json:"error,omitempty" db:"error"`
+	// AgentStatus and AgentVersion are the agent's current state.
+	AgentStatus  string `json:"agent_status" db:"agent_status"`
+	AgentVersion string `json:"agent_version" db:"agent_version"`
+}
+
+type CreateRolloutInput struct {
+	TargetVersion        string   `json:"target_version" validate:"required"`
+	DownloadURL          string   `json:"download_url,omitempty"`
+	Tags                 []string `json:"tags"`
+	BatchSize            int      `json:"batch_size" validate:"min=1"`
+	WaveIntervalSeconds  int      `json:"wave_interval_seconds"`
+	HealthTimeoutSeconds int      `json:"health_timeout_seconds"`
+	MaxFailures          int      `json:"max_failures"`
+}
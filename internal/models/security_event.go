@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Security event types.
+const (
+	SecurityEventNonceReplay         = "nonce_replay"
+	SecurityEventFingerprintMismatch = "fingerprint_mismatch"
+	// The agent_id in an event payload differs from the subject it was published on.
+	SecurityEventAgentIDMismatch = "event_agent_mismatch"
+)
+
+// SecurityEvent is an audit record for suspicious agent/enrollment activity.
+type SecurityEvent struct {
+	ID               string                 `json:"id"`
+	OrgID            string                 `json:"org_id,omitempty"`
+	AgentID          string                 `json:"agent_id,omitempty"`
+	BootstrapTokenID string                 `json:"bootstrap_token_id,omitempty"`
+	Type             string                 `json:"type"`
+	RemoteIP         string                 `json:"remote_ip,omitempty"`
+	Details          map[string]interface{} `json:"details,omitempty"`
+	CreatedAt        time.Time              `json:"created_at"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Service is a logical service clustered from process candidates across an
+// org's agents, e.g. every "nginx" candidate in the fleet.
+type Service struct {
+	ID            string     `json:"id" db:"id"`
+	OrgID         string     `json:"org_id" db:"org_id"`
+	Key           string     `json:"key" db:"key"`
+	Name          string     `json:"name" db:"name"`
+	Type          string     `json:"type" db:"type"`
+	Owner         *string    `json:"owner,omitempty" db:"owner"`
+	RunbookURL    *string    `json:"runbook_url,omitempty" db:"runbook_url"`
+	Description   *string    `json:"description,omitempty" db:"description"`
+	HostCount     int        `json:"host_count" db:"host_count"`
+	InstanceCount int        `json:"instance_count" db:"instance_count"`
+	FirstSeenAt   time.Time  `json:"first_seen_at" db:"first_seen_at"`
+	LastSeenAt    time.Time  `json:"last_seen_at" db:"last_seen_at"`
+	UpdatedBy     *string    `json:"updated_by,omitempty" db:"updated_by"`
+	UpdatedAt     *time.Time `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+// ServiceInstance is a service running on one agent. RemovedAt is set once
+// the candidate is no longer in the agent's inventory.
+type ServiceInstance struct {
+	ServiceID     string     `json:"service_id"`
+	AgentID       string     `json:"agent_id"`
+	Hostname      string     `json:"hostname"`
+	CandidateKey  string     `json:"candidate_key"`
+	SourceSystemd string     `json:"source_systemd,omitempty"`
+	SourceDocker  string     `json:"source_docker,omitempty"`
+	ListenPorts   []int      `json:"listen_ports"`
+	FirstSeenAt   time.Time  `json:"first_seen_at"`
+	LastSeenAt    time.Time  `json:"last_seen_at"`
+	RemovedAt     *time.Time `json:"removed_at,omitempty"`
+}
+
+// UpdateServiceInput annotates a service; nil fields are left unchanged and
+// empty strings clear them.
+type UpdateServiceInput struct {
+	Owner       *string `json:"owner"`
+	RunbookURL  *string `json:"runbook_url"`
+	Description *string `json:"description"`
+}
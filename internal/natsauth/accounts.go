@@ -0,0 +1,202 @@
+package natsauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+
+	"opspilot-backend/internal/storage"
+)
+
+const (
+	claimsUpdateSubject = "$SYS.REQ.CLAIMS.UPDATE"
+	claimsUpdateTimeout = 5 * time.Second
+)
+
+// AccountManager re-signs the agents account JWT with the current revocation
+// list and pushes it to the NATS account resolver.
+type AccountManager struct {
+	nc         *nats.Conn
+	store      *storage.Storage
+	signingKey nkeys.KeyPair
+	accountJWT string
+	accountID  string
+	mu         sync.Mutex
+	// syncRequests holds at most one pending RequestSync; Start drains it.
+	syncRequests chan struct{}
+}
+
+type claimsUpdateResponse struct {
+	Data *struct {
+		Account string `json:"account"`
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"data,omitempty"`
+	Error *struct {
+		Account     string `json:"account"`
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error,omitempty"`
+}
+
+// NewAccountManager validates the operator signing key and the base account JWT.
+// The base JWT is used as a template: everything except the revocation list is kept.
+func NewAccountManager(nc *nats.Conn, store *storage.Storage, operatorSigningKeySeed, accountJWT string) (*AccountManager, error) {
+	if nc == nil {
+		return nil, fmt.Errorf("missing NATS connection")
+	}
+
+	kp, err := nkeys.FromSeed([]byte(operatorSigningKeySeed))
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS operator signing key seed: %w", err)
+	}
+	pub, err := kp.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS operator signing key seed: %w", err)
+	}
+	if !nkeys.IsValidPublicOperatorKey(pub) {
+		return nil, fmt.Errorf("NATS operator signing key is not an operator key")
+	}
+
+	accountJWT = strings.TrimSpace(accountJWT)
+	if accountJWT == "" {
+		return nil, fmt.Errorf("missing NATS agents account JWT")
+	}
+	claims, err := jwt.DecodeAccountClaims(accountJWT)
+	if err != nil {
+		return nil, fmt.Errorf("decode agents account JWT: %w", err)
+	}
+
+	return &AccountManager{
+		nc:           nc,
+		store:        store,
+		signingKey:   kp,
+		accountJWT:   accountJWT,
+		accountID:    claims.Subject,
+		syncRequests: make(chan struct{}, 1),
+	}, nil
+}
+
+// NewAccountManagerFromEnv builds an AccountManager from NATS_OPERATOR_SIGNING_KEY_SEED
+// and NATS_AGENTS_ACCOUNT_JWT (or NATS_AGENTS_ACCOUNT_JWT_FILE).
+func NewAccountManagerFromEnv(nc *nats.Conn, store *storage.Storage) (*AccountManager, error) {
+	seed := strings.TrimSpace(os.Getenv("NATS_OPERATOR_SIGNING_KEY_SEED"))
+	if seed == "" {
+		return nil, fmt.Errorf("missing NATS_OPERATOR_SIGNING_KEY_SEED")
+	}
+
+	accountJWT := strings.TrimSpace(os.Getenv("NATS_AGENTS_ACCOUNT_JWT"))
+	if accountJWT == "" {
+		if path := strings.TrimSpace(os.Getenv("NATS_AGENTS_ACCOUNT_JWT_FILE")); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("read NATS_AGENTS_ACCOUNT_JWT_FILE: %w", err)
+			}
+			accountJWT = string(data)
+		}
+	}
+
+	m, err := NewAccountManager(nc, store, seed, accountJWT)
+	if err != nil {
+		return nil, err
+	}
+
+	if expected := strings.TrimSpace(os.Getenv("NATS_AGENTS_ACCOUNT_PUBLIC_KEY")); expected != "" && expected != m.accountID {
+		return nil, fmt.Errorf("agents account JWT subject %s does not match NATS_AGENTS_ACCOUNT_PUBLIC_KEY", m.accountID)
+	}
+	return m, nil
+}
+
+// Start pushes the account JWT once, then on RequestSync and periodically, so
+// the resolver converges after restarts and failed pushes, and expired
+// revocations drop out of the list.
+func (m *AccountManager) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		m.sync(ctx)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-m.syncRequests:
+				m.sync(ctx)
+			case <-ticker.C:
+				m.sync(ctx)
+			}
+		}
+	}()
+	slog.InfoContext(ctx, "NATS account revocation sync started")
+}
+
+// RequestSync asks the Start loop to push the revocation list without
+// waiting for it. Requests made while one is pending are coalesced.
+func (m *AccountManager) RequestSync() {
+	select {
+	case m.syncRequests <- struct{}{}:
+	default:
+	}
+}
+
+func (m *AccountManager) sync(ctx context.Context) {
+	if err := m.SyncRevocations(ctx); err != nil {
+		slog.WarnContext(ctx, "NATS account revocation sync failed", "err", err)
+	}
+}
+
+// SyncRevocations rebuilds the account revocation list from the database and
+// pushes the re-signed account JWT to the resolver.
+func (m *AccountManager) SyncRevocations(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.store.PruneExpiredRevocations(ctx); err != nil {
+		slog.WarnContext(ctx, "NATS account revocation prune failed", "err", err)
+	}
+
+	revocations, err := m.store.ListActiveRevocations(ctx)
+	if err != nil {
+		return fmt.Errorf("list revocations: %w", err)
+	}
+
+	claims, err := jwt.DecodeAccountClaims(m.accountJWT)
+	if err != nil {
+		return fmt.Errorf("decode agents account JWT: %w", err)
+	}
+	claims.Revocations = jwt.RevocationList{}
+	for _, rev := range revocations {
+		claims.RevokeAt(rev.PublicKey, rev.RevokedAt)
+	}
+
+	token, err := claims.Encode(m.signingKey)
+	if err != nil {
+		return fmt.Errorf("encode agents account JWT: %w", err)
+	}
+
+	msg, err := m.nc.Request(claimsUpdateSubject, []byte(token), claimsUpdateTimeout)
+	if err != nil {
+		return fmt.Errorf("push account JWT: %w", err)
+	}
+
+	var resp claimsUpdateResponse
+	if err := json.Unmarshal(msg.Data, &resp); err != nil {
+		return fmt.Errorf("decode claims update response: %w", err)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("claims update rejected (%d): %s", resp.Error.Code, resp.Error.Description)
+	}
+
+	slog.Info("NATS account updated", "account", m.accountID, "revocations", len(revocations))
+	return nil
+}
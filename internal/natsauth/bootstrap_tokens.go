@@ -2,12 +2,13 @@ package natsauth
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"opspilot-backend/internal/auth"
 	"opspilot-backend/internal/models"
+	"opspilot-backend/internal/tokenpolicy"
 )
 
 // GET /api/v1/bootstrap-tokens
@@ -20,7 +21,7 @@ func (h *Handler) ListBootstrapTokens(w http.ResponseWriter, r *http.Request) {
 
 	user, err := h.storage.GetUser(r.Context(), userID)
 	if err != nil {
-		log.Printf("ERROR bootstrap tokens: load user: %v", err)
+		slog.ErrorContext(r.Context(), "bootstrap tokens: load user", "err", err)
 		respondError(w, http.StatusInternalServerError, "failed to load user")
 		return
 	}
@@ -31,7 +32,7 @@ func (h *Handler) ListBootstrapTokens(w http.ResponseWriter, r *http.Request) {
 
 	tokens, err := h.storage.GetBootstrapTokens(r.Context(), user.OrgID)
 	if err != nil {
-		log.Printf("ERROR bootstrap tokens: list org_id=%s: %v", user.OrgID, err)
+		slog.ErrorContext(r.Context(), "bootstrap tokens: list", "org_id", user.OrgID, "err", err)
 		respondError(w, http.StatusInternalServerError, "failed to list tokens")
 		return
 	}
@@ -49,7 +50,7 @@ func (h *Handler) CreateBootstrapToken(w http.ResponseWriter, r *http.Request) {
 
 	user, err := h.storage.GetUser(r.Context(), userID)
 	if err != nil {
-		log.Printf("ERROR bootstrap tokens: load user: %v", err)
+		slog.ErrorContext(r.Context(), "bootstrap tokens: load user", "err", err)
 		respondError(w, http.StatusInternalServerError, "failed to load user")
 		return
 	}
@@ -64,9 +65,14 @@ func (h *Handler) CreateBootstrapToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := tokenpolicy.Validate(req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	result, err := h.storage.CreateBootstrapToken(r.Context(), user.OrgID, userID, req)
 	if err != nil {
-		log.Printf("ERROR bootstrap tokens: create org_id=%s user_id=%s: %v", user.OrgID, userID, err)
+		slog.ErrorContext(r.Context(), "bootstrap tokens: create", "org_id", user.OrgID, "user_id", userID, "err", err)
 		respondError(w, http.StatusInternalServerError, "failed to create token")
 		return
 	}
@@ -84,7 +90,7 @@ func (h *Handler) GetBootstrapToken(w http.ResponseWriter, r *http.Request) {
 
 	user, err := h.storage.GetUser(r.Context(), userID)
 	if err != nil {
-		log.Printf("ERROR bootstrap tokens: load user: %v", err)
+		slog.ErrorContext(r.Context(), "bootstrap tokens: load user", "err", err)
 		respondError(w, http.StatusInternalServerError, "failed to load user")
 		return
 	}
@@ -101,7 +107,7 @@ func (h *Handler) GetBootstrapToken(w http.ResponseWriter, r *http.Request) {
 
 	token, err := h.storage.GetBootstrapToken(r.Context(), tokenID)
 	if err != nil {
-		log.Printf("ERROR bootstrap tokens: load token id=%s: %v", tokenID, err)
+		slog.ErrorContext(r.Context(), "bootstrap tokens: load token", "id", tokenID, "err", err)
 		respondError(w, http.StatusInternalServerError, "failed to load token")
 		return
 	}
@@ -123,7 +129,7 @@ func (h *Handler) RevokeBootstrapToken(w http.ResponseWriter, r *http.Request) {
 
 	user, err := h.storage.GetUser(r.Context(), userID)
 	if err != nil {
-		log.Printf("ERROR bootstrap tokens: load user: %v", err)
+		slog.ErrorContext(r.Context(), "bootstrap tokens: load user", "err", err)
 		respondError(w, http.StatusInternalServerError, "failed to load user")
 		return
 	}
@@ -140,7 +146,7 @@ func (h *Handler) RevokeBootstrapToken(w http.ResponseWriter, r *http.Request) {
 
 	token, err := h.storage.GetBootstrapToken(r.Context(), tokenID)
 	if err != nil {
-		log.Printf("ERROR bootstrap tokens: load token id=%s: %v", tokenID, err)
+		slog.ErrorContext(r.Context(), "bootstrap tokens: load token", "id", tokenID, "err", err)
 		respondError(w, http.StatusInternalServerError, "failed to load token")
 		return
 	}
@@ -150,7 +156,7 @@ func (h *Handler) RevokeBootstrapToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.storage.RevokeBootstrapToken(r.Context(), tokenID); err != nil {
-		log.Printf("ERROR bootstrap tokens: revoke id=%s: %v", tokenID, err)
+		slog.ErrorContext(r.Context(), "bootstrap tokens: revoke", "id", tokenID, "err", err)
 		respondError(w, http.StatusInternalServerError, "failed to revoke token")
 		return
 	}
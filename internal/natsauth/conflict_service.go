@@ -2,7 +2,7 @@ package natsauth
 
 import (
 	"context"
-	"log"
+	"log/slog"
 
 	"opspilot-backend/internal/models"
 	"opspilot-backend/internal/storage"
@@ -19,7 +19,7 @@ func NewConflictService(store *storage.Storage) *ConflictService {
 func (s *ConflictService) OnAgentConnect(ctx context.Context, agentID, remoteIP, hostname, natsClientID string) {
 	existing, err := s.store.GetActiveConnection(ctx, agentID)
 	if err != nil {
-		log.Printf("ERROR conflict check failed: %v", err)
+		slog.ErrorContext(ctx, "conflict check failed", "err", err)
 	}
 
 	if existing != nil && existing.RemoteIP != remoteIP {
@@ -32,10 +32,10 @@ func (s *ConflictService) OnAgentConnect(ctx context.Context, agentID, remoteIP,
 			Resolution:       "pending",
 		}
 		if err := s.store.RecordAgentConflict(ctx, conflict); err != nil {
-			log.Printf("ERROR conflict record failed: %v", err)
+			slog.ErrorContext(ctx, "conflict record failed", "err", err)
 		}
 
-		if agent, err := s.store.GetAgentByAgentID(agentID); err == nil && agent != nil && agent.OrgID != "" {
+		if agent, err := s.store.GetAgentByAgentID(ctx, agentID); err == nil && agent != nil && agent.OrgID != "" {
 			PublishConflictEvent(agent.OrgID, conflict)
 		}
 	}
@@ -46,12 +46,12 @@ func (s *ConflictService) OnAgentConnect(ctx context.Context, agentID, remoteIP,
 		RemoteIP:     remoteIP,
 		Hostname:     hostname,
 	}); err != nil {
-		log.Printf("ERROR conflict connection record failed: %v", err)
+		slog.ErrorContext(ctx, "conflict connection record failed", "err", err)
 	}
 }
 
 func (s *ConflictService) OnAgentDisconnect(ctx context.Context, agentID string, reason string) {
 	if err := s.store.RecordAgentDisconnect(ctx, agentID, reason); err != nil {
-		log.Printf("ERROR conflict disconnect record failed: %v", err)
+		slog.ErrorContext(ctx, "conflict disconnect record failed", "err", err)
 	}
 }
@@ -76,7 +76,7 @@ func (h *Handler) ResolveConflict(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	agent, err := h.storage.GetAgentByAgentID(conflict.AgentID)
+	agent, err := h.storage.GetAgentByAgentID(r.Context(), conflict.AgentID)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "failed to load agent")
 		return
@@ -3,27 +3,35 @@ package natsauth
 import (
 	"encoding/json"
 	"errors"
+	"log/slog"
 	"net"
 	"net/http"
 	"strings"
 	"time"
 
 	"opspilot-backend/internal/models"
+	"opspilot-backend/internal/protocol"
 	"opspilot-backend/internal/storage"
+	"opspilot-backend/internal/tokenpolicy"
 )
 
 type EnrollmentConfig struct {
 	NATSURLs []string
+	// BlockFingerprintChange refuses re-enrollment when the hardware
+	// fingerprint differs from the pinned one (it is always recorded).
+	BlockFingerprintChange bool
 }
 
 type EnrollmentHandler struct {
-	store  *storage.Storage
-	issuer *JWTIssuer
-	config EnrollmentConfig
+	store        *storage.Storage
+	issuer       *JWTIssuer
+	nonces       *NonceStore
+	fingerprints *FingerprintMonitor
+	config       EnrollmentConfig
 }
 
-func NewEnrollmentHandler(store *storage.Storage, issuer *JWTIssuer, cfg EnrollmentConfig) *EnrollmentHandler {
-	return &EnrollmentHandler{store: store, issuer: issuer, config: cfg}
+func NewEnrollmentHandler(store *storage.Storage, issuer *JWTIssuer, nonces *NonceStore, fingerprints *FingerprintMonitor, cfg EnrollmentConfig) *EnrollmentHandler {
+	return &EnrollmentHandler{store: store, issuer: issuer, nonces: nonces, fingerprints: fingerprints, config: cfg}
 }
 
 func (h *EnrollmentHandler) EnrollAgent(w http.ResponseWriter, r *http.Request) {
@@ -57,8 +65,8 @@ func (h *EnrollmentHandler) EnrollAgent(w http.ResponseWriter, r *http.Request)
 		respondError(w, http.StatusBadRequest, "missing required fields")
 		return
 	}
-	if len(req.AgentID) != 12 {
-		respondError(w, http.StatusBadRequest, "invalid agent_id")
+	if !protocol.ValidAgentID(req.AgentID) {
+		respondError(w, http.StatusBadRequest, "invalid agent_id: must be 12 lowercase hex characters")
 		return
 	}
 
@@ -67,7 +75,7 @@ func (h *EnrollmentHandler) EnrollAgent(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if !isTimestampFresh(req.Timestamp, 5*time.Minute) {
+	if !isTimestampFresh(req.Timestamp, signatureMaxSkew) {
 		respondError(w, http.StatusUnauthorized, "timestamp expired")
 		return
 	}
@@ -92,7 +100,20 @@ func (h *EnrollmentHandler) EnrollAgent(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	existing, err := h.store.GetAgentByAgentID(req.AgentID)
+	if !h.claimNonce(w, r, req.PublicKey, req.Nonce, models.SecurityEvent{
+		OrgID:            bt.OrgID,
+		AgentID:          req.AgentID,
+		BootstrapTokenID: bt.ID,
+		RemoteIP:         remoteIP,
+		Details: map[string]interface{}{
+			"endpoint": "enroll",
+			"hostname": req.Hostname,
+		},
+	}) {
+		return
+	}
+
+	existing, err := h.store.GetAgentByAgentID(r.Context(), req.AgentID)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "database error")
 		return
@@ -102,6 +123,16 @@ func (h *EnrollmentHandler) EnrollAgent(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := tokenpolicy.CheckPlatform(bt, req); err != nil {
+		switch {
+		case errors.Is(err, tokenpolicy.ErrOSNotAllowed):
+			respondError(w, http.StatusForbidden, "os not allowed by token")
+		default:
+			respondError(w, http.StatusForbidden, "arch not allowed by token")
+		}
+		return
+	}
+
 	existingKey, err := h.store.GetPinnedPublicKey(r.Context(), req.AgentID)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "database error")
@@ -112,18 +143,51 @@ func (h *EnrollmentHandler) EnrollAgent(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	agent, err := h.store.EnrollAgent(r.Context(), bt.OrgID, req, bt.ID, remoteIP)
+	if existing != nil {
+		mismatch, err := h.fingerprints.Observe(r.Context(), existing, req.HardwareFingerprint, FingerprintSourceEnrollment, remoteIP)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "fingerprint check", "agent_id", req.AgentID, "err", err)
+			respondError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		if mismatch && h.config.BlockFingerprintChange {
+			respondError(w, http.StatusForbidden, "hardware fingerprint does not match")
+			return
+		}
+	}
+
+	// Approval applies to first admission only; an agent re-enrolling with its
+	// pinned key is already known to the org.
+	if bt.RequireApproval && existingKey == "" {
+		h.enrollPending(w, r, req, bt, existing, remoteIP)
+		return
+	}
+
+	agent, err := h.store.EnrollAgent(r.Context(), req, storage.EnrollAgentParams{
+		OrgID:            bt.OrgID,
+		BootstrapTokenID: bt.ID,
+		RemoteIP:         remoteIP,
+		Name:             tokenpolicy.AgentName(bt.NameTemplate, req),
+		Tags:             tokenpolicy.DeriveTags(bt, req),
+	})
+	if errors.Is(err, storage.ErrTokenAgentLimitReached) {
+		respondError(w, http.StatusForbidden, "token active agent limit reached")
+		return
+	}
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "enrollment failed")
 		return
 	}
+	if existing == nil {
+		h.recordFirstFingerprint(r, agent, remoteIP)
+	}
 
 	if err := h.store.IncrementBootstrapTokenUsage(r.Context(), bt.ID); err != nil {
 		respondError(w, http.StatusInternalServerError, "failed to update token usage")
 		return
 	}
 
-	jwtToken, expiresAt, err := h.issuer.IssueAgentJWT(req.AgentID, req.PublicKey, 365*24*time.Hour)
+	jwtToken, expiresAt, err := h.issuer.IssueAgentJWT(req.AgentID, req.PublicKey, AgentJWTTTL())
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "failed to issue JWT")
 		return
@@ -150,9 +214,246 @@ func (h *EnrollmentHandler) EnrollAgent(w http.ResponseWriter, r *http.Request)
 		NATSURLs:  h.config.NATSURLs,
 		Tags:      agent.Tags,
 		ExpiresAt: expiresAt.Format(time.RFC3339),
+		Protocol:  protocol.Supported(),
+	})
+}
+
+// enrollPending stores the agent as pending and queues the enrollment for admin
+// approval. The agent polls /agents/enroll/status for its JWT.
+func (h *EnrollmentHandler) enrollPending(w http.ResponseWriter, r *http.Request, req models.EnrollAgentRequest, bt *models.BootstrapToken, existing *models.Agent, remoteIP string) {
+	latest, err := h.store.GetLatestEnrollment(r.Context(), req.AgentID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if latest != nil && (latest.Status == "pending" || latest.Status == "approved") {
+		if latest.PublicKey != req.PublicKey {
+			respondError(w, http.StatusConflict, "enrollment already pending with different key")
+			return
+		}
+		respondJSON(w, http.StatusAccepted, models.EnrollmentPendingResponse{
+			AgentID:      latest.AgentID,
+			OrgID:        latest.OrgID,
+			EnrollmentID: latest.ID,
+			Status:       latest.Status,
+		})
+		return
+	}
+
+	agent, err := h.store.EnrollAgent(r.Context(), req, storage.EnrollAgentParams{
+		OrgID:            bt.OrgID,
+		BootstrapTokenID: bt.ID,
+		RemoteIP:         remoteIP,
+		Name:             tokenpolicy.AgentName(bt.NameTemplate, req),
+		Tags:             tokenpolicy.DeriveTags(bt, req),
+		Status:           "pending",
+	})
+	if errors.Is(err, storage.ErrTokenAgentLimitReached) {
+		respondError(w, http.StatusForbidden, "token active agent limit reached")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "enrollment failed")
+		return
+	}
+	if existing == nil {
+		h.recordFirstFingerprint(r, agent, remoteIP)
+	}
+
+	tokenID := bt.ID
+	enrollment, err := h.store.CreatePendingEnrollment(r.Context(), models.AgentEnrollment{
+		AgentID:             req.AgentID,
+		OrgID:               bt.OrgID,
+		BootstrapTokenID:    &tokenID,
+		PublicKey:           req.PublicKey,
+		Hostname:            req.Hostname,
+		HardwareFingerprint: req.HardwareFingerprint,
+		RemoteIP:            remoteIP,
+		OS:                  req.OS,
+		Arch:                req.Arch,
+		AgentVersion:        req.AgentVersion,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "enrollment failed")
+		return
+	}
+
+	if err := h.store.IncrementBootstrapTokenUsage(r.Context(), bt.ID); err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to update token usage")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Enrollment pending approval", "agent_id", req.AgentID, "org_id", bt.OrgID, "hostname", req.Hostname, "ip", remoteIP)
+
+	respondJSON(w, http.StatusAccepted, models.EnrollmentPendingResponse{
+		AgentID:      agent.AgentID,
+		OrgID:        agent.OrgID,
+		EnrollmentID: enrollment.ID,
+		Status:       enrollment.Status,
+	})
+}
+
+// EnrollmentStatus is polled by agents waiting for approval. Once the enrollment
+// is approved, the first signed poll receives the JWT.
+func (h *EnrollmentHandler) EnrollmentStatus(w http.ResponseWriter, r *http.Request) {
+	if h.issuer == nil {
+		respondError(w, http.StatusInternalServerError, "NATS JWT issuer not configured")
+		return
+	}
+
+	var req models.EnrollmentStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	req.AgentID = strings.TrimSpace(req.AgentID)
+	req.PublicKey = strings.TrimSpace(req.PublicKey)
+	req.Nonce = strings.TrimSpace(req.Nonce)
+	req.Signature = strings.TrimSpace(req.Signature)
+
+	if req.AgentID == "" || req.PublicKey == "" || req.Nonce == "" || req.Timestamp == 0 || req.Signature == "" {
+		respondError(w, http.StatusBadRequest, "missing required fields")
+		return
+	}
+
+	if !VerifyNKeySignature(req.PublicKey, req.Nonce, req.Timestamp, req.Signature) {
+		respondError(w, http.StatusUnauthorized, "invalid signature")
+		return
+	}
+
+	if !isTimestampFresh(req.Timestamp, signatureMaxSkew) {
+		respondError(w, http.StatusUnauthorized, "timestamp expired")
+		return
+	}
+
+	enrollment, err := h.store.GetLatestEnrollment(r.Context(), req.AgentID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if enrollment == nil || enrollment.PublicKey != req.PublicKey {
+		respondError(w, http.StatusNotFound, "enrollment not found")
+		return
+	}
+
+	if !h.claimNonce(w, r, req.PublicKey, req.Nonce, models.SecurityEvent{
+		OrgID:            enrollment.OrgID,
+		AgentID:          req.AgentID,
+		BootstrapTokenID: ptrString(enrollment.BootstrapTokenID),
+		RemoteIP:         getClientIP(r),
+		Details: map[string]interface{}{
+			"endpoint":      "enroll_status",
+			"enrollment_id": enrollment.ID,
+		},
+	}) {
+		return
+	}
+
+	switch enrollment.Status {
+	case "pending":
+		respondJSON(w, http.StatusAccepted, models.EnrollmentPendingResponse{
+			AgentID:      enrollment.AgentID,
+			OrgID:        enrollment.OrgID,
+			EnrollmentID: enrollment.ID,
+			Status:       enrollment.Status,
+		})
+		return
+	case "rejected":
+		respondError(w, http.StatusForbidden, "enrollment rejected")
+		return
+	case "issued":
+		respondCredentialsIssued(w, req.AgentID)
+		return
+	case "approved":
+	default:
+		respondError(w, http.StatusInternalServerError, "unknown enrollment status")
+		return
+	}
+
+	agent, err := h.store.GetAgentByAgentID(r.Context(), req.AgentID)
+	if err != nil || agent == nil {
+		respondError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	jwtToken, expiresAt, err := h.issuer.IssueAgentJWT(req.AgentID, req.PublicKey, AgentJWTTTL())
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to issue JWT")
+		return
+	}
+
+	issued, err := h.store.IssueEnrollmentCredentials(r.Context(), enrollment, expiresAt)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "enrollment: store credentials", "agent_id", req.AgentID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to store credentials")
+		return
+	}
+	if !issued {
+		// A concurrent poll claimed the enrollment and returns the JWT.
+		respondCredentialsIssued(w, req.AgentID)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.EnrollAgentResponse{
+		AgentID:   agent.AgentID,
+		OrgID:     agent.OrgID,
+		JWT:       jwtToken,
+		NATSURLs:  h.config.NATSURLs,
+		Tags:      agent.Tags,
+		ExpiresAt: expiresAt.Format(time.RFC3339),
+		Protocol:  protocol.Supported(),
 	})
 }
 
+// respondCredentialsIssued answers polls after the JWT of an approved
+// enrollment was handed out: the status endpoint returns it only once, later
+// JWTs come from the signed renewal over NATS.
+func respondCredentialsIssued(w http.ResponseWriter, agentID string) {
+	respondError(w, http.StatusConflict, "credentials already issued; renew the JWT on ops."+agentID+".auth.renew")
+}
+
+// claimNonce rejects replayed signatures and records the attempt as a security
+// event. It writes the error response and returns false when the request must stop.
+func (h *EnrollmentHandler) claimNonce(w http.ResponseWriter, r *http.Request, publicKey, nonce string, event models.SecurityEvent) bool {
+	err := h.nonces.Claim(publicKey, nonce)
+	if err == nil {
+		return true
+	}
+	if !errors.Is(err, ErrNonceReplayed) {
+		slog.ErrorContext(r.Context(), "enrollment nonce check failed", "err", err)
+		respondError(w, http.StatusServiceUnavailable, "nonce check failed")
+		return false
+	}
+
+	event.Type = models.SecurityEventNonceReplay
+	if event.Details == nil {
+		event.Details = map[string]interface{}{}
+	}
+	event.Details["nonce"] = nonce
+	event.Details["public_key"] = publicKey
+	if err := h.store.RecordSecurityEvent(r.Context(), event); err != nil {
+		slog.ErrorContext(r.Context(), "record security event", "err", err)
+	}
+	slog.WarnContext(r.Context(), "Enrollment nonce replay", "agent_id", event.AgentID, "token", event.BootstrapTokenID, "ip", event.RemoteIP)
+
+	respondError(w, http.StatusUnauthorized, ErrNonceReplayed.Error())
+	return false
+}
+
+// recordFirstFingerprint starts the fingerprint history of a newly enrolled agent.
+func (h *EnrollmentHandler) recordFirstFingerprint(r *http.Request, agent *models.Agent, remoteIP string) {
+	if _, err := h.fingerprints.Observe(r.Context(), agent, agent.HardwareFingerprint, FingerprintSourceEnrollment, remoteIP); err != nil {
+		slog.WarnContext(r.Context(), "fingerprint history", "agent_id", agent.AgentID, "err", err)
+	}
+}
+
+func ptrString(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
 func isTimestampFresh(timestampMs int64, maxSkew time.Duration) bool {
 	stamp := time.UnixMilli(timestampMs)
 	return time.Since(stamp) <= maxSkew && time.Until(stamp) <= maxSkew
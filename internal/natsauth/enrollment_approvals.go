@@ -0,0 +1,113 @@
+package natsauth
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"opspilot-backend/internal/auth"
+	"opspilot-backend/internal/models"
+	"opspilot-backend/internal/storage"
+)
+
+// GET /api/v1/agents/enrollments?status=pending
+func (h *Handler) ListEnrollments(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	status := strings.TrimSpace(r.URL.Query().Get("status"))
+	if status == "" {
+		status = "pending"
+	}
+	if status == "all" {
+		status = ""
+	}
+
+	enrollments, err := h.storage.ListEnrollments(r.Context(), user.OrgID, status)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "enrollments: list", "org_id", user.OrgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to list enrollments")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, enrollments)
+}
+
+// POST /api/v1/agents/enrollments/{id}/approve
+func (h *Handler) ApproveEnrollment(w http.ResponseWriter, r *http.Request) {
+	h.decideEnrollment(w, r, "approved")
+}
+
+// POST /api/v1/agents/enrollments/{id}/reject
+func (h *Handler) RejectEnrollment(w http.ResponseWriter, r *http.Request) {
+	h.decideEnrollment(w, r, "rejected")
+}
+
+func (h *Handler) decideEnrollment(w http.ResponseWriter, r *http.Request, status string) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	enrollmentID := chi.URLParam(r, "id")
+	if enrollmentID == "" {
+		respondError(w, http.StatusBadRequest, "missing enrollment id")
+		return
+	}
+
+	enrollment, err := h.storage.GetEnrollment(r.Context(), enrollmentID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "enrollments: load", "id", enrollmentID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load enrollment")
+		return
+	}
+	if enrollment == nil || enrollment.OrgID != user.OrgID {
+		respondError(w, http.StatusNotFound, "enrollment not found")
+		return
+	}
+
+	if err := h.storage.DecideEnrollment(r.Context(), enrollmentID, status, user.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusConflict, "enrollment is not pending")
+			return
+		}
+		if errors.Is(err, storage.ErrTokenAgentLimitReached) {
+			respondError(w, http.StatusConflict, "token active agent limit reached")
+			return
+		}
+		slog.ErrorContext(r.Context(), "enrollments: decide", "id", enrollmentID, "status", status, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to update enrollment")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Enrollment decided", "agent_id", enrollment.AgentID, "org_id", user.OrgID, "status", status, "user_id", user.ID)
+	respondJSON(w, http.StatusOK, map[string]string{"status": status})
+}
+
+// orgUser loads the authenticated user and requires an org. It writes the
+// error response itself and returns false when the request must stop.
+func (h *Handler) orgUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "unauthorized")
+		return nil, false
+	}
+
+	user, err := h.storage.GetUser(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "load user", "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load user")
+		return nil, false
+	}
+	if user == nil || user.OrgID == "" {
+		respondError(w, http.StatusUnauthorized, "unauthorized")
+		return nil, false
+	}
+
+	return user, true
+}
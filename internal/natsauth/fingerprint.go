@@ -0,0 +1,103 @@
+package natsauth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"opspilot-backend/internal/models"
+	"opspilot-backend/internal/storage"
+)
+
+// Fingerprint observation sources.
+const (
+	FingerprintSourceEnrollment = "enrollment"
+	FingerprintSourceHeartbeat  = "heartbeat"
+)
+
+// FingerprintMonitor compares reported hardware fingerprints with the one
+// pinned on the agent and keeps the per-agent fingerprint history.
+type FingerprintMonitor struct {
+	store *storage.Storage
+}
+
+func NewFingerprintMonitor(store *storage.Storage) *FingerprintMonitor {
+	return &FingerprintMonitor{store: store}
+}
+
+// BlockFingerprintChangeFromEnv reports whether re-enrollment with a
+// fingerprint other than the pinned one must be refused.
+func BlockFingerprintChangeFromEnv() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("ENROLLMENT_BLOCK_FINGERPRINT_CHANGE"))) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// Observe records fingerprint for agent and reports whether it differs from
+// the pinned one. An agent without a pinned fingerprint gets this one pinned.
+// A security event and an incident are raised the first time a given
+// mismatching fingerprint is seen; repeats only update the history.
+func (m *FingerprintMonitor) Observe(ctx context.Context, agent *models.Agent, fingerprint, source, remoteIP string) (bool, error) {
+	fingerprint = strings.TrimSpace(fingerprint)
+	if agent == nil || fingerprint == "" {
+		return false, nil
+	}
+
+	firstSeen, err := m.store.ObserveFingerprint(ctx, agent.AgentID, fingerprint, source, remoteIP)
+	if err != nil {
+		return false, err
+	}
+
+	if agent.HardwareFingerprint == "" {
+		if err := m.store.PinFingerprint(ctx, agent.AgentID, fingerprint); err != nil {
+			return false, err
+		}
+		agent.HardwareFingerprint = fingerprint
+		return false, nil
+	}
+	if agent.HardwareFingerprint == fingerprint {
+		return false, nil
+	}
+
+	if firstSeen {
+		m.raise(ctx, agent, fingerprint, source, remoteIP)
+	}
+	return true, nil
+}
+
+func (m *FingerprintMonitor) raise(ctx context.Context, agent *models.Agent, fingerprint, source, remoteIP string) {
+	slog.WarnContext(ctx, "Hardware fingerprint mismatch", "agent_id", agent.AgentID, "source", source, "ip", remoteIP)
+
+	details := map[string]interface{}{
+		"source":   source,
+		"pinned":   agent.HardwareFingerprint,
+		"observed": fingerprint,
+	}
+
+	if err := m.store.RecordSecurityEvent(ctx, models.SecurityEvent{
+		OrgID:            agent.OrgID,
+		AgentID:          agent.AgentID,
+		BootstrapTokenID: ptrString(agent.EnrolledVia),
+		Type:             models.SecurityEventFingerprintMismatch,
+		RemoteIP:         remoteIP,
+		Details:          details,
+	}); err != nil {
+		slog.ErrorContext(ctx, "record security event", "err", err)
+	}
+
+	incident := &models.Incident{
+		AgentID:  agent.AgentID,
+		Type:     "security",
+		Source:   "hardware_fingerprint",
+		RawError: fmt.Sprintf("Hardware fingerprint changed (%s): pinned %s, observed %s", source, agent.HardwareFingerprint, fingerprint),
+		Context:  details,
+		Status:   "new",
+	}
+	if err := m.store.CreateIncident(ctx, incident); err != nil {
+		slog.ErrorContext(ctx, "fingerprint incident create", "err", err)
+	}
+}
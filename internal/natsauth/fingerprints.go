@@ -0,0 +1,90 @@
+package natsauth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"opspilot-backend/internal/models"
+)
+
+type PinFingerprintRequest struct {
+	Fingerprint string `json:"fingerprint"`
+}
+
+// GET /api/v1/agents/{id}/fingerprints
+func (h *Handler) ListFingerprints(w http.ResponseWriter, r *http.Request) {
+	agent, ok := h.orgAgent(w, r)
+	if !ok {
+		return
+	}
+
+	fingerprints, err := h.storage.ListFingerprints(r.Context(), agent.AgentID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "fingerprints: list", "agent_id", agent.AgentID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to list fingerprints")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, fingerprints)
+}
+
+// POST /api/v1/agents/{id}/fingerprints/pin
+// Accepts a previously observed fingerprint as the agent's trusted one (e.g.
+// after a legitimate hardware change).
+func (h *Handler) PinFingerprint(w http.ResponseWriter, r *http.Request) {
+	agent, ok := h.orgAgent(w, r)
+	if !ok {
+		return
+	}
+
+	var req PinFingerprintRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	req.Fingerprint = strings.TrimSpace(req.Fingerprint)
+	if req.Fingerprint == "" {
+		respondError(w, http.StatusBadRequest, "fingerprint is required")
+		return
+	}
+
+	if err := h.storage.PinFingerprint(r.Context(), agent.AgentID, req.Fingerprint); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "fingerprint not observed for this agent")
+			return
+		}
+		slog.ErrorContext(r.Context(), "fingerprints: pin", "agent_id", agent.AgentID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to pin fingerprint")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Hardware fingerprint pinned", "agent_id", agent.AgentID)
+	respondJSON(w, http.StatusOK, map[string]string{"hardware_fingerprint": req.Fingerprint})
+}
+
+// orgAgent loads the agent from the {id} URL param and requires it to belong
+// to the caller's org.
+func (h *Handler) orgAgent(w http.ResponseWriter, r *http.Request) (*models.Agent, bool) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	agentID := chi.URLParam(r, "id")
+	agent, err := h.storage.GetAgentByAgentID(r.Context(), agentID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to load agent")
+		return nil, false
+	}
+	if agent == nil || agent.OrgID != user.OrgID {
+		respondError(w, http.StatusNotFound, "agent not found")
+		return nil, false
+	}
+
+	return agent, true
+}
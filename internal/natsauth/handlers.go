@@ -1,9 +1,13 @@
 package natsauth
 
 import (
+	"context"
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"strings"
@@ -12,30 +16,45 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/nats-io/nkeys"
+	"opspilot-backend/internal/auth"
+	"opspilot-backend/internal/models"
 	"opspilot-backend/internal/storage"
 )
 
-const defaultJWTExpiry = 365 * 24 * time.Hour
-
 type Handler struct {
 	db        *sqlx.DB
 	storage   *storage.Storage
 	jwtIssuer *JWTIssuer
+	accounts  *AccountManager
+	nonces    *NonceStore
+	natsURLs  []string
 }
 
-func NewHandler(db *sqlx.DB, storage *storage.Storage, issuer *JWTIssuer) *Handler {
-	return &Handler{db: db, storage: storage, jwtIssuer: issuer}
+func NewHandler(db *sqlx.DB, storage *storage.Storage, issuer *JWTIssuer, accounts *AccountManager, nonces *NonceStore, natsURLs []string) *Handler {
+	return &Handler{db: db, storage: storage, jwtIssuer: issuer, accounts: accounts, nonces: nonces, natsURLs: natsURLs}
 }
 
 type createAgentRequest struct {
 	Name     string `json:"name"`
 	Hostname string `json:"hostname"`
+	keyProof
+}
+
+// keyProof carries a locally generated agent public key and a signature over
+// nonce:timestamp proving possession of the seed. When PublicKey is set the
+// server issues a JWT for it and never sees the seed.
+type keyProof struct {
+	PublicKey string `json:"public_key"`
+	Nonce     string `json:"nonce"`
+	Timestamp int64  `json:"timestamp"`
+	Signature string `json:"signature"`
 }
 
 type credentialsResponse struct {
-	CredsContent string    `json:"creds_content"` // .creds file format (recommended)
-	NKeySeed     string    `json:"nkey_seed"`     // Legacy: separate seed
-	JWT          string    `json:"jwt"`           // Legacy: separate JWT
+	CredsContent string    `json:"creds_content,omitempty"` // .creds file format (server keygen only)
+	NKeySeed     string    `json:"nkey_seed,omitempty"`     // Legacy: separate seed (server keygen only)
+	JWT          string    `json:"jwt"`
 	ExpiresAt    time.Time `json:"expires_at"`
 }
 
@@ -47,7 +66,7 @@ type createAgentResponse struct {
 		Status  string `json:"status"`
 	} `json:"agent"`
 	Credentials    credentialsResponse `json:"credentials"`
-	InstallCommand string              `json:"install_command"`
+	InstallCommand string              `json:"install_command,omitempty"`
 }
 
 func (h *Handler) CreateAgent(w http.ResponseWriter, r *http.Request) {
@@ -58,20 +77,25 @@ func (h *Handler) CreateAgent(w http.ResponseWriter, r *http.Request) {
 	var req createAgentRequest
 	_ = json.NewDecoder(r.Body).Decode(&req)
 
-	agentID := generateAgentID()
-	seed, publicKey, err := GenerateUserKeyPair()
-	if err != nil {
-		http.Error(w, "Failed to generate NKey", http.StatusInternalServerError)
+	orgID := ""
+	if user, err := h.currentUser(r); err == nil && user != nil {
+		orgID = user.OrgID
+	}
+
+	seed, publicKey, ok := h.agentKey(w, r, req.keyProof, orgID)
+	if !ok {
 		return
 	}
 
-	jwtToken, expiresAt, err := h.jwtIssuer.IssueAgentJWT(agentID, publicKey, defaultJWTExpiry)
+	agentID := generateAgentID()
+
+	jwtToken, expiresAt, err := h.jwtIssuer.IssueAgentJWT(agentID, publicKey, AgentJWTTTL())
 	if err != nil {
 		http.Error(w, "Failed to issue JWT", http.StatusInternalServerError)
 		return
 	}
 
-	tx, err := h.db.Beginx()
+	tx, err := h.db.BeginTxx(r.Context(), nil)
 	if err != nil {
 		http.Error(w, "Failed to create agent", http.StatusInternalServerError)
 		return
@@ -79,16 +103,16 @@ func (h *Handler) CreateAgent(w http.ResponseWriter, r *http.Request) {
 	defer tx.Rollback()
 
 	recordID := uuid.New().String()
-	_, err = tx.Exec(`
-		INSERT INTO agents (id, agent_id, name, hostname, status)
-		VALUES ($1, $2, $3, $4, 'pending')
-	`, recordID, agentID, strings.TrimSpace(req.Name), strings.TrimSpace(req.Hostname))
+	_, err = tx.ExecContext(r.Context(), `
+		INSERT INTO agents (id, agent_id, org_id, name, hostname, status)
+		VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5, 'pending')
+	`, recordID, agentID, orgID, strings.TrimSpace(req.Name), strings.TrimSpace(req.Hostname))
 	if err != nil {
 		http.Error(w, "Failed to create agent", http.StatusInternalServerError)
 		return
 	}
 
-	_, err = tx.Exec(`
+	_, err = tx.ExecContext(r.Context(), `
 		INSERT INTO agent_credentials (agent_id, public_key, is_pinned, jwt_expires_at)
 		VALUES ($1, $2, true, $3)
 	`, agentID, publicKey, expiresAt)
@@ -102,16 +126,17 @@ func (h *Handler) CreateAgent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	credsContent := BuildCredsFile(jwtToken, seed)
-
 	resp := createAgentResponse{
 		Credentials: credentialsResponse{
-			CredsContent: credsContent,
-			NKeySeed:     seed,
-			JWT:          jwtToken,
-			ExpiresAt:    expiresAt,
+			JWT:       jwtToken,
+			ExpiresAt: expiresAt,
 		},
-		InstallCommand: buildInstallCommand(credsContent),
+	}
+	if seed != "" {
+		credsContent := BuildCredsFile(jwtToken, seed)
+		resp.Credentials.CredsContent = credsContent
+		resp.Credentials.NKeySeed = seed
+		resp.InstallCommand = buildInstallCommand(credsContent)
 	}
 	resp.Agent.ID = recordID
 	resp.Agent.AgentID = agentID
@@ -134,26 +159,56 @@ func (h *Handler) RotateCredentials(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	seed, publicKey, err := GenerateUserKeyPair()
-	if err != nil {
-		http.Error(w, "Failed to generate NKey", http.StatusInternalServerError)
+	// The body is optional: without a key proof the server generates the key.
+	var proof keyProof
+	if err := json.NewDecoder(r.Body).Decode(&proof); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	jwtToken, expiresAt, err := h.jwtIssuer.IssueAgentJWT(agentID, publicKey, defaultJWTExpiry)
+	orgID := ""
+	if agent, err := h.storage.GetAgentByAgentID(r.Context(), agentID); err == nil && agent != nil {
+		orgID = agent.OrgID
+	}
+	if orgID == "" {
+		if user, err := h.currentUser(r); err == nil && user != nil {
+			orgID = user.OrgID
+		}
+	}
+
+	seed, publicKey, ok := h.agentKey(w, r, proof, orgID)
+	if !ok {
+		return
+	}
+	if seed == "" {
+		if cred, err := h.storage.GetActiveCredential(r.Context(), agentID, publicKey); err != nil {
+			http.Error(w, "Failed to check public key", http.StatusInternalServerError)
+			return
+		} else if cred != nil {
+			http.Error(w, "public_key is already the active credential", http.StatusConflict)
+			return
+		}
+	}
+
+	jwtToken, expiresAt, err := h.jwtIssuer.IssueAgentJWT(agentID, publicKey, AgentJWTTTL())
 	if err != nil {
 		http.Error(w, "Failed to issue JWT", http.StatusInternalServerError)
 		return
 	}
 
-	tx, err := h.db.Beginx()
+	tx, err := h.db.BeginTxx(r.Context(), nil)
 	if err != nil {
 		http.Error(w, "Failed to rotate credentials", http.StatusInternalServerError)
 		return
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec(`
+	if err := recordRevocations(r.Context(), tx, agentID); err != nil {
+		http.Error(w, "Failed to revoke old credentials", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.ExecContext(r.Context(), `
 		UPDATE agent_credentials
 		SET revoked_at = now()
 		WHERE agent_id = $1 AND revoked_at IS NULL
@@ -163,7 +218,7 @@ func (h *Handler) RotateCredentials(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = tx.Exec(`
+	_, err = tx.ExecContext(r.Context(), `
 		INSERT INTO agent_credentials (agent_id, public_key, is_pinned, jwt_expires_at)
 		VALUES ($1, $2, false, $3)
 	`, agentID, publicKey, expiresAt)
@@ -177,17 +232,21 @@ func (h *Handler) RotateCredentials(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	credsContent := BuildCredsFile(jwtToken, seed)
+	h.pushRevocations(r.Context())
+
+	creds := credentialsResponse{
+		JWT:       jwtToken,
+		ExpiresAt: expiresAt,
+	}
+	if seed != "" {
+		creds.CredsContent = BuildCredsFile(jwtToken, seed)
+		creds.NKeySeed = seed
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]any{
-		"credentials": credentialsResponse{
-			CredsContent: credsContent,
-			NKeySeed:     seed,
-			JWT:          jwtToken,
-			ExpiresAt:    expiresAt,
-		},
-		"warning": "Old credentials revoked. Update agent configuration immediately.",
+		"credentials": creds,
+		"warning":     "Old credentials revoked. Update agent configuration immediately.",
 	})
 }
 
@@ -199,19 +258,21 @@ func (h *Handler) ListCredentials(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var rows []struct {
-		PublicKey string       `db:"public_key"`
-		CreatedAt time.Time    `db:"created_at"`
-		ExpiresAt time.Time    `db:"jwt_expires_at"`
-		RevokedAt sql.NullTime `db:"revoked_at"`
+		PublicKey     string       `db:"public_key"`
+		CreatedAt     time.Time    `db:"created_at"`
+		ExpiresAt     time.Time    `db:"jwt_expires_at"`
+		RenewalCount  int          `db:"renewal_count"`
+		LastRenewedAt sql.NullTime `db:"last_renewed_at"`
+		RevokedAt     sql.NullTime `db:"revoked_at"`
 	}
 
 	query := `
-		SELECT public_key, created_at, jwt_expires_at, revoked_at
+		SELECT public_key, created_at, jwt_expires_at, renewal_count, last_renewed_at, revoked_at
 		FROM agent_credentials
 		WHERE agent_id = $1
 		ORDER BY created_at DESC
 	`
-	if err := h.db.Select(&rows, query, agentID); err != nil {
+	if err := h.db.SelectContext(r.Context(), &rows, query, agentID); err != nil {
 		http.Error(w, "Failed to load credentials", http.StatusInternalServerError)
 		return
 	}
@@ -224,12 +285,18 @@ func (h *Handler) ListCredentials(w http.ResponseWriter, r *http.Request) {
 			status = "revoked"
 			revoked = row.RevokedAt.Time
 		}
+		renewed := any(nil)
+		if row.LastRenewedAt.Valid {
+			renewed = row.LastRenewedAt.Time
+		}
 		creds = append(creds, map[string]any{
-			"public_key": row.PublicKey,
-			"created_at": row.CreatedAt,
-			"expires_at": row.ExpiresAt,
-			"revoked_at": revoked,
-			"status":     status,
+			"public_key":      row.PublicKey,
+			"created_at":      row.CreatedAt,
+			"expires_at":      row.ExpiresAt,
+			"renewal_count":   row.RenewalCount,
+			"last_renewed_at": renewed,
+			"revoked_at":      revoked,
+			"status":          status,
 		})
 	}
 
@@ -244,7 +311,20 @@ func (h *Handler) DeleteAgent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	res, err := h.db.Exec(`DELETE FROM agents WHERE agent_id = $1`, agentID)
+	tx, err := h.db.BeginTxx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "Failed to delete agent", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Credentials are removed by the cascade, so keep their revocations first.
+	if err := recordRevocations(r.Context(), tx, agentID); err != nil {
+		http.Error(w, "Failed to revoke credentials", http.StatusInternalServerError)
+		return
+	}
+
+	res, err := tx.ExecContext(r.Context(), `DELETE FROM agents WHERE agent_id = $1`, agentID)
 	if err != nil {
 		http.Error(w, "Failed to delete agent", http.StatusInternalServerError)
 		return
@@ -256,15 +336,154 @@ func (h *Handler) DeleteAgent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to delete agent", http.StatusInternalServerError)
+		return
+	}
+
+	h.pushRevocations(r.Context())
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]any{"ok": true})
 }
 
-func buildInstallCommand(credsContent string) string {
-	installURL := os.Getenv("OPSPILOT_INSTALL_URL")
-	if installURL == "" {
-		installURL = "https://get.opspilot.io/install.sh"
+// agentKey returns the key pair for a new agent credential. A submitted public
+// key must carry a valid, fresh and unused proof of possession and yields an
+// empty seed; without one the server generates the pair, if the org allows it.
+// Errors are written to w and reported as ok == false.
+func (h *Handler) agentKey(w http.ResponseWriter, r *http.Request, proof keyProof, orgID string) (seed, publicKey string, ok bool) {
+	proof.PublicKey = strings.TrimSpace(proof.PublicKey)
+	if proof.PublicKey == "" {
+		allowed, err := h.serverKeygenAllowed(r.Context(), orgID)
+		if err != nil {
+			http.Error(w, "Failed to load organization", http.StatusInternalServerError)
+			return "", "", false
+		}
+		if !allowed {
+			http.Error(w, "Server-side key generation is disabled; submit public_key with a signed proof", http.StatusForbidden)
+			return "", "", false
+		}
+
+		seed, publicKey, err := GenerateUserKeyPair()
+		if err != nil {
+			http.Error(w, "Failed to generate NKey", http.StatusInternalServerError)
+			return "", "", false
+		}
+		return seed, publicKey, true
+	}
+
+	if !nkeys.IsValidPublicUserKey(proof.PublicKey) {
+		http.Error(w, "public_key must be an nkey user public key", http.StatusBadRequest)
+		return "", "", false
+	}
+	if !VerifyNKeySignature(proof.PublicKey, strings.TrimSpace(proof.Nonce), proof.Timestamp, strings.TrimSpace(proof.Signature)) {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return "", "", false
+	}
+	if !isTimestampFresh(proof.Timestamp, signatureMaxSkew) {
+		http.Error(w, "Timestamp expired", http.StatusUnauthorized)
+		return "", "", false
+	}
+	if !h.claimProofNonce(w, r, proof, orgID) {
+		return "", "", false
+	}
+
+	revoked, err := h.storage.IsPublicKeyRevoked(r.Context(), proof.PublicKey)
+	if err != nil {
+		http.Error(w, "Failed to check public key", http.StatusInternalServerError)
+		return "", "", false
+	}
+	if revoked {
+		http.Error(w, "public_key was revoked", http.StatusConflict)
+		return "", "", false
+	}
+
+	return "", proof.PublicKey, true
+}
+
+// claimProofNonce rejects a replayed key proof and records the replay as a
+// security event, like enrollment and renewal do.
+func (h *Handler) claimProofNonce(w http.ResponseWriter, r *http.Request, proof keyProof, orgID string) bool {
+	nonce := strings.TrimSpace(proof.Nonce)
+	err := h.nonces.Claim(proof.PublicKey, nonce)
+	if err == nil {
+		return true
+	}
+	if !errors.Is(err, ErrNonceReplayed) {
+		slog.ErrorContext(r.Context(), "key proof nonce check failed", "err", err)
+		http.Error(w, "Nonce check failed", http.StatusServiceUnavailable)
+		return false
+	}
+
+	event := models.SecurityEvent{
+		OrgID:    orgID,
+		AgentID:  chi.URLParam(r, "id"),
+		Type:     models.SecurityEventNonceReplay,
+		RemoteIP: getClientIP(r),
+		Details: map[string]interface{}{
+			"endpoint":   r.URL.Path,
+			"nonce":      nonce,
+			"public_key": proof.PublicKey,
+		},
+	}
+	if err := h.storage.RecordSecurityEvent(r.Context(), event); err != nil {
+		slog.ErrorContext(r.Context(), "record security event", "err", err)
+	}
+	slog.WarnContext(r.Context(), "Key proof nonce replay", "agent_id", event.AgentID, "org_id", orgID, "ip", event.RemoteIP)
+
+	http.Error(w, "Nonce already used", http.StatusUnauthorized)
+	return false
+}
+
+// serverKeygenAllowed reports whether orgID still permits server-generated
+// seeds. Agents without an org keep the legacy behaviour.
+func (h *Handler) serverKeygenAllowed(ctx context.Context, orgID string) (bool, error) {
+	if orgID == "" {
+		return true, nil
+	}
+	org, err := h.storage.GetOrganization(ctx, orgID)
+	if err != nil {
+		return false, err
+	}
+	return org.AllowServerKeygen, nil
+}
+
+// currentUser loads the authenticated user, if any.
+func (h *Handler) currentUser(r *http.Request) (*models.User, error) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		return nil, nil
 	}
+	return h.storage.GetUser(r.Context(), userID)
+}
+
+// recordRevocations copies the agent's active credentials into credential_revocations.
+func recordRevocations(ctx context.Context, tx *sqlx.Tx, agentID string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO credential_revocations (public_key, agent_id, revoked_at, jwt_expires_at)
+		SELECT public_key, agent_id, now(), jwt_expires_at
+		FROM agent_credentials
+		WHERE agent_id = $1 AND revoked_at IS NULL
+		ON CONFLICT (public_key) DO UPDATE
+		SET revoked_at = EXCLUDED.revoked_at,
+			jwt_expires_at = GREATEST(credential_revocations.jwt_expires_at, EXCLUDED.jwt_expires_at)
+	`, agentID)
+	return err
+}
+
+// pushRevocations schedules an account JWT update on the NATS resolver
+// without blocking the request. The account manager logs failed pushes and
+// its periodic sync retries them.
+func (h *Handler) pushRevocations(ctx context.Context) {
+	if h.accounts == nil {
+		slog.WarnContext(ctx, "NATS account manager not configured; revocation not pushed")
+		return
+	}
+	h.accounts.RequestSync()
+}
+
+func buildInstallCommand(credsContent string) string {
+	installURL := installScriptURL()
 
 	// Pass .creds content as single argument (base64 to avoid shell escaping issues)
 	// Note: install script should decode and write to /etc/opspilot/credentials/agent.creds
@@ -272,6 +491,13 @@ func buildInstallCommand(credsContent string) string {
 	return "curl -sSL " + installURL + " | bash -s -- --creds-b64 '" + encoded + "'"
 }
 
+func installScriptURL() string {
+	if installURL := os.Getenv("OPSPILOT_INSTALL_URL"); installURL != "" {
+		return installURL
+	}
+	return "https://get.opspilot.io/install.sh"
+}
+
 func generateAgentID() string {
 	id := strings.ReplaceAll(uuid.New().String(), "-", "")
 	if len(id) > 12 {
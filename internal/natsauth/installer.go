@@ -0,0 +1,302 @@
+package natsauth
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type installerRequest struct {
+	// Token is the plaintext bootstrap token; only its hash is stored, so the
+	// caller must supply it (it is shown once at creation).
+	Token    string `json:"token"`
+	Platform string `json:"platform"`
+}
+
+type installerResponse struct {
+	Systemd       string `json:"systemd"`
+	DockerCompose string `json:"docker_compose"`
+	Kubernetes    string `json:"kubernetes"`
+}
+
+type installerData struct {
+	TokenPrefix string
+	Token       string
+	APIURL      string
+	InstallURL  string
+	Image       string
+	NATSURLs    string
+	Tags        string
+}
+
+var installerFuncs = template.FuncMap{
+	// quote renders a double-quoted string that is valid in YAML.
+	"quote": func(value string) string {
+		data, _ := json.Marshal(value)
+		return string(data)
+	},
+}
+
+var installerTemplates = map[string]*template.Template{
+	"systemd":    template.Must(template.New("systemd").Funcs(installerFuncs).Parse(systemdInstallerTemplate)),
+	"docker":     template.Must(template.New("docker").Funcs(installerFuncs).Parse(dockerInstallerTemplate)),
+	"kubernetes": template.Must(template.New("kubernetes").Funcs(installerFuncs).Parse(kubernetesInstallerTemplate)),
+}
+
+// POST /api/v1/bootstrap-tokens/{id}/installer
+// Renders agent installers (systemd, docker compose, Kubernetes DaemonSet)
+// for the token. ?platform=systemd|docker|kubernetes returns just that one as text.
+func (h *Handler) RenderInstaller(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	tokenID := chi.URLParam(r, "id")
+	token, err := h.storage.GetBootstrapToken(r.Context(), tokenID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "bootstrap tokens: load token", "id", tokenID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load token")
+		return
+	}
+	if token == nil || token.OrgID != user.OrgID {
+		respondError(w, http.StatusNotFound, "token not found")
+		return
+	}
+	if token.RevokedAt != nil {
+		respondError(w, http.StatusConflict, "token revoked")
+		return
+	}
+	if token.ExpiresAt != nil && token.ExpiresAt.Before(time.Now()) {
+		respondError(w, http.StatusConflict, "token expired")
+		return
+	}
+
+	var req installerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	req.Token = strings.TrimSpace(req.Token)
+	platform := strings.TrimSpace(r.URL.Query().Get("platform"))
+	if platform == "" {
+		platform = strings.TrimSpace(req.Platform)
+	}
+	if platform != "" && installerTemplates[platform] == nil {
+		respondError(w, http.StatusBadRequest, "platform must be systemd, docker or kubernetes")
+		return
+	}
+
+	matches, err := h.storage.BootstrapTokenMatches(r.Context(), token.ID, req.Token)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "bootstrap tokens: verify token", "id", tokenID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to verify token")
+		return
+	}
+	if !matches {
+		respondError(w, http.StatusBadRequest, "token does not match bootstrap token")
+		return
+	}
+
+	data := installerData{
+		TokenPrefix: token.TokenPrefix,
+		Token:       req.Token,
+		APIURL:      publicAPIURL(r),
+		InstallURL:  installScriptURL(),
+		Image:       agentImage(),
+		NATSURLs:    singleLine(strings.Join(h.natsURLs, ",")),
+		Tags:        singleLine(strings.Join(token.Tags, ",")),
+	}
+
+	if platform != "" {
+		content, err := renderInstaller(platform, data)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "installer render", "platform", platform, "err", err)
+			respondError(w, http.StatusInternalServerError, "failed to render installer")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(content))
+		return
+	}
+
+	var resp installerResponse
+	for platform, dst := range map[string]*string{
+		"systemd":    &resp.Systemd,
+		"docker":     &resp.DockerCompose,
+		"kubernetes": &resp.Kubernetes,
+	} {
+		content, err := renderInstaller(platform, data)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "installer render", "platform", platform, "err", err)
+			respondError(w, http.StatusInternalServerError, "failed to render installer")
+			return
+		}
+		*dst = content
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+func renderInstaller(platform string, data installerData) (string, error) {
+	var buf bytes.Buffer
+	if err := installerTemplates[platform].Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// publicAPIURL is the API base agents enroll against: OPSPILOT_PUBLIC_URL, or
+// the URL this request came in on.
+func publicAPIURL(r *http.Request) string {
+	if base := strings.TrimRight(os.Getenv("OPSPILOT_PUBLIC_URL"), "/"); base != "" {
+		return base + "/api/v1"
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.TrimSpace(strings.Split(proto, ",")[0])
+	}
+	return scheme + "://" + r.Host + "/api/v1"
+}
+
+func agentImage() string {
+	if image := os.Getenv("OPSPILOT_AGENT_IMAGE"); image != "" {
+		return image
+	}
+	return "opspilot/agent:latest"
+}
+
+// singleLine keeps user-provided values from breaking out of env files.
+func singleLine(value string) string {
+	return strings.NewReplacer("\n", " ", "\r", " ").Replace(value)
+}
+
+const systemdInstallerTemplate = `#!/usr/bin/env bash
+# OpsPilot agent installer (systemd), bootstrap token {{.TokenPrefix}}...
+set -euo pipefail
+
+curl -sSL '{{.InstallURL}}' | bash -s -- --no-start
+
+install -d -m 0750 /etc/opspilot
+cat > /etc/opspilot/agent.env <<'OPSPILOT_ENV'
+OPSPILOT_API_URL={{.APIURL}}
+OPSPILOT_BOOTSTRAP_TOKEN={{.Token}}
+OPSPILOT_NATS_URLS={{.NATSURLs}}
+OPSPILOT_TAGS={{.Tags}}
+OPSPILOT_ENV
+chmod 0600 /etc/opspilot/agent.env
+
+cat > /etc/systemd/system/opspilot-agent.service <<'OPSPILOT_UNIT'
+[Unit]
+Description=OpsPilot agent
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+EnvironmentFile=/etc/opspilot/agent.env
+ExecStart=/usr/local/bin/opspilot-agent
+Restart=always
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+OPSPILOT_UNIT
+
+systemctl daemon-reload
+systemctl enable --now opspilot-agent
+`
+
+const dockerInstallerTemplate = `# OpsPilot agent (docker compose), bootstrap token {{.TokenPrefix}}...
+services:
+  opspilot-agent:
+    image: {{quote .Image}}
+    restart: unless-stopped
+    network_mode: host
+    pid: host
+    environment:
+      OPSPILOT_API_URL: {{quote .APIURL}}
+      OPSPILOT_BOOTSTRAP_TOKEN: {{quote .Token}}
+      OPSPILOT_NATS_URLS: {{quote .NATSURLs}}
+      OPSPILOT_TAGS: {{quote .Tags}}
+    volumes:
+      - opspilot-agent-data:/var/lib/opspilot
+      - /var/run/docker.sock:/var/run/docker.sock:ro
+
+volumes:
+  opspilot-agent-data:
+`
+
+const kubernetesInstallerTemplate = `# OpsPilot agent (Kubernetes DaemonSet), bootstrap token {{.TokenPrefix}}...
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: opspilot
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: opspilot-agent
+  namespace: opspilot
+type: Opaque
+stringData:
+  bootstrap-token: {{quote .Token}}
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: opspilot-agent
+  namespace: opspilot
+spec:
+  selector:
+    matchLabels:
+      app: opspilot-agent
+  template:
+    metadata:
+      labels:
+        app: opspilot-agent
+    spec:
+      hostNetwork: true
+      hostPID: true
+      tolerations:
+        - operator: Exists
+      containers:
+        - name: agent
+          image: {{quote .Image}}
+          env:
+            - name: OPSPILOT_API_URL
+              value: {{quote .APIURL}}
+            - name: OPSPILOT_BOOTSTRAP_TOKEN
+              valueFrom:
+                secretKeyRef:
+                  name: opspilot-agent
+                  key: bootstrap-token
+            - name: OPSPILOT_NATS_URLS
+              value: {{quote .NATSURLs}}
+            - name: OPSPILOT_TAGS
+              value: {{quote .Tags}}
+            - name: OPSPILOT_HOSTNAME
+              valueFrom:
+                fieldRef:
+                  fieldPath: spec.nodeName
+          volumeMounts:
+            - name: state
+              mountPath: /var/lib/opspilot
+      volumes:
+        # Per-node state keeps each node's agent_id and nkey across restarts.
+        - name: state
+          hostPath:
+            path: /var/lib/opspilot
+            type: DirectoryOrCreate
+`
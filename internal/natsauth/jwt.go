@@ -3,12 +3,33 @@ package natsauth
 import (
 	"encoding/base64"
 	"fmt"
+	"log/slog"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/nats-io/jwt/v2"
 	"github.com/nats-io/nkeys"
 )
 
+// DefaultAgentJWTTTL is the lifetime of agent JWTs; agents renew them over
+// ops.{agent_id}.auth.renew before they expire.
+const DefaultAgentJWTTTL = 7 * 24 * time.Hour
+
+// AgentJWTTTL returns NATS_AGENT_JWT_TTL (Go duration) or DefaultAgentJWTTTL.
+func AgentJWTTTL() time.Duration {
+	value := strings.TrimSpace(os.Getenv("NATS_AGENT_JWT_TTL"))
+	if value == "" {
+		return DefaultAgentJWTTTL
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		slog.Warn("Invalid NATS_AGENT_JWT_TTL, using default", "value", value, "default", DefaultAgentJWTTTL)
+		return DefaultAgentJWTTTL
+	}
+	return ttl
+}
+
 type JWTIssuer struct {
 	signingKey   nkeys.KeyPair
 	accountPubID string
@@ -68,6 +89,10 @@ func (ji *JWTIssuer) IssueAgentJWT(agentID, publicKey string, expiresIn time.Dur
 	claims.Permissions.Pub.Allow.Add("$KV.AGENTS." + agentID)
 	// Allow KV stream info lookup (required by nats.go KeyValue binding)
 	claims.Permissions.Pub.Allow.Add("$JS.API.STREAM.INFO.KV_AGENTS")
+	// Allow reading and watching the agent's own AGENT_CONFIG entry
+	claims.Permissions.Pub.Allow.Add("$JS.API.STREAM.INFO.KV_AGENT_CONFIG")
+	claims.Permissions.Pub.Allow.Add("$JS.API.DIRECT.GET.KV_AGENT_CONFIG.$KV.AGENT_CONFIG." + agentID)
+	claims.Permissions.Pub.Allow.Add("$JS.API.CONSUMER.CREATE.KV_AGENT_CONFIG.*.$KV.AGENT_CONFIG." + agentID)
 	// Allow subscribing to agent's RPC subject
 	claims.Permissions.Sub.Allow.Add("ops." + agentID + ".rpc")
 	// Allow subscribe to all agent-scoped subjects (safe, still per-agent)
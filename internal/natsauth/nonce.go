@@ -0,0 +1,44 @@
+package natsauth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"opspilot-backend/internal/cache"
+)
+
+// signatureMaxSkew is how far an agent-signed timestamp may drift from server time.
+const signatureMaxSkew = 5 * time.Minute
+
+// ErrNonceReplayed is returned when a signed nonce was already used within the skew window.
+var ErrNonceReplayed = errors.New("nonce already used")
+
+// NonceStore remembers nonces of agent-signed requests in Redis. A signature is
+// accepted for signatureMaxSkew on either side of its timestamp, so nonces are
+// kept for the full window to make every replay within it detectable.
+type NonceStore struct {
+	cache cache.Client
+	ttl   time.Duration
+}
+
+func NewNonceStore(cacheClient cache.Client) *NonceStore {
+	return &NonceStore{cache: cacheClient, ttl: 2 * signatureMaxSkew}
+}
+
+// Claim marks the nonce as used for publicKey. It fails closed: a Redis error
+// is returned to the caller rather than accepting the signature.
+func (n *NonceStore) Claim(publicKey, nonce string) error {
+	sum := sha256.Sum256([]byte(publicKey + ":" + nonce))
+	key := "ops:nonce:" + hex.EncodeToString(sum[:])
+
+	ok, err := n.cache.SetNX(key, "1", n.ttl)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNonceReplayed
+	}
+	return nil
+}
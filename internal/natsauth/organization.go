@@ -0,0 +1,59 @@
+package natsauth
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"opspilot-backend/internal/agentversion"
+	"opspilot-backend/internal/models"
+)
+
+// GET /api/v1/organization
+func (h *Handler) GetOrganization(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	org, err := h.storage.GetOrganization(r.Context(), user.OrgID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "organization: load", "org_id", user.OrgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to load organization")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, org)
+}
+
+// PATCH /api/v1/organization
+func (h *Handler) UpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateOrganizationInput
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if req.MinAgentVersion != nil {
+		*req.MinAgentVersion = strings.TrimSpace(*req.MinAgentVersion)
+		if *req.MinAgentVersion != "" && !agentversion.Valid(*req.MinAgentVersion) {
+			respondError(w, http.StatusBadRequest, "invalid min_agent_version")
+			return
+		}
+	}
+
+	org, err := h.storage.UpdateOrganization(r.Context(), user.OrgID, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "organization: update", "org_id", user.OrgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to update organization")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Organization updated", "org_id", org.ID, "allow_server_keygen", org.AllowServerKeygen, "min_agent_version", org.MinAgentVersion, "user_id", user.ID)
+	respondJSON(w, http.StatusOK, org)
+}
@@ -0,0 +1,177 @@
+package natsauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"opspilot-backend/internal/models"
+	"opspilot-backend/internal/protocol"
+	"opspilot-backend/internal/storage"
+)
+
+const (
+	renewSubject    = "ops.*.auth.renew"
+	renewQueueGroup = "backend-auth"
+)
+
+// RenewalService answers agent JWT renewal requests on ops.{agent_id}.auth.renew.
+// The agent proves possession of its pinned nkey by signing nonce + timestamp;
+// the new JWT carries the same permissions as the enrollment JWT.
+type RenewalService struct {
+	nc     *nats.Conn
+	store  *storage.Storage
+	issuer *JWTIssuer
+	nonces *NonceStore
+	sub    *nats.Subscription
+}
+
+func NewRenewalService(nc *nats.Conn, store *storage.Storage, issuer *JWTIssuer, nonces *NonceStore) *RenewalService {
+	return &RenewalService{nc: nc, store: store, issuer: issuer, nonces: nonces}
+}
+
+// Start subscribes to renewal requests (queue group, safe with several replicas).
+func (s *RenewalService) Start() error {
+	if s.issuer == nil {
+		return fmt.Errorf("NATS JWT issuer not configured")
+	}
+
+	sub, err := s.nc.QueueSubscribe(renewSubject, renewQueueGroup, s.handle)
+	if err != nil {
+		return err
+	}
+	s.sub = sub
+
+	slog.Info("JWT renewal service started")
+	return nil
+}
+
+func (s *RenewalService) handle(msg *nats.Msg) {
+	resp := s.renew(msg)
+	if !resp.Success {
+		slog.Warn("JWT renewal rejected", "subject", msg.Subject, "code", resp.ErrorCode, "error", resp.Error)
+	}
+
+	data, err := msgpack.Marshal(&resp)
+	if err != nil {
+		slog.Error("JWT renewal marshal error", "err", err)
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		slog.Warn("JWT renewal respond error", "err", err)
+	}
+}
+
+func (s *RenewalService) renew(msg *nats.Msg) models.RenewResponse {
+	subjectAgentID, err := agentIDFromRenewSubject(msg.Subject)
+	if err != nil {
+		return renewError("invalid_subject", err.Error())
+	}
+
+	var req models.RenewRequest
+	if err := msgpack.Unmarshal(msg.Data, &req); err != nil {
+		return renewError("invalid_request", "invalid request")
+	}
+	req.AgentID = strings.TrimSpace(req.AgentID)
+	req.PublicKey = strings.TrimSpace(req.PublicKey)
+
+	if req.AgentID == "" || req.PublicKey == "" || req.Nonce == "" || req.Timestamp == 0 || req.Signature == "" {
+		return renewError("invalid_request", "missing required fields")
+	}
+	if req.AgentID != subjectAgentID {
+		return renewError("agent_mismatch", "agent_id does not match subject")
+	}
+
+	if !VerifyNKeySignature(req.PublicKey, req.Nonce, req.Timestamp, req.Signature) {
+		return renewError("invalid_signature", "invalid signature")
+	}
+	if !isTimestampFresh(req.Timestamp, signatureMaxSkew) {
+		return renewError("timestamp_expired", "timestamp expired")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cred, err := s.store.GetActiveCredential(ctx, req.AgentID, req.PublicKey)
+	if err != nil {
+		slog.ErrorContext(ctx, "JWT renewal credential lookup", "agent_id", req.AgentID, "err", err)
+		return renewError("internal", "database error")
+	}
+	if cred == nil {
+		return renewError("unknown_credential", "no active credential for this key")
+	}
+
+	if err := s.nonces.Claim(req.PublicKey, req.Nonce); err != nil {
+		if !errors.Is(err, ErrNonceReplayed) {
+			slog.ErrorContext(ctx, "JWT renewal nonce check", "agent_id", req.AgentID, "err", err)
+			return renewError("internal", "nonce check failed")
+		}
+		s.recordReplay(ctx, req)
+		return renewError("nonce_replayed", ErrNonceReplayed.Error())
+	}
+
+	jwtToken, expiresAt, err := s.issuer.IssueAgentJWT(req.AgentID, req.PublicKey, AgentJWTTTL())
+	if err != nil {
+		return renewError("internal", "failed to issue JWT")
+	}
+
+	if err := s.store.RecordCredentialRenewal(ctx, req.AgentID, req.PublicKey, expiresAt); err != nil {
+		slog.ErrorContext(ctx, "JWT renewal audit", "agent_id", req.AgentID, "err", err)
+		return renewError("internal", "failed to store renewal")
+	}
+
+	slog.InfoContext(ctx, "JWT renewed", "agent_id", req.AgentID, "expires_at", expiresAt.Format(time.RFC3339), "renewals", cred.RenewalCount+1)
+
+	supported := protocol.Supported()
+	return models.RenewResponse{
+		Success:   true,
+		JWT:       jwtToken,
+		ExpiresAt: expiresAt.Unix(),
+		Protocol:  &supported,
+	}
+}
+
+func (s *RenewalService) recordReplay(ctx context.Context, req models.RenewRequest) {
+	event := models.SecurityEvent{
+		AgentID: req.AgentID,
+		Type:    models.SecurityEventNonceReplay,
+		Details: map[string]interface{}{
+			"endpoint":   "auth_renew",
+			"nonce":      req.Nonce,
+			"public_key": req.PublicKey,
+		},
+	}
+	if agent, err := s.store.GetAgentByAgentID(ctx, req.AgentID); err == nil && agent != nil {
+		event.OrgID = agent.OrgID
+		event.BootstrapTokenID = ptrString(agent.EnrolledVia)
+	}
+	if err := s.store.RecordSecurityEvent(ctx, event); err != nil {
+		slog.ErrorContext(ctx, "record security event", "err", err)
+	}
+}
+
+// Stop drains the renewal subscription.
+func (s *RenewalService) Stop() error {
+	if s.sub != nil {
+		return s.sub.Drain()
+	}
+	return nil
+}
+
+func renewError(code, message string) models.RenewResponse {
+	return models.RenewResponse{Success: false, Error: message, ErrorCode: code}
+}
+
+func agentIDFromRenewSubject(subject string) (string, error) {
+	parts := strings.Split(subject, ".")
+	if len(parts) != 4 || parts[0] != "ops" || parts[2] != "auth" || parts[3] != "renew" {
+		return "", fmt.Errorf("unexpected subject: %s", subject)
+	}
+	return parts[1], nil
+}
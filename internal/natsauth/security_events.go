@@ -0,0 +1,36 @@
+package natsauth
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// GET /api/v1/security-events?type=nonce_replay&limit=100
+func (h *Handler) ListSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.orgUser(w, r)
+	if !ok {
+		return
+	}
+
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			respondError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = parsed
+	}
+
+	eventType := strings.TrimSpace(r.URL.Query().Get("type"))
+	events, err := h.storage.ListSecurityEvents(r.Context(), user.OrgID, eventType, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "security events: list", "org_id", user.OrgID, "err", err)
+		respondError(w, http.StatusInternalServerError, "failed to list security events")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, events)
+}
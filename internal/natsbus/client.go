@@ -2,7 +2,7 @@ package natsbus
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"strings"
 	"time"
@@ -12,13 +12,57 @@ import (
 )
 
 type Client struct {
-	nc *nats.Conn
-	js nats.JetStreamContext
-	kv nats.KeyValue
+	nc       *nats.Conn
+	js       nats.JetStreamContext
+	kv       nats.KeyValue
+	configKV nats.KeyValue
 }
 
-// Connect establishes NATS connection and initializes JetStream/KV.
+// Connect establishes NATS connection and reconciles JetStream/KV with
+// LoadInfraConfig. NATS_INFRA_DRY_RUN=true only creates missing resources and
+// logs pending updates.
 func Connect() (*Client, error) {
+	infra, err := LoadInfraConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	nc, err := Dial()
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("create JetStream context: %w", err)
+	}
+
+	// Initialize infrastructure (streams, KV)
+	if err := ensureInfrastructure(js, infra, os.Getenv("NATS_INFRA_DRY_RUN") == "true"); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("ensure infrastructure: %w", err)
+	}
+
+	// Bind to KV bucket
+	kv, err := js.KeyValue("AGENTS")
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("bind KV bucket: %w", err)
+	}
+
+	configKV, err := js.KeyValue("AGENT_CONFIG")
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("bind KV bucket AGENT_CONFIG: %w", err)
+	}
+
+	return &Client{nc: nc, js: js, kv: kv, configKV: configKV}, nil
+}
+
+// Dial opens a connection with the backend credentials without touching
+// JetStream.
+func Dial() (*nats.Conn, error) {
 	url := os.Getenv("NATS_URL")
 	if url == "" {
 		url = nats.DefaultURL
@@ -30,16 +74,16 @@ func Connect() (*Client, error) {
 		nats.ReconnectJitter(500*time.Millisecond, 2*time.Second),
 		nats.ReconnectBufSize(8 * 1024 * 1024),
 		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
-			log.Printf("WARN NATS disconnected: %v", err)
+			slog.Warn("NATS disconnected", "err", err)
 		}),
 		nats.ReconnectHandler(func(nc *nats.Conn) {
-			log.Printf("INFO NATS reconnected to %s", nc.ConnectedUrl())
+			slog.Info("NATS reconnected", "url", nc.ConnectedUrl())
 		}),
 		nats.ClosedHandler(func(nc *nats.Conn) {
-			log.Println("INFO NATS connection closed")
+			slog.Info("NATS connection closed")
 		}),
 		nats.ErrorHandler(func(nc *nats.Conn, sub *nats.Subscription, err error) {
-			log.Printf("ERROR NATS error: %v", err)
+			slog.Error("NATS error", "err", err)
 		}),
 	}
 
@@ -53,28 +97,34 @@ func Connect() (*Client, error) {
 	if err != nil {
 		return nil, fmt.Errorf("connect to NATS: %w", err)
 	}
-	log.Printf("INFO Connected to NATS at %s", nc.ConnectedUrl())
+	slog.Info("Connected to NATS", "url", nc.ConnectedUrl())
+	return nc, nil
+}
 
-	js, err := nc.JetStream()
-	if err != nil {
-		nc.Close()
-		return nil, fmt.Errorf("create JetStream context: %w", err)
+// ConnectSystem opens a connection with NATS_SYSTEM_CREDS for publishing
+// account claim updates. Returns nil when the variable is not set.
+func ConnectSystem() (*nats.Conn, error) {
+	creds := strings.TrimSpace(os.Getenv("NATS_SYSTEM_CREDS"))
+	if creds == "" {
+		return nil, nil
 	}
 
-	// Initialize infrastructure (streams, KV)
-	if err := ensureInfrastructure(js); err != nil {
-		nc.Close()
-		return nil, fmt.Errorf("ensure infrastructure: %w", err)
+	url := os.Getenv("NATS_URL")
+	if url == "" {
+		url = nats.DefaultURL
 	}
 
-	// Bind to KV bucket
-	kv, err := js.KeyValue("AGENTS")
+	nc, err := nats.Connect(url,
+		nats.Name("opspilot-backend-system"),
+		nats.UserCredentials(creds),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(1*time.Second),
+	)
 	if err != nil {
-		nc.Close()
-		return nil, fmt.Errorf("bind KV bucket: %w", err)
+		return nil, fmt.Errorf("connect to NATS system account: %w", err)
 	}
-
-	return &Client{nc: nc, js: js, kv: kv}, nil
+	slog.Info("Connected to NATS system account", "url", nc.ConnectedUrl())
+	return nc, nil
 }
 
 func authOption() (nats.Option, error) {
@@ -136,63 +186,7 @@ func (c *Client) KV() nats.KeyValue {
 	return c.kv
 }
 
-func ensureInfrastructure(js nats.JetStreamContext) error {
-	// Create OPS_EVENTS stream if not exists
-	_, err := js.StreamInfo("OPS_EVENTS")
-	if err == nats.ErrStreamNotFound {
-		_, err = js.AddStream(&nats.StreamConfig{
-			Name:       "OPS_EVENTS",
-			Subjects:   []string{"ops.*.events.>"},
-			Retention:  nats.LimitsPolicy,
-			MaxAge:     72 * time.Hour,
-			MaxBytes:   10 * 1024 * 1024, // 1GB
-			MaxMsgSize: 1 * 1024 * 1024,  // 1MB
-			Discard:    nats.DiscardOld,
-			Storage:    nats.FileStorage,
-		})
-		if err != nil {
-			return fmt.Errorf("create stream OPS_EVENTS: %w", err)
-		}
-		log.Println("INFO Created JetStream stream OPS_EVENTS")
-	} else if err != nil {
-		return fmt.Errorf("get stream info: %w", err)
-	}
-
-	// Create OPS_INVENTORY stream if not exists
-	_, err = js.StreamInfo("OPS_INVENTORY")
-	if err == nats.ErrStreamNotFound {
-		_, err = js.AddStream(&nats.StreamConfig{
-			Name:      "OPS_INVENTORY",
-			Subjects:  []string{"ops.*.inventory"},
-			Retention: nats.LimitsPolicy,
-			MaxAge:    30 * 24 * time.Hour,
-			Storage:   nats.FileStorage,
-		})
-		if err != nil {
-			return fmt.Errorf("create stream OPS_INVENTORY: %w", err)
-		}
-		log.Println("INFO Created JetStream stream OPS_INVENTORY")
-	} else if err != nil {
-		return fmt.Errorf("get stream info: %w", err)
-	}
-
-	// Create AGENTS KV bucket if not exists
-	_, err = js.KeyValue("AGENTS")
-	if err == nats.ErrBucketNotFound {
-		_, err = js.CreateKeyValue(&nats.KeyValueConfig{
-			Bucket:       "AGENTS",
-			TTL:          2 * time.Minute,
-			MaxValueSize: 8 * 1024,
-			History:      1,
-			Storage:      nats.FileStorage,
-		})
-		if err != nil {
-			return fmt.Errorf("create KV bucket AGENTS: %w", err)
-		}
-		log.Println("INFO Created KV bucket AGENTS")
-	} else if err != nil {
-		return fmt.Errorf("get KV bucket: %w", err)
-	}
-
-	return nil
+// ConfigKV returns the AGENT_CONFIG KV bucket (desired agent config).
+func (c *Client) ConfigKV() nats.KeyValue {
+	return c.configKV
 }
@@ -0,0 +1,170 @@
+package natsbus
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DLQStream keeps messages the consumers could not process: undecodable
+// ones and ones whose deliveries were exhausted. They are stored under
+// dlq.{original subject} with the failure in headers.
+const (
+	DLQStream        = "OPS_DLQ"
+	dlqSubjectPrefix = "dlq."
+
+	dlqHeaderReason     = "Ops-Dlq-Reason"
+	dlqHeaderSubject    = "Ops-Dlq-Subject"
+	dlqHeaderConsumer   = "Ops-Dlq-Consumer"
+	dlqHeaderStream     = "Ops-Dlq-Stream"
+	dlqHeaderStreamSeq  = "Ops-Dlq-Stream-Seq"
+	dlqHeaderDeliveries = "Ops-Dlq-Deliveries"
+	dlqHeaderFailedAt   = "Ops-Dlq-Failed-At"
+
+	// dlqMaxScan bounds how many DLQ messages one List call reads.
+	dlqMaxScan = 5000
+)
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is a message in OPS_DLQ. Data is only set by Get.
+type DeadLetter struct {
+	Seq        uint64    `json:"seq"`
+	Subject    string    `json:"subject"`
+	AgentID    string    `json:"agent_id,omitempty"`
+	Consumer   string    `json:"consumer"`
+	Stream     string    `json:"stream"`
+	StreamSeq  uint64    `json:"stream_seq"`
+	Deliveries uint64    `json:"deliveries"`
+	Reason     string    `json:"reason"`
+	FailedAt   time.Time `json:"failed_at"`
+	Size       int       `json:"size"`
+	Data       []byte    `json:"data,omitempty"`
+}
+
+// DeadLetters writes to and reads from OPS_DLQ.
+type DeadLetters struct {
+	js nats.JetStreamContext
+}
+
+func NewDeadLetters(js nats.JetStreamContext) *DeadLetters {
+	return &DeadLetters{js: js}
+}
+
+// Publish copies msg to the DLQ with the consumer and failure reason.
+func (d *DeadLetters) Publish(msg *nats.Msg, consumer, reason string) error {
+	header := nats.Header{}
+	header.Set(dlqHeaderReason, reason)
+	header.Set(dlqHeaderSubject, msg.Subject)
+	header.Set(dlqHeaderConsumer, consumer)
+	header.Set(dlqHeaderFailedAt, time.Now().UTC().Format(time.RFC3339Nano))
+	if meta, err := msg.Metadata(); err == nil {
+		header.Set(dlqHeaderStream, meta.Stream)
+		header.Set(dlqHeaderStreamSeq, strconv.FormatUint(meta.Sequence.Stream, 10))
+		header.Set(dlqHeaderDeliveries, strconv.FormatUint(meta.NumDelivered, 10))
+	}
+
+	_, err := d.js.PublishMsg(&nats.Msg{
+		Subject: dlqSubjectPrefix + msg.Subject,
+		Header:  header,
+		Data:    msg.Data,
+	})
+	if err != nil {
+		return fmt.Errorf("publish to %s: %w", DLQStream, err)
+	}
+	return nil
+}
+
+// List returns dead letters newest first, starting below before (0 = from the
+// latest) and keeping those accepted by keep. It stops after limit letters or
+// dlqMaxScan messages and returns the lowest scanned seq as the before of the
+// next page, or 0 when the scan reached the start of the stream.
+func (d *DeadLetters) List(before uint64, limit int, keep func(*DeadLetter) bool) ([]DeadLetter, uint64, error) {
+	info, err := d.js.StreamInfo(DLQStream)
+	if err != nil {
+		return nil, 0, fmt.Errorf("get stream info %s: %w", DLQStream, err)
+	}
+
+	seq := info.State.LastSeq
+	if before > 0 && before-1 < seq {
+		seq = before - 1
+	}
+
+	letters := []DeadLetter{}
+	for scanned := 0; seq >= info.State.FirstSeq && seq > 0 && len(letters) < limit && scanned < dlqMaxScan; seq-- {
+		scanned++
+		raw, err := d.js.GetMsg(DLQStream, seq)
+		if errors.Is(err, nats.ErrMsgNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("get %s message %d: %w", DLQStream, seq, err)
+		}
+		letter := deadLetterFromMsg(raw)
+		if keep == nil || keep(letter) {
+			letter.Data = nil
+			letters = append(letters, *letter)
+		}
+	}
+
+	var next uint64
+	if seq >= info.State.FirstSeq && seq > 0 {
+		next = seq + 1
+	}
+	return letters, next, nil
+}
+
+// Get returns a dead letter with its payload.
+func (d *DeadLetters) Get(seq uint64) (*DeadLetter, error) {
+	raw, err := d.js.GetMsg(DLQStream, seq)
+	if errors.Is(err, nats.ErrMsgNotFound) {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get %s message %d: %w", DLQStream, seq, err)
+	}
+	return deadLetterFromMsg(raw), nil
+}
+
+// Replay re-publishes the dead letter to its original subject and removes it
+// from the DLQ. If it fails again it comes back as a new dead letter.
+func (d *DeadLetters) Replay(letter *DeadLetter) error {
+	if _, err := d.js.Publish(letter.Subject, letter.Data); err != nil {
+		return fmt.Errorf("republish %s: %w", letter.Subject, err)
+	}
+	if err := d.js.DeleteMsg(DLQStream, letter.Seq); err != nil && !errors.Is(err, nats.ErrMsgNotFound) {
+		return fmt.Errorf("delete %s message %d: %w", DLQStream, letter.Seq, err)
+	}
+	return nil
+}
+
+func deadLetterFromMsg(raw *nats.RawStreamMsg) *DeadLetter {
+	letter := &DeadLetter{
+		Seq:      raw.Sequence,
+		Subject:  raw.Header.Get(dlqHeaderSubject),
+		Consumer: raw.Header.Get(dlqHeaderConsumer),
+		Stream:   raw.Header.Get(dlqHeaderStream),
+		Reason:   raw.Header.Get(dlqHeaderReason),
+		FailedAt: raw.Time,
+		Size:     len(raw.Data),
+		Data:     raw.Data,
+	}
+	if letter.Subject == "" {
+		letter.Subject = strings.TrimPrefix(raw.Subject, dlqSubjectPrefix)
+	}
+	letter.StreamSeq, _ = strconv.ParseUint(raw.Header.Get(dlqHeaderStreamSeq), 10, 64)
+	letter.Deliveries, _ = strconv.ParseUint(raw.Header.Get(dlqHeaderDeliveries), 10, 64)
+	if failedAt, err := time.Parse(time.RFC3339Nano, raw.Header.Get(dlqHeaderFailedAt)); err == nil {
+		letter.FailedAt = failedAt
+	}
+
+	// Agent subjects are ops.{agent_id}.…
+	if parts := strings.Split(letter.Subject, "."); len(parts) > 2 && parts[0] == "ops" {
+		letter.AgentID = parts[1]
+	}
+	return letter
+}
@@ -0,0 +1,325 @@
+package natsbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// InfraConfig describes the JetStream streams and KV buckets the backend
+// needs. Missing resources are created, existing ones are reconciled.
+type InfraConfig struct {
+	Streams []StreamSpec `json:"streams"`
+	Buckets []BucketSpec `json:"buckets"`
+}
+
+// StreamSpec is the desired configuration of a stream. Zero limits mean
+// unlimited, as in NATS.
+type StreamSpec struct {
+	Name       string   `json:"name"`
+	Subjects   []string `json:"subjects"`
+	Retention  string   `json:"retention"` // limits | interest | workqueue
+	Storage    string   `json:"storage"`   // file | memory
+	Discard    string   `json:"discard"`   // old | new
+	MaxAge     Duration `json:"max_age"`
+	MaxBytes   int64    `json:"max_bytes"`
+	MaxMsgs    int64    `json:"max_msgs"`
+	MaxMsgSize int32    `json:"max_msg_size"`
+	Replicas   int      `json:"replicas"`
+}
+
+// BucketSpec is the desired configuration of a KV bucket.
+type BucketSpec struct {
+	Bucket       string   `json:"bucket"`
+	TTL          Duration `json:"ttl"`
+	MaxValueSize int32    `json:"max_value_size"`
+	MaxBytes     int64    `json:"max_bytes"`
+	History      uint8    `json:"history"`
+	Storage      string   `json:"storage"`
+	Replicas     int      `json:"replicas"`
+}
+
+// Duration is a time.Duration read from JSON as a string ("72h").
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"72h\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// DefaultInfraConfig returns the built-in stream and bucket settings.
+func DefaultInfraConfig() InfraConfig {
+	return InfraConfig{
+		Streams: []StreamSpec{
+			{
+				Name:       "OPS_EVENTS",
+				Subjects:   []string{"ops.*.events.>"},
+				Retention:  "limits",
+				Storage:    "file",
+				Discard:    "old",
+				MaxAge:     Duration(72 * time.Hour),
+				MaxBytes:   1024 * 1024 * 1024, // 1GB
+				MaxMsgSize: 1 * 1024 * 1024,    // 1MB
+			},
+			{
+				Name:      "OPS_INVENTORY",
+				Subjects:  []string{"ops.*.inventory"},
+				Retention: "limits",
+				Storage:   "file",
+				Discard:   "old",
+				MaxAge:    Duration(30 * 24 * time.Hour),
+			},
+			{
+				Name:      DLQStream,
+				Subjects:  []string{dlqSubjectPrefix + ">"},
+				Retention: "limits",
+				Storage:   "file",
+				Discard:   "old",
+				MaxAge:    Duration(14 * 24 * time.Hour),
+				MaxBytes:  1024 * 1024 * 1024, // 1GB
+			},
+		},
+		Buckets: []BucketSpec{
+			{
+				Bucket:       "AGENTS",
+				TTL:          Duration(2 * time.Minute),
+				MaxValueSize: 8 * 1024,
+				History:      1,
+				Storage:      "file",
+			},
+			{
+				// Written by the backend only
+				Bucket:       "AGENT_CONFIG",
+				MaxValueSize: 64 * 1024,
+				History:      5,
+				Storage:      "file",
+			},
+		},
+	}
+}
+
+// LoadInfraConfig returns the defaults overlaid with the JSON file named by
+// NATS_INFRA_CONFIG. Entries are matched by name; fields missing from an entry
+// keep their default, unknown names add a stream or bucket.
+func LoadInfraConfig() (InfraConfig, error) {
+	cfg := DefaultInfraConfig()
+
+	path := strings.TrimSpace(os.Getenv("NATS_INFRA_CONFIG"))
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("read NATS_INFRA_CONFIG: %w", err)
+	}
+
+	var file struct {
+		Streams []json.RawMessage `json:"streams"`
+		Buckets []json.RawMessage `json:"buckets"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return cfg, fmt.Errorf("parse NATS_INFRA_CONFIG: %w", err)
+	}
+
+	for _, raw := range file.Streams {
+		var named struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(raw, &named); err != nil || named.Name == "" {
+			return cfg, fmt.Errorf("parse NATS_INFRA_CONFIG: stream entry without name")
+		}
+		i := streamIndex(cfg.Streams, named.Name)
+		if i < 0 {
+			cfg.Streams = append(cfg.Streams, StreamSpec{Retention: "limits", Storage: "file", Discard: "old"})
+			i = len(cfg.Streams) - 1
+		}
+		if err := json.Unmarshal(raw, &cfg.Streams[i]); err != nil {
+			return cfg, fmt.Errorf("parse NATS_INFRA_CONFIG stream %s: %w", named.Name, err)
+		}
+	}
+
+	for _, raw := range file.Buckets {
+		var named struct {
+			Bucket string `json:"bucket"`
+		}
+		if err := json.Unmarshal(raw, &named); err != nil || named.Bucket == "" {
+			return cfg, fmt.Errorf("parse NATS_INFRA_CONFIG: bucket entry without bucket")
+		}
+		i := bucketIndex(cfg.Buckets, named.Bucket)
+		if i < 0 {
+			cfg.Buckets = append(cfg.Buckets, BucketSpec{History: 1, Storage: "file"})
+			i = len(cfg.Buckets) - 1
+		}
+		if err := json.Unmarshal(raw, &cfg.Buckets[i]); err != nil {
+			return cfg, fmt.Errorf("parse NATS_INFRA_CONFIG bucket %s: %w", named.Bucket, err)
+		}
+	}
+
+	return cfg, cfg.validate()
+}
+
+func (c InfraConfig) validate() error {
+	for _, s := range c.Streams {
+		if len(s.Subjects) == 0 {
+			return fmt.Errorf("stream %s: subjects are required", s.Name)
+		}
+		if _, err := s.config(); err != nil {
+			return fmt.Errorf("stream %s: %w", s.Name, err)
+		}
+	}
+	for _, b := range c.Buckets {
+		if b.History > nats.KeyValueMaxHistory {
+			return fmt.Errorf("bucket %s: history must be at most %d", b.Bucket, nats.KeyValueMaxHistory)
+		}
+		if _, err := parseStorage(b.Storage); err != nil {
+			return fmt.Errorf("bucket %s: %w", b.Bucket, err)
+		}
+	}
+	return nil
+}
+
+// config builds the stream configuration, with unset limits normalized to
+// the values the server reports for them.
+func (s StreamSpec) config() (nats.StreamConfig, error) {
+	retention, err := parseRetention(s.Retention)
+	if err != nil {
+		return nats.StreamConfig{}, err
+	}
+	storage, err := parseStorage(s.Storage)
+	if err != nil {
+		return nats.StreamConfig{}, err
+	}
+	discard, err := parseDiscard(s.Discard)
+	if err != nil {
+		return nats.StreamConfig{}, err
+	}
+	return nats.StreamConfig{
+		Name:       s.Name,
+		Subjects:   s.Subjects,
+		Retention:  retention,
+		Storage:    storage,
+		Discard:    discard,
+		MaxAge:     time.Duration(s.MaxAge),
+		MaxBytes:   unlimited(s.MaxBytes),
+		MaxMsgs:    unlimited(s.MaxMsgs),
+		MaxMsgSize: int32(unlimited(int64(s.MaxMsgSize))),
+		Replicas:   replicas(s.Replicas),
+	}, nil
+}
+
+// kvConfig is the configuration passed to CreateKeyValue.
+func (b BucketSpec) kvConfig() *nats.KeyValueConfig {
+	storage, _ := parseStorage(b.Storage)
+	return &nats.KeyValueConfig{
+		Bucket:       b.Bucket,
+		TTL:          time.Duration(b.TTL),
+		MaxValueSize: b.MaxValueSize,
+		MaxBytes:     b.MaxBytes,
+		History:      b.History,
+		Storage:      storage,
+		Replicas:     b.Replicas,
+	}
+}
+
+// streamConfig is the part of the bucket's backing stream (KV_<bucket>)
+// that the spec controls.
+func (b BucketSpec) streamConfig() nats.StreamConfig {
+	storage, _ := parseStorage(b.Storage)
+	history := int64(b.History)
+	if history < 1 {
+		history = 1
+	}
+	return nats.StreamConfig{
+		Name:              kvStreamName(b.Bucket),
+		Storage:           storage,
+		MaxAge:            time.Duration(b.TTL),
+		MaxBytes:          unlimited(b.MaxBytes),
+		MaxMsgSize:        int32(unlimited(int64(b.MaxValueSize))),
+		MaxMsgsPerSubject: history,
+		Replicas:          replicas(b.Replicas),
+	}
+}
+
+func kvStreamName(bucket string) string {
+	return "KV_" + bucket
+}
+
+func unlimited(v int64) int64 {
+	if v <= 0 {
+		return -1
+	}
+	return v
+}
+
+func replicas(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func parseRetention(s string) (nats.RetentionPolicy, error) {
+	switch s {
+	case "", "limits":
+		return nats.LimitsPolicy, nil
+	case "interest":
+		return nats.InterestPolicy, nil
+	case "workqueue":
+		return nats.WorkQueuePolicy, nil
+	}
+	return 0, fmt.Errorf("unknown retention %q", s)
+}
+
+func parseStorage(s string) (nats.StorageType, error) {
+	switch s {
+	case "", "file":
+		return nats.FileStorage, nil
+	case "memory":
+		return nats.MemoryStorage, nil
+	}
+	return 0, fmt.Errorf("unknown storage %q", s)
+}
+
+func parseDiscard(s string) (nats.DiscardPolicy, error) {
+	switch s {
+	case "", "old":
+		return nats.DiscardOld, nil
+	case "new":
+		return nats.DiscardNew, nil
+	}
+	return 0, fmt.Errorf("unknown discard policy %q", s)
+}
+
+func streamIndex(streams []StreamSpec, name string) int {
+	for i, s := range streams {
+		if s.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func bucketIndex(buckets []BucketSpec, name string) int {
+	for i, b := range buckets {
+		if b.Bucket == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,287 @@
+package natsbus
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Change kinds and actions reported by PlanInfrastructure.
+const (
+	ChangeKindStream = "stream"
+	ChangeKindBucket = "kv"
+
+	ChangeActionCreate = "create"
+	ChangeActionUpdate = "update"
+)
+
+// Change is a pending difference between the desired and the live
+// configuration of one stream or bucket.
+type Change struct {
+	Kind   string        `json:"kind"`
+	Name   string        `json:"name"`
+	Action string        `json:"action"`
+	Fields []FieldChange `json:"fields,omitempty"`
+
+	stream *nats.StreamConfig
+	bucket *BucketSpec
+	live   *nats.StreamConfig
+}
+
+// FieldChange is a single differing setting. Immutable settings cannot be
+// updated in place; the stream has to be recreated by hand.
+type FieldChange struct {
+	Field     string `json:"field"`
+	Current   string `json:"current"`
+	Desired   string `json:"desired"`
+	Immutable bool   `json:"immutable,omitempty"`
+}
+
+// String renders the change for logs and the `nats plan` report.
+func (c Change) String() string {
+	if c.Action == ChangeActionCreate {
+		return fmt.Sprintf("create %s %s", c.Kind, c.Name)
+	}
+	parts := make([]string, 0, len(c.Fields))
+	for _, f := range c.Fields {
+		part := fmt.Sprintf("%s %s -> %s", f.Field, f.Current, f.Desired)
+		if f.Immutable {
+			part += " (immutable, recreate required)"
+		}
+		parts = append(parts, part)
+	}
+	return fmt.Sprintf("update %s %s: %s", c.Kind, c.Name, strings.Join(parts, "; "))
+}
+
+// Mutable reports whether any of the change can be applied in place.
+func (c Change) Mutable() bool {
+	if c.Action == ChangeActionCreate {
+		return true
+	}
+	for _, f := range c.Fields {
+		if !f.Immutable {
+			return true
+		}
+	}
+	return false
+}
+
+// streamField maps one reconciled setting onto the stream configuration.
+type streamField struct {
+	name      string
+	immutable bool
+	get       func(*nats.StreamConfig) string
+	set       func(dst, src *nats.StreamConfig)
+}
+
+var streamFields = []streamField{
+	{name: "subjects", get: func(c *nats.StreamConfig) string { return subjectList(c.Subjects) },
+		set: func(dst, src *nats.StreamConfig) { dst.Subjects = src.Subjects }},
+	{name: "retention", immutable: true, get: func(c *nats.StreamConfig) string { return c.Retention.String() }},
+	{name: "storage", immutable: true, get: func(c *nats.StreamConfig) string { return c.Storage.String() }},
+	{name: "discard", get: func(c *nats.StreamConfig) string { return c.Discard.String() },
+		set: func(dst, src *nats.StreamConfig) { dst.Discard = src.Discard }},
+	{name: "max_age", get: func(c *nats.StreamConfig) string { return formatAge(c.MaxAge) },
+		set: func(dst, src *nats.StreamConfig) { dst.MaxAge = src.MaxAge }},
+	{name: "max_bytes", get: func(c *nats.StreamConfig) string { return formatLimit(c.MaxBytes) },
+		set: func(dst, src *nats.StreamConfig) { dst.MaxBytes = src.MaxBytes }},
+	{name: "max_msgs", get: func(c *nats.StreamConfig) string { return formatLimit(c.MaxMsgs) },
+		set: func(dst, src *nats.StreamConfig) { dst.MaxMsgs = src.MaxMsgs }},
+	{name: "max_msg_size", get: func(c *nats.StreamConfig) string { return formatLimit(int64(c.MaxMsgSize)) },
+		set: func(dst, src *nats.StreamConfig) { dst.MaxMsgSize = src.MaxMsgSize }},
+	{name: "replicas", get: func(c *nats.StreamConfig) string { return strconv.Itoa(c.Replicas) },
+		set: func(dst, src *nats.StreamConfig) { dst.Replicas = src.Replicas }},
+}
+
+var bucketFields = []streamField{
+	{name: "storage", immutable: true, get: func(c *nats.StreamConfig) string { return c.Storage.String() }},
+	{name: "ttl", get: func(c *nats.StreamConfig) string { return formatAge(c.MaxAge) },
+		set: func(dst, src *nats.StreamConfig) { dst.MaxAge = src.MaxAge }},
+	{name: "max_bytes", get: func(c *nats.StreamConfig) string { return formatLimit(c.MaxBytes) },
+		set: func(dst, src *nats.StreamConfig) { dst.MaxBytes = src.MaxBytes }},
+	{name: "max_value_size", get: func(c *nats.StreamConfig) string { return formatLimit(int64(c.MaxMsgSize)) },
+		set: func(dst, src *nats.StreamConfig) { dst.MaxMsgSize = src.MaxMsgSize }},
+	{name: "history", get: func(c *nats.StreamConfig) string { return strconv.FormatInt(c.MaxMsgsPerSubject, 10) },
+		set: func(dst, src *nats.StreamConfig) { dst.MaxMsgsPerSubject = src.MaxMsgsPerSubject }},
+	{name: "replicas", get: func(c *nats.StreamConfig) string { return strconv.Itoa(c.Replicas) },
+		set: func(dst, src *nats.StreamConfig) { dst.Replicas = src.Replicas }},
+}
+
+// PlanInfrastructure compares cfg with the live streams and buckets and
+// returns the pending changes. Resources already in the desired state are
+// omitted.
+func PlanInfrastructure(js nats.JetStreamContext, cfg InfraConfig) ([]Change, error) {
+	var changes []Change
+
+	for _, spec := range cfg.Streams {
+		desired, err := spec.config()
+		if err != nil {
+			return nil, fmt.Errorf("stream %s: %w", spec.Name, err)
+		}
+		info, err := js.StreamInfo(spec.Name)
+		if errors.Is(err, nats.ErrStreamNotFound) {
+			changes = append(changes, Change{Kind: ChangeKindStream, Name: spec.Name, Action: ChangeActionCreate, stream: &desired})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get stream info %s: %w", spec.Name, err)
+		}
+		if fields := diffFields(streamFields, &info.Config, &desired); len(fields) > 0 {
+			live := info.Config
+			changes = append(changes, Change{Kind: ChangeKindStream, Name: spec.Name, Action: ChangeActionUpdate, Fields: fields, stream: &desired, live: &live})
+		}
+	}
+
+	for _, spec := range cfg.Buckets {
+		spec := spec
+		desired := spec.streamConfig()
+		info, err := js.StreamInfo(desired.Name)
+		if errors.Is(err, nats.ErrStreamNotFound) {
+			changes = append(changes, Change{Kind: ChangeKindBucket, Name: spec.Bucket, Action: ChangeActionCreate, bucket: &spec})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get KV bucket %s: %w", spec.Bucket, err)
+		}
+		if fields := diffFields(bucketFields, &info.Config, &desired); len(fields) > 0 {
+			live := info.Config
+			changes = append(changes, Change{Kind: ChangeKindBucket, Name: spec.Bucket, Action: ChangeActionUpdate, Fields: fields, stream: &desired, live: &live})
+		}
+	}
+
+	return changes, nil
+}
+
+// ApplyInfrastructure creates missing resources and updates the mutable
+// settings of existing ones. Immutable differences are logged and left alone.
+func ApplyInfrastructure(js nats.JetStreamContext, changes []Change) error {
+	for _, change := range changes {
+		switch change.Action {
+		case ChangeActionCreate:
+			if err := create(js, change); err != nil {
+				return err
+			}
+			slog.Info("Created "+describeKind(change.Kind), "name", change.Name)
+
+		case ChangeActionUpdate:
+			for _, f := range change.Fields {
+				if f.Immutable {
+					slog.Warn("NATS setting is immutable; recreate to apply", "kind", change.Kind, "name", change.Name,
+						"field", f.Field, "current", f.Current, "desired", f.Desired)
+				}
+			}
+			if !change.Mutable() {
+				continue
+			}
+			if err := update(js, change); err != nil {
+				return err
+			}
+			slog.Info("Updated NATS "+change.Kind, "name", change.Name, "change", change.String())
+		}
+	}
+	return nil
+}
+
+// ensureInfrastructure reconciles cfg. With dryRun only missing resources
+// are created (the backend cannot run without them); pending updates are
+// logged.
+func ensureInfrastructure(js nats.JetStreamContext, cfg InfraConfig, dryRun bool) error {
+	changes, err := PlanInfrastructure(js, cfg)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		var creates []Change
+		for _, change := range changes {
+			if change.Action == ChangeActionCreate {
+				creates = append(creates, change)
+			} else {
+				slog.Warn("NATS infra pending (dry run)", "kind", change.Kind, "name", change.Name, "change", change.String())
+			}
+		}
+		changes = creates
+	}
+	return ApplyInfrastructure(js, changes)
+}
+
+func create(js nats.JetStreamContext, change Change) error {
+	if change.Kind == ChangeKindBucket {
+		if _, err := js.CreateKeyValue(change.bucket.kvConfig()); err != nil {
+			return fmt.Errorf("create KV bucket %s: %w", change.Name, err)
+		}
+		return nil
+	}
+	if _, err := js.AddStream(change.stream); err != nil {
+		return fmt.Errorf("create stream %s: %w", change.Name, err)
+	}
+	return nil
+}
+
+// update applies the mutable fields over the live configuration so settings
+// the spec does not manage (duplicates window, allow_direct, ...) are kept.
+func update(js nats.JetStreamContext, change Change) error {
+	fields := streamFields
+	if change.Kind == ChangeKindBucket {
+		fields = bucketFields
+	}
+
+	next := *change.live
+	for _, f := range fields {
+		if f.set != nil {
+			f.set(&next, change.stream)
+		}
+	}
+	// The server rejects a duplicates window longer than max_age.
+	if next.MaxAge > 0 && next.Duplicates > next.MaxAge {
+		next.Duplicates = next.MaxAge
+	}
+
+	if _, err := js.UpdateStream(&next); err != nil {
+		return fmt.Errorf("update %s %s: %w", change.Kind, change.Name, err)
+	}
+	return nil
+}
+
+func diffFields(fields []streamField, live, desired *nats.StreamConfig) []FieldChange {
+	var out []FieldChange
+	for _, f := range fields {
+		current, want := f.get(live), f.get(desired)
+		if current != want {
+			out = append(out, FieldChange{Field: f.name, Current: current, Desired: want, Immutable: f.immutable})
+		}
+	}
+	return out
+}
+
+func describeKind(kind string) string {
+	if kind == ChangeKindBucket {
+		return "KV bucket"
+	}
+	return "JetStream stream"
+}
+
+func subjectList(subjects []string) string {
+	sorted := append([]string(nil), subjects...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+func formatAge(d time.Duration) string {
+	if d <= 0 {
+		return "unlimited"
+	}
+	return d.String()
+}
+
+func formatLimit(v int64) string {
+	if v < 0 {
+		return "unlimited"
+	}
+	return strconv.FormatInt(v, 10)
+}
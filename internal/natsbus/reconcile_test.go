@@ -0,0 +1,72 @@
+package natsbus
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestDiffFields(t *testing.T) {
+	desired := &nats.StreamConfig{
+		Subjects:   []string{"ops.a", "ops.b"},
+		Retention:  nats.LimitsPolicy,
+		Storage:    nats.FileStorage,
+		MaxAge:     24 * time.Hour,
+		MaxBytes:   -1,
+		MaxMsgs:    -1,
+		MaxMsgSize: 1024,
+		Replicas:   1,
+	}
+
+	tests := []struct {
+		name string
+		live func(c nats.StreamConfig) nats.StreamConfig
+		want []FieldChange
+	}{
+		{
+			name: "in sync",
+			live: func(c nats.StreamConfig) nats.StreamConfig { return c },
+		},
+		{
+			name: "subject order is ignored",
+			live: func(c nats.StreamConfig) nats.StreamConfig {
+				c.Subjects = []string{"ops.b", "ops.a"}
+				return c
+			},
+		},
+		{
+			name: "mutable limits",
+			live: func(c nats.StreamConfig) nats.StreamConfig {
+				c.MaxAge = 0
+				c.MaxBytes = 1 << 20
+				return c
+			},
+			want: []FieldChange{
+				{Field: "max_age", Current: "unlimited", Desired: "24h0m0s"},
+				{Field: "max_bytes", Current: "1048576", Desired: "unlimited"},
+			},
+		},
+		{
+			name: "immutable storage",
+			live: func(c nats.StreamConfig) nats.StreamConfig {
+				c.Storage = nats.MemoryStorage
+				return c
+			},
+			want: []FieldChange{
+				{Field: "storage", Current: nats.MemoryStorage.String(), Desired: nats.FileStorage.String(), Immutable: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			live := tt.live(*desired)
+			got := diffFields(streamFields, &live, desired)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("diffFields = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+// Package protocol decodes agent messages by protocol version. Each message
+// kind has a decoder per supported version; the version is read from the
+// msgpack "v" field before the full decode. Messages without a version
+// (v = 0) predate versioning and are read as v1.
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"opspilot-backend/internal/models"
+)
+
+// Message kinds.
+const (
+	KindEvent     = "event"
+	KindHeartbeat = "heartbeat"
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+	ErrInvalidMessage     = errors.New("invalid message")
+)
+
+// Size limits; larger messages are rejected before decoding.
+const (
+	MaxEventSize     = 1024 * 1024 // default OPS_EVENTS max_msg_size
+	MaxHeartbeatSize = 64 * 1024   // well above the AGENTS max_value_size
+)
+
+type eventDecoder func(data []byte) (*models.Event, error)
+type heartbeatDecoder func(data []byte) (*models.Heartbeat, error)
+
+var eventDecoders = map[int]eventDecoder{
+	1: decodeEventV1,
+}
+
+var heartbeatDecoders = map[int]heartbeatDecoder{
+	1: decodeHeartbeatV1,
+}
+
+// Supported lists the versions the backend decodes, advertised to agents at
+// enrollment, on JWT renewal and on GET /api/v1/protocol.
+func Supported() models.ProtocolVersions {
+	return models.ProtocolVersions{
+		Events:     versions(eventDecoders),
+		Heartbeats: versions(heartbeatDecoders),
+	}
+}
+
+// DecodeEvent decodes and validates an event.
+func DecodeEvent(data []byte) (*models.Event, error) {
+	if len(data) > MaxEventSize {
+		counters.add(KindEvent, 0, ResultInvalid)
+		return nil, fmt.Errorf("%w: event is %d bytes, limit %d", ErrInvalidMessage, len(data), MaxEventSize)
+	}
+	version, err := peekVersion(data)
+	if err != nil {
+		counters.add(KindEvent, 0, ResultInvalid)
+		return nil, err
+	}
+	decode, ok := eventDecoders[version]
+	if !ok {
+		counters.add(KindEvent, version, ResultUnsupported)
+		return nil, fmt.Errorf("%w: event v%d", ErrUnsupportedVersion, version)
+	}
+	event, err := decode(data)
+	if err != nil {
+		counters.add(KindEvent, version, ResultInvalid)
+		return nil, err
+	}
+	counters.add(KindEvent, version, ResultAccepted)
+	return event, nil
+}
+
+// DecodeHeartbeat decodes and validates a heartbeat.
+func DecodeHeartbeat(data []byte) (*models.Heartbeat, error) {
+	if len(data) > MaxHeartbeatSize {
+		counters.add(KindHeartbeat, 0, ResultInvalid)
+		return nil, fmt.Errorf("%w: heartbeat is %d bytes, limit %d", ErrInvalidMessage, len(data), MaxHeartbeatSize)
+	}
+	version, err := peekVersion(data)
+	if err != nil {
+		counters.add(KindHeartbeat, 0, ResultInvalid)
+		return nil, err
+	}
+	decode, ok := heartbeatDecoders[version]
+	if !ok {
+		counters.add(KindHeartbeat, version, ResultUnsupported)
+		return nil, fmt.Errorf("%w: heartbeat v%d", ErrUnsupportedVersion, version)
+	}
+	hb, err := decode(data)
+	if err != nil {
+		counters.add(KindHeartbeat, version, ResultInvalid)
+		return nil, err
+	}
+	counters.add(KindHeartbeat, version, ResultAccepted)
+	return hb, nil
+}
+
+// peekVersion reads only the "v" field.
+func peekVersion(data []byte) (int, error) {
+	var header struct {
+		V int `msgpack:"v"`
+	}
+	if err := msgpack.Unmarshal(data, &header); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	if header.V == 0 {
+		return 1, nil
+	}
+	return header.V, nil
+}
+
+func versions[T any](decoders map[int]T) []int {
+	out := make([]int, 0, len(decoders))
+	for v := range decoders {
+		out = append(out, v)
+	}
+	sort.Ints(out)
+	return out
+}
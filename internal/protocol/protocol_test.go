@@ -0,0 +1,197 @@
+package protocol
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const testAgentID = "0123456789ab"
+
+func encode(t *testing.T, v any) []byte {
+	t.Helper()
+	data, err := msgpack.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return data
+}
+
+func TestDecodeEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    func(t *testing.T) []byte
+		wantErr error
+	}{
+		{
+			name: "v1",
+			data: func(t *testing.T) []byte {
+				return encode(t, map[string]any{"v": 1, "agent_id": testAgentID, "alert_type": "oom", "message": "killed"})
+			},
+		},
+		{
+			name: "unversioned reads as v1",
+			data: func(t *testing.T) []byte {
+				return encode(t, map[string]any{"agent_id": testAgentID, "alert_type": "oom"})
+			},
+		},
+		{
+			name: "unsupported version",
+			data: func(t *testing.T) []byte {
+				return encode(t, map[string]any{"v": 99, "agent_id": testAgentID, "alert_type": "oom"})
+			},
+			wantErr: ErrUnsupportedVersion,
+		},
+		{
+			name:    "not msgpack",
+			data:    func(t *testing.T) []byte { return []byte{0xc1} },
+			wantErr: ErrInvalidMessage,
+		},
+		{
+			name: "invalid agent id",
+			data: func(t *testing.T) []byte {
+				return encode(t, map[string]any{"v": 1, "agent_id": "ABC", "alert_type": "oom"})
+			},
+			wantErr: ErrInvalidMessage,
+		},
+		{
+			name: "missing alert type",
+			data: func(t *testing.T) []byte {
+				return encode(t, map[string]any{"v": 1, "agent_id": testAgentID})
+			},
+			wantErr: ErrInvalidMessage,
+		},
+		{
+			name: "alert type too long",
+			data: func(t *testing.T) []byte {
+				return encode(t, map[string]any{"v": 1, "agent_id": testAgentID, "alert_type": strings.Repeat("a", maxAlertTypeLen+1)})
+			},
+			wantErr: ErrInvalidMessage,
+		},
+		{
+			name: "too large",
+			data: func(t *testing.T) []byte {
+				return encode(t, map[string]any{"v": 1, "agent_id": testAgentID, "alert_type": "oom", "message": strings.Repeat("a", MaxEventSize)})
+			},
+			wantErr: ErrInvalidMessage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := DecodeEvent(tt.data(t))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			if event.V != 1 || event.AgentID != testAgentID || event.AlertType != "oom" {
+				t.Fatalf("event = %+v", event)
+			}
+		})
+	}
+}
+
+func TestDecodeHeartbeat(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    func(t *testing.T) []byte
+		wantErr error
+	}{
+		{
+			name: "v1",
+			data: func(t *testing.T) []byte {
+				return encode(t, map[string]any{"v": 1, "agent_id": testAgentID, "hostname": "web-1", "config_version": 3})
+			},
+		},
+		{
+			name: "agent id is optional",
+			data: func(t *testing.T) []byte {
+				return encode(t, map[string]any{"v": 1, "hostname": "web-1"})
+			},
+		},
+		{
+			name: "unsupported version",
+			data: func(t *testing.T) []byte {
+				return encode(t, map[string]any{"v": 2, "hostname": "web-1"})
+			},
+			wantErr: ErrUnsupportedVersion,
+		},
+		{
+			name: "invalid agent id",
+			data: func(t *testing.T) []byte {
+				return encode(t, map[string]any{"v": 1, "agent_id": "not-hex-id!!"})
+			},
+			wantErr: ErrInvalidMessage,
+		},
+		{
+			name: "hostname too long",
+			data: func(t *testing.T) []byte {
+				return encode(t, map[string]any{"v": 1, "hostname": strings.Repeat("h", maxHostnameLen+1)})
+			},
+			wantErr: ErrInvalidMessage,
+		},
+		{
+			name: "too many capabilities",
+			data: func(t *testing.T) []byte {
+				return encode(t, map[string]any{"v": 1, "capabilities": make([]string, maxListLen+1)})
+			},
+			wantErr: ErrInvalidMessage,
+		},
+		{
+			name: "negative config version",
+			data: func(t *testing.T) []byte {
+				return encode(t, map[string]any{"v": 1, "config_version": -1})
+			},
+			wantErr: ErrInvalidMessage,
+		},
+		{
+			name:    "too large",
+			data:    func(t *testing.T) []byte { return make([]byte, MaxHeartbeatSize+1) },
+			wantErr: ErrInvalidMessage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hb, err := DecodeHeartbeat(tt.data(t))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			if hb.V != 1 || hb.Hostname != "web-1" {
+				t.Fatalf("heartbeat = %+v", hb)
+			}
+		})
+	}
+}
+
+func TestValidAgentID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{testAgentID, true},
+		{"", false},
+		{"0123456789a", false},
+		{"0123456789abc", false},
+		{"0123456789AB", false},
+		{"0123456789ag", false},
+	}
+	for _, tt := range tests {
+		if got := ValidAgentID(tt.id); got != tt.want {
+			t.Errorf("ValidAgentID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package protocol
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"opspilot-backend/internal/metrics"
+	"opspilot-backend/internal/models"
+)
+
+// Decode results counted per kind and version.
+const (
+	ResultAccepted    = "accepted"
+	ResultInvalid     = "invalid"
+	ResultUnsupported = "unsupported"
+)
+
+type counterKey struct {
+	kind    string
+	version int
+	result  string
+}
+
+type counterSet struct {
+	mu     sync.Mutex
+	counts map[counterKey]uint64
+}
+
+var counters = &counterSet{counts: make(map[counterKey]uint64)}
+
+func (c *counterSet) add(kind string, version int, result string) {
+	c.mu.Lock()
+	c.counts[counterKey{kind: kind, version: version, result: result}]++
+	c.mu.Unlock()
+	metrics.ProtocolMessages.WithLabelValues(kind, strconv.Itoa(version), result).Inc()
+}
+
+// Stats returns the decode counters of this process since start. Version 0
+// counts messages rejected before their version was known.
+func Stats() []models.ProtocolStat {
+	counters.mu.Lock()
+	stats := make([]models.ProtocolStat, 0, len(counters.counts))
+	for key, count := range counters.counts {
+		stats = append(stats, models.ProtocolStat{Kind: key.kind, Version: key.version, Result: key.result, Count: count})
+	}
+	counters.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.Result < b.Result
+	})
+	return stats
+}
@@ -0,0 +1,77 @@
+package protocol
+
+import (
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"opspilot-backend/internal/models"
+)
+
+// v1 field limits.
+const (
+	maxAlertTypeLen    = 64
+	maxEventMessageLen = 64 * 1024
+	maxEventDetails    = 256
+	maxHostnameLen     = 255
+	maxVersionLen      = 64
+	maxListLen         = 128
+)
+
+func decodeEventV1(data []byte) (*models.Event, error) {
+	var event models.Event
+	if err := msgpack.Unmarshal(data, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	event.V = 1
+
+	switch {
+	case !ValidAgentID(event.AgentID):
+		return nil, fmt.Errorf("%w: agent_id %q", ErrInvalidMessage, event.AgentID)
+	case event.AlertType == "":
+		return nil, fmt.Errorf("%w: alert_type is required", ErrInvalidMessage)
+	case len(event.AlertType) > maxAlertTypeLen:
+		return nil, fmt.Errorf("%w: alert_type longer than %d", ErrInvalidMessage, maxAlertTypeLen)
+	case len(event.Message) > maxEventMessageLen:
+		return nil, fmt.Errorf("%w: message longer than %d", ErrInvalidMessage, maxEventMessageLen)
+	case len(event.Details) > maxEventDetails:
+		return nil, fmt.Errorf("%w: more than %d details", ErrInvalidMessage, maxEventDetails)
+	}
+	return &event, nil
+}
+
+func decodeHeartbeatV1(data []byte) (*models.Heartbeat, error) {
+	var hb models.Heartbeat
+	if err := msgpack.Unmarshal(data, &hb); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	hb.V = 1
+
+	switch {
+	case hb.AgentID != "" && !ValidAgentID(hb.AgentID):
+		return nil, fmt.Errorf("%w: agent_id %q", ErrInvalidMessage, hb.AgentID)
+	case len(hb.Hostname) > maxHostnameLen:
+		return nil, fmt.Errorf("%w: hostname longer than %d", ErrInvalidMessage, maxHostnameLen)
+	case len(hb.AgentVersion) > maxVersionLen:
+		return nil, fmt.Errorf("%w: agent_version longer than %d", ErrInvalidMessage, maxVersionLen)
+	case len(hb.Capabilities) > maxListLen || len(hb.Actions) > maxListLen:
+		return nil, fmt.Errorf("%w: more than %d capabilities or actions", ErrInvalidMessage, maxListLen)
+	case hb.ConfigVersion < 0:
+		return nil, fmt.Errorf("%w: negative config_version", ErrInvalidMessage)
+	}
+	return &hb, nil
+}
+
+// ValidAgentID reports whether id is 12 lowercase hex characters. Enrollment
+// and agent creation apply the same rule, so every registered agent can send.
+func ValidAgentID(id string) bool {
+	if len(id) != 12 {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -1,6 +1,7 @@
 package rpc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
@@ -8,8 +9,13 @@ import (
 	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
 	"github.com/vmihailenco/msgpack/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
+	"opspilot-backend/internal/metrics"
 	"opspilot-backend/internal/models"
+	"opspilot-backend/internal/tracing"
 )
 
 var (
@@ -26,7 +32,65 @@ func NewClient(nc *nats.Conn) *Client {
 }
 
 // ExecAction sends an action request to an agent and waits for response.
-func (c *Client) ExecAction(agentID string, action string, args map[string]string, timeoutMS int) (*models.ActionResponseV3, error) {
+// The trace context of ctx is passed to the agent in the request headers.
+func (c *Client) ExecAction(ctx context.Context, agentID string, action string, args map[string]string, timeoutMS int) (*models.ActionResponseV3, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "rpc "+action,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("agent_id", agentID),
+			attribute.String("rpc.action", action),
+		),
+	)
+	defer span.End()
+
+	start := time.Now()
+	resp, err := c.execAction(ctx, agentID, action, args, timeoutMS)
+	code := resultCode(resp, err)
+	metrics.RPCDuration.WithLabelValues(action).Observe(time.Since(start).Seconds())
+	metrics.RPCRequests.WithLabelValues(action, code).Inc()
+
+	span.SetAttributes(attribute.String("rpc.code", code))
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case !resp.Success:
+		span.SetStatus(codes.Error, code)
+	}
+	return resp, err
+}
+
+// agentErrorCodes are the agent error_code values used as metric labels as
+// they are; any other code is reported as "other" so agents cannot grow the
+// label set.
+var agentErrorCodes = map[string]bool{
+	"unknown_action": true,
+	"invalid_args":   true,
+	"not_allowed":    true,
+	"exec_failed":    true,
+}
+
+// resultCode is the code label of an RPC: ok, a known agent error_code (or
+// other), failed without one, or offline/timeout/error when no response arrived.
+func resultCode(resp *models.ActionResponseV3, err error) string {
+	switch {
+	case errors.Is(err, ErrAgentOffline):
+		return "offline"
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case err != nil:
+		return "error"
+	case resp.Success:
+		return "ok"
+	case agentErrorCodes[resp.ErrorCode]:
+		return resp.ErrorCode
+	case resp.ErrorCode != "":
+		return "other"
+	}
+	return "failed"
+}
+
+func (c *Client) execAction(ctx context.Context, agentID string, action string, args map[string]string, timeoutMS int) (*models.ActionResponseV3, error) {
 	req := models.ActionRequestV3{
 		Action:    action,
 		Args:      args,
@@ -47,8 +111,14 @@ func (c *Client) ExecAction(agentID string, action string, args map[string]strin
 		timeout = 125 * time.Second
 	}
 
-	subject := fmt.Sprintf("ops.%s.rpc", agentID)
-	msg, err := c.nc.Request(subject, payload, timeout)
+	msg := &nats.Msg{
+		Subject: fmt.Sprintf("ops.%s.rpc", agentID),
+		Data:    payload,
+	}
+	tracing.InjectNATS(ctx, msg)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("rpc.request_id", req.RequestID))
+
+	reply, err := c.nc.RequestMsg(msg, timeout)
 	if err != nil {
 		if errors.Is(err, nats.ErrNoResponders) {
 			return nil, ErrAgentOffline
@@ -60,7 +130,7 @@ func (c *Client) ExecAction(agentID string, action string, args map[string]strin
 	}
 
 	var resp models.ActionResponseV3
-	if err := msgpack.Unmarshal(msg.Data, &resp); err != nil {
+	if err := msgpack.Unmarshal(reply.Data, &resp); err != nil {
 		return nil, fmt.Errorf("unmarshal response: %w", err)
 	}
 
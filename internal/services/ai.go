@@ -2,15 +2,24 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"opspilot-backend/internal/metrics"
 	"opspilot-backend/internal/models"
+	"opspilot-backend/internal/tracing"
 )
 
 type OpenRouterClient struct {
@@ -48,7 +57,8 @@ func NewOpenRouterClient() *OpenRouterClient {
 		apiKey:  apiKey,
 		baseURL: "https://openrouter.ai/api/v1",
 		client: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:   30 * time.Second,
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
 		},
 	}
 }
@@ -101,10 +111,36 @@ EXAMPLES:
 
 If no action is needed or safe, omit "suggested_action" field entirely.`
 
-func (c *OpenRouterClient) AnalyzeIncident(incident *models.Incident) (*models.AIAnalysis, error) {
+func (c *OpenRouterClient) AnalyzeIncident(ctx context.Context, incident *models.Incident) (*models.AIAnalysis, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "ai.analyze_incident", trace.WithAttributes(
+		attribute.Int("incident_id", incident.ID),
+		attribute.String("agent_id", incident.AgentID),
+	))
+	defer span.End()
+
+	start := time.Now()
+	analysis, fallback, err := c.analyze(ctx, incident)
+	metrics.AIDuration.Observe(time.Since(start).Seconds())
+
+	switch {
+	case err != nil:
+		metrics.AIRequests.WithLabelValues("error").Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case fallback:
+		metrics.AIRequests.WithLabelValues("fallback").Inc()
+	default:
+		metrics.AIRequests.WithLabelValues("ok").Inc()
+	}
+	span.SetAttributes(attribute.Bool("ai.fallback", fallback))
+	return analysis, err
+}
+
+// analyze reports whether the fallback analysis was returned.
+func (c *OpenRouterClient) analyze(ctx context.Context, incident *models.Incident) (*models.AIAnalysis, bool, error) {
 	prompt := c.buildPrompt(incident)
 
-	fmt.Printf("[AI] Sending prompt:\n%s\n", prompt)
+	slog.DebugContext(ctx, "AI request", "incident_id", incident.ID, "prompt_bytes", len(prompt))
 
 	req := OpenRouterRequest{
 		Model: "qwen/qwen-2.5-coder-32b-instruct",
@@ -122,12 +158,12 @@ func (c *OpenRouterClient) AnalyzeIncident(incident *models.Incident) (*models.A
 
 	reqBody, err := json.Marshal(req)
 	if err != nil {
-		return nil, fmt.Errorf("marshal error: %w", err)
+		return nil, false, fmt.Errorf("marshal error: %w", err)
 	}
 
-	httpReq, err := http.NewRequest("POST", c.baseURL+"/chat/completions", bytes.NewReader(reqBody))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/chat/completions", bytes.NewReader(reqBody))
 	if err != nil {
-		return nil, fmt.Errorf("create request error: %w", err)
+		return nil, false, fmt.Errorf("create request error: %w", err)
 	}
 
 	httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
@@ -137,40 +173,51 @@ func (c *OpenRouterClient) AnalyzeIncident(incident *models.Incident) (*models.A
 
 	resp, err := c.client.Do(httpReq)
 	if err != nil {
-		return c.fallbackAnalysis(incident), nil
+		slog.WarnContext(ctx, "AI request failed, using fallback", "incident_id", incident.ID, "err", err)
+		return c.fallbackAnalysis(incident), true, nil
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		fmt.Printf("OpenRouter error: %s\n", string(body))
-		return c.fallbackAnalysis(incident), nil
+		slog.WarnContext(ctx, "AI request failed, using fallback", "incident_id", incident.ID,
+			"status", resp.StatusCode, "body", truncate(string(body), 512))
+		return c.fallbackAnalysis(incident), true, nil
 	}
 
 	var orResp OpenRouterResponse
 	if err := json.NewDecoder(resp.Body).Decode(&orResp); err != nil {
-		return c.fallbackAnalysis(incident), nil
+		slog.WarnContext(ctx, "AI response decode failed, using fallback", "incident_id", incident.ID, "err", err)
+		return c.fallbackAnalysis(incident), true, nil
 	}
 
 	if len(orResp.Choices) == 0 {
-		return c.fallbackAnalysis(incident), nil
+		return c.fallbackAnalysis(incident), true, nil
 	}
 
 	aiContent := orResp.Choices[0].Message.Content
-	fmt.Printf("[AI] Raw response: %s\n", aiContent)
+	slog.DebugContext(ctx, "AI response", "incident_id", incident.ID, "response", aiContent)
 
 	// Попытка извлечь JSON из ответа (AI может добавить текст вокруг JSON)
 	jsonStr := extractJSON(aiContent)
 
 	var analysis models.AIAnalysis
 	if err := json.Unmarshal([]byte(jsonStr), &analysis); err != nil {
-		fmt.Printf("[AI] JSON parse error: %v, using fallback\n", err)
-		return c.fallbackAnalysis(incident), nil
+		slog.WarnContext(ctx, "AI response parse failed, using fallback", "incident_id", incident.ID, "err", err)
+		return c.fallbackAnalysis(incident), true, nil
 	}
 
-	fmt.Printf("[AI] Parsed: analysis=%s, is_critical=%v, suggested_action=%+v\n",
-		analysis.Analysis, analysis.IsCritical, analysis.SuggestedAction)
-	return &analysis, nil
+	slog.InfoContext(ctx, "AI analysis parsed", "incident_id", incident.ID,
+		"is_critical", analysis.IsCritical, "suggested_action", analysis.SuggestedAction != nil)
+	return &analysis, false, nil
+}
+
+// truncate cuts s to at most n bytes for logging.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
 }
 
 // extractJSON пытается извлечь JSON объект из текста
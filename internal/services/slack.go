@@ -1,7 +1,7 @@
 package services
 
 import (
-	"log"
+	"log/slog"
 
 	"opspilot-backend/internal/models"
 )
@@ -20,7 +20,7 @@ func (s *SlackClient) SendAlert(incident *models.Incident, agent *models.Agent)
 		if agent != nil {
 			agentID = agent.AgentID
 		}
-		log.Printf("Slack disabled, would send alert: incident=%d agent=%s", incident.ID, agentID)
+		slog.Info("Slack disabled, would send alert", "incident_id", incident.ID, "agent_id", agentID)
 		return nil
 	}
 	return nil
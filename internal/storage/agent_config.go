@@ -0,0 +1,373 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"opspilot-backend/internal/models"
+)
+
+var ErrTemplateNameTaken = errors.New("config template name already taken")
+
+const configTemplateColumns = `id, org_id, name, priority, match_tags, config, updated_by, created_at, updated_at`
+
+// ListConfigTemplates returns the org's templates in merge order.
+func (s *Storage) ListConfigTemplates(ctx context.Context, orgID string) ([]models.ConfigTemplate, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+configTemplateColumns+`
+		FROM config_templates
+		WHERE org_id = $1
+		ORDER BY priority, name
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := make([]models.ConfigTemplate, 0)
+	for rows.Next() {
+		template, err := scanConfigTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *template)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+func (s *Storage) CreateConfigTemplate(ctx context.Context, orgID, userID string, input models.ConfigTemplateInput) (*models.ConfigTemplate, error) {
+	tagsJSON, configJSON, err := configTemplateJSON(input)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := scanConfigTemplate(s.db.QueryRowContext(ctx, `
+		INSERT INTO config_templates (org_id, name, priority, match_tags, config, updated_by)
+		VALUES ($1, $2, $3, $4::jsonb, $5::jsonb, $6)
+		RETURNING `+configTemplateColumns,
+		orgID, input.Name, input.Priority, tagsJSON, configJSON, nullIfEmpty(userID)))
+	if isUniqueViolation(err) {
+		return nil, ErrTemplateNameTaken
+	}
+	return template, err
+}
+
+// UpdateConfigTemplate replaces a template; sql.ErrNoRows if it is not in the org.
+func (s *Storage) UpdateConfigTemplate(ctx context.Context, orgID, id, userID string, input models.ConfigTemplateInput) (*models.ConfigTemplate, error) {
+	tagsJSON, configJSON, err := configTemplateJSON(input)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := scanConfigTemplate(s.db.QueryRowContext(ctx, `
+		UPDATE config_templates
+		SET name = $3, priority = $4, match_tags = $5::jsonb, config = $6::jsonb,
+			updated_by = $7, updated_at = now()
+		WHERE org_id = $1 AND id = $2
+		RETURNING `+configTemplateColumns,
+		orgID, id, input.Name, input.Priority, tagsJSON, configJSON, nullIfEmpty(userID)))
+	if isUniqueViolation(err) {
+		return nil, ErrTemplateNameTaken
+	}
+	return template, err
+}
+
+// DeleteConfigTemplate returns sql.ErrNoRows if the template is not in the org.
+func (s *Storage) DeleteConfigTemplate(ctx context.Context, orgID, id string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM config_templates WHERE org_id = $1 AND id = $2`, orgID, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetAgentConfigOverride returns the agent's override, or nil if none is set.
+func (s *Storage) GetAgentConfigOverride(ctx context.Context, agentID string) (*models.AgentConfig, error) {
+	var configJSON []byte
+	err := s.db.QueryRowContext(ctx, `SELECT config FROM agent_config_overrides WHERE agent_id = $1`, agentID).Scan(&configJSON)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config models.AgentConfig
+	if err := json.Unmarshal(configJSON, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// SetAgentConfigOverride stores the agent's override; nil removes it.
+func (s *Storage) SetAgentConfigOverride(ctx context.Context, agentID, userID string, config *models.AgentConfig) error {
+	if config == nil {
+		_, err := s.db.ExecContext(ctx, `DELETE FROM agent_config_overrides WHERE agent_id = $1`, agentID)
+		return err
+	}
+
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO agent_config_overrides (agent_id, config, updated_by, updated_at)
+		VALUES ($1, $2::jsonb, $3, now())
+		ON CONFLICT (agent_id) DO UPDATE SET
+			config = EXCLUDED.config,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = EXCLUDED.updated_at
+	`, agentID, string(configJSON), nullIfEmpty(userID))
+	return err
+}
+
+// GetAgentConfigState returns the agent's published config, or nil if none
+// was published yet.
+func (s *Storage) GetAgentConfigState(ctx context.Context, agentID string) (*models.AgentConfigState, error) {
+	var (
+		state      models.AgentConfigState
+		configJSON []byte
+		applied    sql.NullInt64
+	)
+	err := s.db.QueryRowContext(ctx, `
+		SELECT agent_id, version, hash, config, published_at, applied_version, applied_at
+		FROM agent_configs
+		WHERE agent_id = $1
+	`, agentID).Scan(&state.AgentID, &state.Version, &state.Hash, &configJSON, &state.PublishedAt, &applied, &state.AppliedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(configJSON, &state.Config); err != nil {
+		return nil, err
+	}
+	if applied.Valid {
+		state.AppliedVersion = &applied.Int64
+		state.InSync = applied.Int64 == state.Version
+	}
+	return &state, nil
+}
+
+// SaveAgentConfig stores config as the agent's desired config. When hash
+// differs from the stored one the version is bumped and a history row is
+// added; changed reports whether that happened.
+func (s *Storage) SaveAgentConfig(ctx context.Context, agentID, hash string, config models.AgentConfig) (int64, bool, error) {
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return 0, false, err
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	var version int64
+	var currentHash string
+	err = tx.QueryRowContext(ctx, `
+		SELECT version, hash FROM agent_configs WHERE agent_id = $1 FOR UPDATE
+	`, agentID).Scan(&version, &currentHash)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, false, err
+	}
+	if err == nil && currentHash == hash {
+		return version, false, nil
+	}
+	version++
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO agent_configs (agent_id, version, hash, config, published_at)
+		VALUES ($1, $2, $3, $4::jsonb, now())
+		ON CONFLICT (agent_id) DO UPDATE SET
+			version = EXCLUDED.version,
+			hash = EXCLUDED.hash,
+			config = EXCLUDED.config,
+			published_at = EXCLUDED.published_at
+	`, agentID, version, hash, string(configJSON)); err != nil {
+		return 0, false, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO agent_config_history (agent_id, version, hash, config)
+		VALUES ($1, $2, $3, $4::jsonb)
+	`, agentID, version, hash, string(configJSON)); err != nil {
+		return 0, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, false, err
+	}
+	return version, true, nil
+}
+
+// SetAppliedConfigVersion records the config version an agent reported.
+func (s *Storage) SetAppliedConfigVersion(ctx context.Context, agentID string, version int64) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE agent_configs SET applied_version = $2, applied_at = now()
+		WHERE agent_id = $1 AND applied_version IS DISTINCT FROM $2
+	`, agentID, version)
+	return err
+}
+
+func (s *Storage) ListAgentConfigHistory(ctx context.Context, agentID string, limit int) ([]models.AgentConfigVersion, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT version, hash, config, created_at
+		FROM agent_config_history
+		WHERE agent_id = $1
+		ORDER BY version DESC
+		LIMIT $2
+	`, agentID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make([]models.AgentConfigVersion, 0)
+	for rows.Next() {
+		var version models.AgentConfigVersion
+		var configJSON []byte
+		if err := rows.Scan(&version.Version, &version.Hash, &configJSON, &version.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(configJSON, &version.Config); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// ListOrgAgentIDs returns the agent ids of an org.
+func (s *Storage) ListOrgAgentIDs(ctx context.Context, orgID string) ([]string, error) {
+	ids := make([]string, 0)
+	if err := s.db.SelectContext(ctx, &ids, `SELECT agent_id FROM agents WHERE org_id = $1 ORDER BY agent_id`, orgID); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// configTargetStatuses excludes agents that get no config: pending ones
+// awaiting approval and rejected ones.
+const configTargetStatuses = `status NOT IN ('pending', 'rejected')`
+
+// ConfigTarget is an agent whose desired config is published, with what the
+// config is derived from and the state of its current one.
+type ConfigTarget struct {
+	AgentID  string
+	Tags     []string
+	Override *models.AgentConfig
+	// Hash is the hash of the stored config, "" if none was published yet.
+	Hash string
+	// InSync reports whether the agent applied the stored version.
+	InSync bool
+}
+
+// ListConfigTargets returns the org's agents that get a config, in one query.
+func (s *Storage) ListConfigTargets(ctx context.Context, orgID string) ([]ConfigTarget, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT a.agent_id, a.tags, o.config, COALESCE(c.hash, ''),
+			COALESCE(c.applied_version = c.version, false)
+		FROM agents a
+		LEFT JOIN agent_config_overrides o ON o.agent_id = a.agent_id
+		LEFT JOIN agent_configs c ON c.agent_id = a.agent_id
+		WHERE a.org_id = $1 AND a.`+configTargetStatuses+`
+		ORDER BY a.agent_id
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := make([]ConfigTarget, 0)
+	for rows.Next() {
+		var (
+			target       ConfigTarget
+			tagsJSON     []byte
+			overrideJSON []byte
+		)
+		if err := rows.Scan(&target.AgentID, &tagsJSON, &overrideJSON, &target.Hash, &target.InSync); err != nil {
+			return nil, err
+		}
+		if target.Tags, err = decodeStringArray(tagsJSON); err != nil {
+			return nil, err
+		}
+		if overrideJSON != nil {
+			target.Override = &models.AgentConfig{}
+			if err := json.Unmarshal(overrideJSON, target.Override); err != nil {
+				return nil, err
+			}
+		}
+		targets = append(targets, target)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// ListConfigTargetOrgIDs returns the orgs that have agents getting a config.
+func (s *Storage) ListConfigTargetOrgIDs(ctx context.Context) ([]string, error) {
+	ids := make([]string, 0)
+	err := s.db.SelectContext(ctx, &ids, `
+		SELECT DISTINCT org_id::text FROM agents
+		WHERE org_id IS NOT NULL AND `+configTargetStatuses+`
+		ORDER BY 1
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func configTemplateJSON(input models.ConfigTemplateInput) (string, string, error) {
+	tagsJSON, err := json.Marshal(nonNilStrings(input.MatchTags))
+	if err != nil {
+		return "", "", err
+	}
+	configJSON, err := json.Marshal(input.Config)
+	if err != nil {
+		return "", "", err
+	}
+	return string(tagsJSON), string(configJSON), nil
+}
+
+func scanConfigTemplate(scanner rowScanner) (*models.ConfigTemplate, error) {
+	var (
+		template   models.ConfigTemplate
+		tagsJSON   []byte
+		configJSON []byte
+		updatedBy  sql.NullString
+	)
+	if err := scanner.Scan(
+		&template.ID, &template.OrgID, &template.Name, &template.Priority, &tagsJSON,
+		&configJSON, &updatedBy, &template.CreatedAt, &template.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if template.MatchTags, err = decodeStringArray(tagsJSON); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(configJSON, &template.Config); err != nil {
+		return nil, err
+	}
+	if updatedBy.Valid {
+		template.UpdatedBy = &updatedBy.String
+	}
+	return &template, nil
+}
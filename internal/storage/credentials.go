@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"opspilot-backend/internal/models"
+)
+
+// GetActiveCredential returns the non-revoked credential for agentID and publicKey.
+func (s *Storage) GetActiveCredential(ctx context.Context, agentID, publicKey string) (*models.AgentCredential, error) {
+	query := `
+		SELECT id, agent_id, public_key, is_pinned, jwt_expires_at, renewal_count,
+			last_renewed_at, created_at, revoked_at
+		FROM agent_credentials
+		WHERE agent_id = $1 AND public_key = $2 AND revoked_at IS NULL
+	`
+
+	var cred models.AgentCredential
+	if err := s.db.GetContext(ctx, &cred, query, agentID, publicKey); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &cred, nil
+}
+
+// RecordCredentialRenewal stores the new JWT expiry and bumps the renewal counter.
+func (s *Storage) RecordCredentialRenewal(ctx context.Context, agentID, publicKey string, expiresAt time.Time) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE agent_credentials
+		SET jwt_expires_at = $3, renewal_count = renewal_count + 1, last_renewed_at = NOW()
+		WHERE agent_id = $1 AND public_key = $2 AND revoked_at IS NULL
+	`, agentID, publicKey, expiresAt)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// IsPublicKeyRevoked reports whether publicKey was ever revoked and is still
+// listed in credential_revocations.
+func (s *Storage) IsPublicKeyRevoked(ctx context.Context, publicKey string) (bool, error) {
+	var revoked bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM credential_revocations WHERE public_key = $1)
+	`, publicKey).Scan(&revoked)
+	return revoked, err
+}
+
+// ListActiveRevocations returns revoked public keys whose JWTs have not expired yet.
+func (s *Storage) ListActiveRevocations(ctx context.Context) ([]models.CredentialRevocation, error) {
+	query := `
+		SELECT public_key, agent_id, revoked_at, jwt_expires_at
+		FROM credential_revocations
+		WHERE jwt_expires_at > NOW()
+		ORDER BY revoked_at
+	`
+
+	revocations := make([]models.CredentialRevocation, 0)
+	if err := s.db.SelectContext(ctx, &revocations, query); err != nil {
+		return nil, err
+	}
+	return revocations, nil
+}
+
+// PruneExpiredRevocations removes revocations whose JWTs are already expired.
+func (s *Storage) PruneExpiredRevocations(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM credential_revocations WHERE jwt_expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"opspilot-backend/internal/models"
+)
+
+const driftPolicyColumns = `
+	org_id, enabled, new_ports, removed_services, kernel_change, new_containers,
+	ignored_ports, allowed_containers, silenced_tags, updated_by, updated_at
+`
+
+// GetDriftPolicy returns the org's drift policy, or the defaults if none is stored.
+func (s *Storage) GetDriftPolicy(ctx context.Context, orgID string) (*models.DriftPolicy, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+driftPolicyColumns+` FROM drift_policies WHERE org_id = $1`, orgID)
+	policy, err := scanDriftPolicy(row)
+	if err == sql.ErrNoRows {
+		return models.DefaultDriftPolicy(orgID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (s *Storage) UpsertDriftPolicy(ctx context.Context, orgID, userID string, input models.UpdateDriftPolicyInput) (*models.DriftPolicy, error) {
+	ignoredPorts := input.IgnoredPorts
+	if ignoredPorts == nil {
+		ignoredPorts = []int{}
+	}
+	portsJSON, err := json.Marshal(ignoredPorts)
+	if err != nil {
+		return nil, err
+	}
+	containersJSON, err := json.Marshal(nonNilStrings(input.AllowedContainers))
+	if err != nil {
+		return nil, err
+	}
+	tagsJSON, err := json.Marshal(nonNilStrings(input.SilencedTags))
+	if err != nil {
+		return nil, err
+	}
+
+	row := s.db.QueryRowContext(ctx, `
+		INSERT INTO drift_policies (
+			org_id, enabled, new_ports, removed_services, kernel_change, new_containers,
+			ignored_ports, allowed_containers, silenced_tags, updated_by, updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb, $8::jsonb, $9::jsonb, $10, now())
+		ON CONFLICT (org_id) DO UPDATE SET
+			enabled = EXCLUDED.enabled,
+			new_ports = EXCLUDED.new_ports,
+			removed_services = EXCLUDED.removed_services,
+			kernel_change = EXCLUDED.kernel_change,
+			new_containers = EXCLUDED.new_containers,
+			ignored_ports = EXCLUDED.ignored_ports,
+			allowed_containers = EXCLUDED.allowed_containers,
+			silenced_tags = EXCLUDED.silenced_tags,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = EXCLUDED.updated_at
+		RETURNING `+driftPolicyColumns,
+		orgID, input.Enabled, input.NewPorts, input.RemovedServices, input.KernelChange, input.NewContainers,
+		string(portsJSON), string(containersJSON), string(tagsJSON), nullIfEmpty(userID),
+	)
+	return scanDriftPolicy(row)
+}
+
+func scanDriftPolicy(row *sql.Row) (*models.DriftPolicy, error) {
+	var (
+		policy         models.DriftPolicy
+		portsJSON      []byte
+		containersJSON []byte
+		tagsJSON       []byte
+		updatedBy      sql.NullString
+		updatedAt      sql.NullTime
+	)
+	if err := row.Scan(
+		&policy.OrgID, &policy.Enabled, &policy.NewPorts, &policy.RemovedServices,
+		&policy.KernelChange, &policy.NewContainers, &portsJSON, &containersJSON,
+		&tagsJSON, &updatedBy, &updatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	policy.IgnoredPorts = []int{}
+	if len(portsJSON) > 0 {
+		if err := json.Unmarshal(portsJSON, &policy.IgnoredPorts); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if policy.AllowedContainers, err = decodeStringArray(containersJSON); err != nil {
+		return nil, err
+	}
+	if policy.SilencedTags, err = decodeStringArray(tagsJSON); err != nil {
+		return nil, err
+	}
+	if updatedBy.Valid {
+		policy.UpdatedBy = &updatedBy.String
+	}
+	if updatedAt.Valid {
+		policy.UpdatedAt = &updatedAt.Time
+	}
+	return &policy, nil
+}
+
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
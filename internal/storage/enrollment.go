@@ -7,24 +7,48 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
 	"opspilot-backend/internal/models"
 )
 
-func (s *Storage) EnrollAgent(ctx context.Context, orgID string, req models.EnrollAgentRequest, bootstrapTokenID string, remoteIP string) (*models.Agent, error) {
-	tags, err := s.getBootstrapTokenTags(ctx, bootstrapTokenID)
-	if err != nil {
-		return nil, err
+// EnrollAgentParams describes where an enrollment came from and the agent
+// status to store ("online" for instant admission, "pending" for approval).
+// Name and Tags come from the token policy; nil Tags means the token's tags.
+type EnrollAgentParams struct {
+	OrgID            string
+	BootstrapTokenID string
+	RemoteIP         string
+	Status           string
+	Name             string
+	Tags             []string
+}
+
+// EnrollAgent stores the enrolling agent. It returns ErrTokenAgentLimitReached
+// when the token's max_active_agents is reached (see lockTokenAgentSlot).
+func (s *Storage) EnrollAgent(ctx context.Context, req models.EnrollAgentRequest, params EnrollAgentParams) (*models.Agent, error) {
+	var err error
+	tags := params.Tags
+	if tags == nil {
+		if tags, err = s.getBootstrapTokenTags(ctx, params.BootstrapTokenID); err != nil {
+			return nil, err
+		}
+	}
+
+	status := params.Status
+	if status == "" {
+		status = "online"
 	}
 
 	now := time.Now().UTC()
-	enrolledVia := bootstrapTokenID
-	enrolledIP := remoteIP
+	enrolledVia := params.BootstrapTokenID
+	enrolledIP := params.RemoteIP
 	agent := &models.Agent{
 		ID:                  uuid.New().String(),
 		AgentID:             req.AgentID,
-		OrgID:               orgID,
+		OrgID:               params.OrgID,
+		Name:                params.Name,
 		Hostname:            req.Hostname,
-		Status:              "online",
+		Status:              status,
 		Tags:                tags,
 		HardwareFingerprint: req.HardwareFingerprint,
 		EnrolledVia:         &enrolledVia,
@@ -60,7 +84,7 @@ func (s *Storage) EnrollAgent(ctx context.Context, orgID string, req models.Enro
 			status = EXCLUDED.status,
 			last_seen_at = EXCLUDED.last_seen_at,
 			tags = COALESCE(EXCLUDED.tags, agents.tags),
-			hardware_fingerprint = COALESCE(NULLIF(EXCLUDED.hardware_fingerprint, ''), agents.hardware_fingerprint),
+			hardware_fingerprint = COALESCE(NULLIF(agents.hardware_fingerprint, ''), EXCLUDED.hardware_fingerprint),
 			enrolled_via = COALESCE(EXCLUDED.enrolled_via, agents.enrolled_via),
 			enrolled_at = COALESCE(EXCLUDED.enrolled_at, agents.enrolled_at),
 			enrolled_ip = COALESCE(EXCLUDED.enrolled_ip, agents.enrolled_ip),
@@ -74,7 +98,19 @@ func (s *Storage) EnrollAgent(ctx context.Context, orgID string, req models.Enro
 		}
 	}
 
-	_, err = s.db.ExecContext(ctx, query,
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if params.BootstrapTokenID != "" {
+		if err := lockTokenAgentSlot(ctx, tx, params.BootstrapTokenID, req.AgentID); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, query,
 		agent.ID,
 		agent.AgentID,
 		nullIfEmpty(agent.OrgID),
@@ -92,10 +128,231 @@ func (s *Storage) EnrollAgent(ctx context.Context, orgID string, req models.Enro
 	if err != nil {
 		return nil, err
 	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if s.cache != nil {
+		_ = s.cache.Del(agentCacheKey(agent.AgentID))
+	}
+	if err := s.RecordAgentVersion(ctx, agent.AgentID, req.AgentVersion, models.VersionSourceEnrollment); err != nil {
+		return nil, err
+	}
 
 	return agent, nil
 }
 
+const agentEnrollmentColumns = `
+	id, agent_id, org_id, bootstrap_token_id, public_key,
+	COALESCE(hostname, '') AS hostname,
+	COALESCE(hardware_fingerprint, '') AS hardware_fingerprint,
+	COALESCE(remote_ip::text, '') AS remote_ip,
+	COALESCE(os, '') AS os,
+	COALESCE(arch, '') AS arch,
+	COALESCE(agent_version, '') AS agent_version,
+	status, decided_by, decided_at, created_at
+`
+
+// CreatePendingEnrollment stores an enrollment request awaiting approval.
+func (s *Storage) CreatePendingEnrollment(ctx context.Context, enrollment models.AgentEnrollment) (*models.AgentEnrollment, error) {
+	query := `
+		INSERT INTO agent_enrollments (
+			agent_id, org_id, bootstrap_token_id, public_key, hostname,
+			hardware_fingerprint, remote_ip, os, arch, agent_version, status
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 'pending')
+		RETURNING ` + agentEnrollmentColumns
+
+	var created models.AgentEnrollment
+	err := s.db.GetContext(ctx, &created, query,
+		enrollment.AgentID,
+		enrollment.OrgID,
+		nullIfEmpty(ptrValue(enrollment.BootstrapTokenID)),
+		enrollment.PublicKey,
+		nullIfEmpty(enrollment.Hostname),
+		nullIfEmpty(enrollment.HardwareFingerprint),
+		nullIfEmpty(enrollment.RemoteIP),
+		nullIfEmpty(enrollment.OS),
+		nullIfEmpty(enrollment.Arch),
+		nullIfEmpty(enrollment.AgentVersion),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (s *Storage) GetEnrollment(ctx context.Context, id string) (*models.AgentEnrollment, error) {
+	query := `SELECT ` + agentEnrollmentColumns + ` FROM agent_enrollments WHERE id = $1`
+
+	var enrollment models.AgentEnrollment
+	if err := s.db.GetContext(ctx, &enrollment, query, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &enrollment, nil
+}
+
+// GetLatestEnrollment returns the most recent enrollment request for an agent.
+func (s *Storage) GetLatestEnrollment(ctx context.Context, agentID string) (*models.AgentEnrollment, error) {
+	query := `SELECT ` + agentEnrollmentColumns + `
+		FROM agent_enrollments
+		WHERE agent_id = $1
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+
+	var enrollment models.AgentEnrollment
+	if err := s.db.GetContext(ctx, &enrollment, query, agentID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &enrollment, nil
+}
+
+func (s *Storage) ListEnrollments(ctx context.Context, orgID, status string) ([]models.AgentEnrollment, error) {
+	query := `SELECT ` + agentEnrollmentColumns + `
+		FROM agent_enrollments
+		WHERE org_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC
+		LIMIT 500
+	`
+
+	enrollments := make([]models.AgentEnrollment, 0)
+	if err := s.db.SelectContext(ctx, &enrollments, query, orgID, status); err != nil {
+		return nil, err
+	}
+	return enrollments, nil
+}
+
+// DecideEnrollment moves a pending enrollment to approved or rejected and
+// updates the agent status accordingly ("offline" until it connects, or "rejected").
+// Approval re-checks the token's max_active_agents under the token lock and
+// returns ErrTokenAgentLimitReached if the token is already at its limit.
+func (s *Storage) DecideEnrollment(ctx context.Context, id, status, decidedBy string) error {
+	agentStatus := "offline"
+	if status == "rejected" {
+		agentStatus = "rejected"
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if status == "approved" {
+		var agentID string
+		var tokenID sql.NullString
+		err := tx.QueryRowContext(ctx, `
+			SELECT agent_id, bootstrap_token_id::text FROM agent_enrollments
+			WHERE id = $1 AND status = 'pending'
+		`, id).Scan(&agentID, &tokenID)
+		if err != nil {
+			return err
+		}
+		if tokenID.Valid {
+			if err := lockTokenAgentSlot(ctx, tx, tokenID.String, agentID); err != nil {
+				return err
+			}
+		}
+	}
+
+	var agentID string
+	err = tx.QueryRowContext(ctx, `
+		UPDATE agent_enrollments
+		SET status = $2, decided_by = $3, decided_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+		RETURNING agent_id
+	`, id, status, nullIfEmpty(decidedBy)).Scan(&agentID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE agents SET status = $2 WHERE agent_id = $1`, agentID, agentStatus); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if s.cache != nil {
+		_ = s.cache.Del(agentCacheKey(agentID))
+	}
+	return nil
+}
+
+// lockTokenAgentSlot locks the bootstrap token row for the rest of tx and
+// returns ErrTokenAgentLimitReached when the token already has
+// max_active_agents agents other than agentID, so concurrent enrollments and
+// approvals cannot exceed the limit. Every agent enrolled via the token counts
+// unless it was rejected: pending, approved and offline ones hold their slot.
+func lockTokenAgentSlot(ctx context.Context, tx *sqlx.Tx, tokenID, agentID string) error {
+	var limit sql.NullInt64
+	err := tx.QueryRowContext(ctx, `SELECT max_active_agents FROM bootstrap_tokens WHERE id = $1 FOR UPDATE`, tokenID).Scan(&limit)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil || !limit.Valid {
+		return err
+	}
+
+	var active int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM agents
+		WHERE enrolled_via = $1 AND status <> 'rejected' AND agent_id <> $2
+	`, tokenID, agentID).Scan(&active)
+	if err != nil {
+		return err
+	}
+	if active >= limit.Int64 {
+		return ErrTokenAgentLimitReached
+	}
+	return nil
+}
+
+// IssueEnrollmentCredentials claims an approved enrollment and stores the
+// pinned credential for its public key in the same transaction. It returns false
+// when the enrollment was no longer approved, i.e. another poll already claimed it.
+func (s *Storage) IssueEnrollmentCredentials(ctx context.Context, enrollment *models.AgentEnrollment, expiresAt time.Time) (bool, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRowContext(ctx, `
+		UPDATE agent_enrollments SET status = 'issued'
+		WHERE id = $1 AND status = 'approved'
+		RETURNING id
+	`, enrollment.ID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO agent_credentials (
+			agent_id, public_key, is_pinned, fingerprint_at_registration, registered_from_ip, registered_hostname, jwt_expires_at
+		)
+		VALUES ($1, $2, true, $3, $4, $5, $6)
+	`, enrollment.AgentID, enrollment.PublicKey, nullIfEmpty(enrollment.HardwareFingerprint),
+		nullIfEmpty(enrollment.RemoteIP), nullIfEmpty(enrollment.Hostname), expiresAt); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (s *Storage) GetPinnedPublicKey(ctx context.Context, agentID string) (string, error) {
 	query := `
 		SELECT public_key
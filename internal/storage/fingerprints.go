@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"opspilot-backend/internal/models"
+)
+
+// ObserveFingerprint records fingerprint in the agent's history and reports
+// whether it was seen for the first time.
+func (s *Storage) ObserveFingerprint(ctx context.Context, agentID, fingerprint, source, remoteIP string) (bool, error) {
+	var inserted bool
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO agent_fingerprints (agent_id, fingerprint, source, remote_ip)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (agent_id, fingerprint) DO UPDATE SET
+			seen_count = agent_fingerprints.seen_count + 1,
+			last_seen_at = NOW(),
+			remote_ip = COALESCE(EXCLUDED.remote_ip, agent_fingerprints.remote_ip)
+		RETURNING (xmax = 0)
+	`, agentID, fingerprint, source, nullIfEmpty(remoteIP)).Scan(&inserted)
+	return inserted, err
+}
+
+// ListFingerprints returns the fingerprint history of an agent, newest first.
+func (s *Storage) ListFingerprints(ctx context.Context, agentID string) ([]models.AgentFingerprint, error) {
+	query := `
+		SELECT f.id, f.agent_id, f.fingerprint, f.source, COALESCE(host(f.remote_ip), '') AS remote_ip,
+			f.seen_count, f.first_seen_at, f.last_seen_at,
+			COALESCE(a.hardware_fingerprint = f.fingerprint, false) AS pinned
+		FROM agent_fingerprints f
+		JOIN agents a ON a.agent_id = f.agent_id
+		WHERE f.agent_id = $1
+		ORDER BY f.last_seen_at DESC
+	`
+
+	fingerprints := make([]models.AgentFingerprint, 0)
+	if err := s.db.SelectContext(ctx, &fingerprints, query, agentID); err != nil {
+		return nil, err
+	}
+	return fingerprints, nil
+}
+
+// PinFingerprint makes fingerprint the agent's trusted hardware fingerprint.
+// It must already be present in the agent's history.
+func (s *Storage) PinFingerprint(ctx context.Context, agentID, fingerprint string) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE agents SET hardware_fingerprint = $2
+		WHERE agent_id = $1
+		  AND EXISTS (SELECT 1 FROM agent_fingerprints WHERE agent_id = $1 AND fingerprint = $2)
+	`, agentID, fingerprint)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	if s.cache != nil {
+		_ = s.cache.Del(agentCacheKey(agentID))
+		_ = s.cache.Del(FingerprintCacheKey(agentID))
+	}
+	return nil
+}
+
+// FingerprintCacheKey caches the last heartbeat fingerprint checked against
+// the pinned one; re-pinning drops it so the next heartbeat is checked again.
+func FingerprintCacheKey(agentID string) string {
+	return "ops:agent:fingerprint:" + agentID
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"opspilot-backend/internal/models"
+)
+
+var ErrInvalidFacet = errors.New("invalid facet field")
+
+// fleetFacetKeys maps facet names to models.Inventory payload keys.
+var fleetFacetKeys = map[string]string{
+	"platform":         "Platform",
+	"platform_version": "PlatformVersion",
+	"kernel_version":   "KernelVersion",
+	"cpu_model":        "CPUModel",
+}
+
+// inventoryCandidates is the payload's candidates as a JSONB array; agents
+// may send null.
+const inventoryCandidates = `CASE WHEN jsonb_typeof(l.payload->'Candidates') = 'array' THEN l.payload->'Candidates' ELSE '[]'::jsonb END`
+
+// QueryFleet returns the org's agents whose latest inventory matches q.
+func (s *Storage) QueryFleet(ctx context.Context, orgID string, q models.FleetQuery) ([]models.FleetHost, error) {
+	where, candidateCond, args := fleetFilter(orgID, q)
+	if candidateCond == "" {
+		candidateCond = "TRUE"
+	}
+	args = append(args, q.Limit)
+
+	query := fmt.Sprintf(`
+		SELECT a.agent_id, COALESCE(a.name, ''), COALESCE(a.hostname, ''), COALESCE(a.status, ''), l.ts,
+			COALESCE(l.payload->>'Platform', ''), COALESCE(l.payload->>'PlatformVersion', ''),
+			COALESCE(l.payload->>'KernelVersion', ''), COALESCE(l.payload->>'CPUModel', ''),
+			COALESCE((l.payload->>'RAMTotal')::bigint, 0),
+			COALESCE((SELECT jsonb_agg(c) FROM jsonb_array_elements(%s) c WHERE %s), '[]'::jsonb)
+		FROM agents_inventory_latest l
+		JOIN agents a ON a.agent_id = l.agent_id
+		WHERE %s
+		ORDER BY a.agent_id
+		LIMIT $%d
+	`, inventoryCandidates, candidateCond, where, len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hosts := make([]models.FleetHost, 0)
+	for rows.Next() {
+		var host models.FleetHost
+		var candidatesJSON []byte
+		if err := rows.Scan(
+			&host.AgentID, &host.Name, &host.Hostname, &host.Status, &host.InventoryAt,
+			&host.Platform, &host.PlatformVersion, &host.KernelVersion, &host.CPUModel,
+			&host.RAMTotal, &candidatesJSON,
+		); err != nil {
+			return nil, err
+		}
+
+		var candidates []models.ProcessCandidate
+		if err := json.Unmarshal(candidatesJSON, &candidates); err != nil {
+			return nil, err
+		}
+		host.Candidates = make([]models.FleetCandidate, 0, len(candidates))
+		for _, c := range candidates {
+			host.Candidates = append(host.Candidates, models.FleetCandidate{
+				Name:          c.Name,
+				Type:          c.Type,
+				Cmdline:       c.Cmdline,
+				PID:           c.PID,
+				ListenPorts:   c.ListenPorts,
+				SourceSystemd: c.SourceSystemd,
+				SourceDocker:  c.SourceDocker,
+			})
+		}
+		hosts = append(hosts, host)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return hosts, nil
+}
+
+// FleetFacets counts the org's matching agents by an inventory field, most
+// common value first.
+func (s *Storage) FleetFacets(ctx context.Context, orgID, field string, q models.FleetQuery) ([]models.FacetCount, error) {
+	key, ok := fleetFacetKeys[field]
+	if !ok {
+		return nil, ErrInvalidFacet
+	}
+
+	where, _, args := fleetFilter(orgID, q)
+	query := fmt.Sprintf(`
+		SELECT COALESCE(l.payload->>'%s', '') AS value, COUNT(*) AS count
+		FROM agents_inventory_latest l
+		JOIN agents a ON a.agent_id = l.agent_id
+		WHERE %s
+		GROUP BY 1
+		ORDER BY count DESC, value
+	`, key, where)
+
+	facets := make([]models.FacetCount, 0)
+	if err := s.db.SelectContext(ctx, &facets, query, args...); err != nil {
+		return nil, err
+	}
+	return facets, nil
+}
+
+// fleetFilter builds the host WHERE clause for q and the per-candidate
+// condition (alias c) used to pick matching candidates.
+func fleetFilter(orgID string, q models.FleetQuery) (string, string, []any) {
+	args := []any{orgID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	hostConds := []string{"a.org_id = $1"}
+	var candidateConds []string
+
+	if q.Port > 0 {
+		p := arg(q.Port)
+		// The containment check can use the GIN index; the per-candidate
+		// condition below keeps the port and the other filters together.
+		hostConds = append(hostConds, fmt.Sprintf(
+			"l.payload->'Candidates' @> jsonb_build_array(jsonb_build_object('ListenPorts', jsonb_build_array(%s::int)))", p))
+		candidateConds = append(candidateConds, fmt.Sprintf("c->'ListenPorts' @> to_jsonb(%s::int)", p))
+	}
+	if q.Process != "" {
+		p := arg("%" + escapeLike(q.Process) + "%")
+		candidateConds = append(candidateConds, fmt.Sprintf(
+			"(c->>'Name' ILIKE %[1]s OR c->>'SourceDocker' ILIKE %[1]s OR c->>'SourceSystemd' ILIKE %[1]s)", p))
+	}
+	switch q.Source {
+	case models.CandidateSourceDocker:
+		candidateConds = append(candidateConds, "COALESCE(c->>'SourceDocker', '') <> ''")
+	case models.CandidateSourceSystemd:
+		candidateConds = append(candidateConds, "COALESCE(c->>'SourceSystemd', '') <> ''")
+	}
+	if q.Platform != "" {
+		hostConds = append(hostConds, "l.payload->>'Platform' = "+arg(q.Platform))
+	}
+	if q.PlatformVersion != "" {
+		hostConds = append(hostConds, "l.payload->>'PlatformVersion' = "+arg(q.PlatformVersion))
+	}
+	if q.KernelVersion != "" {
+		hostConds = append(hostConds, "l.payload->>'KernelVersion' = "+arg(q.KernelVersion))
+	}
+	if q.MinRAM > 0 {
+		hostConds = append(hostConds, "(l.payload->>'RAMTotal')::bigint >= "+arg(q.MinRAM))
+	}
+	if q.MaxRAM > 0 {
+		hostConds = append(hostConds, "(l.payload->>'RAMTotal')::bigint < "+arg(q.MaxRAM))
+	}
+
+	candidateCond := strings.Join(candidateConds, " AND ")
+	if candidateCond != "" {
+		hostConds = append(hostConds, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM jsonb_array_elements(%s) c WHERE %s)", inventoryCandidates, candidateCond))
+	}
+	return strings.Join(hostConds, " AND "), candidateCond, args
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
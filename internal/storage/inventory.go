@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"opspilot-backend/internal/models"
+)
+
+// latestInventoryHistoryID finds the history row of the agent's latest
+// snapshot (l); it is gone once retention purged it.
+const latestInventoryHistoryID = `
+	SELECT h.id FROM agents_inventory h
+	WHERE h.agent_id = l.agent_id AND h.ts = l.ts AND h.hash = l.hash
+	ORDER BY h.id DESC
+	LIMIT 1
+`
+
+// ListInventorySnapshots returns the agent's stored snapshots without
+// payloads, newest first. The latest snapshot is always listed, with id 0 if
+// retention already purged its history row.
+func (s *Storage) ListInventorySnapshots(ctx context.Context, agentID string, limit int) ([]models.InventorySnapshot, error) {
+	query := `
+		SELECT id, agent_id, ts, hash
+		FROM agents_inventory
+		WHERE agent_id = $1
+		UNION ALL
+		SELECT 0, l.agent_id, l.ts, l.hash
+		FROM agents_inventory_latest l
+		WHERE l.agent_id = $1 AND NOT EXISTS (` + latestInventoryHistoryID + `)
+		ORDER BY ts DESC, id DESC
+		LIMIT $2
+	`
+
+	snapshots := make([]models.InventorySnapshot, 0)
+	if err := s.db.SelectContext(ctx, &snapshots, query, agentID, limit); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// GetInventorySnapshot returns one snapshot with its payload, or nil. Id 0
+// is the latest snapshot, as listed once its history row was purged.
+func (s *Storage) GetInventorySnapshot(ctx context.Context, agentID string, id int64) (*models.InventorySnapshot, error) {
+	if id == 0 {
+		return s.GetLatestInventorySnapshot(ctx, agentID)
+	}
+	return s.getInventorySnapshot(ctx, `
+		SELECT id, agent_id, ts, hash, payload
+		FROM agents_inventory
+		WHERE agent_id = $1 AND id = $2
+	`, agentID, id)
+}
+
+// GetLatestInventorySnapshot returns the agent's latest snapshot from
+// agents_inventory_latest, the source the rest of the API reads, or nil. Its
+// id is 0 if retention already purged the history row.
+func (s *Storage) GetLatestInventorySnapshot(ctx context.Context, agentID string) (*models.InventorySnapshot, error) {
+	return s.getInventorySnapshot(ctx, `
+		SELECT COALESCE((`+latestInventoryHistoryID+`), 0) AS id, l.agent_id, l.ts, l.hash, l.payload
+		FROM agents_inventory_latest l
+		WHERE l.agent_id = $1
+	`, agentID)
+}
+
+// GetPreviousInventorySnapshot returns the snapshot stored just before
+// snapshot, or nil if it is the first one.
+func (s *Storage) GetPreviousInventorySnapshot(ctx context.Context, snapshot *models.InventorySnapshot) (*models.InventorySnapshot, error) {
+	return s.getInventorySnapshot(ctx, `
+		SELECT id, agent_id, ts, hash, payload
+		FROM agents_inventory
+		WHERE agent_id = $1 AND (ts, id) < ($2, $3)
+		ORDER BY ts DESC, id DESC
+		LIMIT 1
+	`, snapshot.AgentID, snapshot.TS, snapshot.ID)
+}
+
+func (s *Storage) getInventorySnapshot(ctx context.Context, query string, args ...any) (*models.InventorySnapshot, error) {
+	var snapshot models.InventorySnapshot
+	if err := s.db.GetContext(ctx, &snapshot, query, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &snapshot, nil
+}
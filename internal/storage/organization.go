@@ -21,6 +21,7 @@ var (
 	ErrTokenExpired           = errors.New("bootstrap token expired")
 	ErrTokenUsageLimitReached = errors.New("bootstrap token usage limit reached")
 	ErrTokenIPNotAllowed      = errors.New("bootstrap token ip not allowed")
+	ErrTokenAgentLimitReached = errors.New("bootstrap token active agent limit reached")
 	ErrOrgNotFound            = errors.New("organization not found")
 	ErrSlugTaken              = errors.New("organization slug already taken")
 )
@@ -31,6 +32,13 @@ const (
 	tokenPrefixLength = 12
 )
 
+const bootstrapTokenColumns = `
+	id, org_id, token_prefix, token_hash, description, tags, allowed_cidrs,
+	expires_at, max_uses, use_count, require_approval, name_template, tag_rules,
+	max_active_agents, allowed_os, allowed_arch, created_by, created_at,
+	last_used_at, revoked_at
+`
+
 type bootstrapTokenRow struct {
 	ID               string
 	OrgID            string
@@ -42,6 +50,12 @@ type bootstrapTokenRow struct {
 	ExpiresAt        *time.Time
 	MaxUses          sql.NullInt64
 	UseCount         int
+	RequireApproval  bool
+	NameTemplate     sql.NullString
+	TagRulesJSON     []byte
+	MaxActiveAgents  sql.NullInt64
+	AllowedOSJSON    []byte
+	AllowedArchJSON  []byte
 	CreatedBy        sql.NullString
 	CreatedAt        time.Time
 	LastUsedAt       *time.Time
@@ -52,12 +66,12 @@ func (s *Storage) CreateOrganization(ctx context.Context, input models.CreateOrg
 	query := `
 		INSERT INTO organizations (name, slug)
 		VALUES ($1, $2)
-		RETURNING id, name, slug, created_at
+		RETURNING id, name, slug, allow_server_keygen, COALESCE(min_agent_version, ''), created_at
 	`
 
 	var org models.Organization
 	err := s.db.QueryRowContext(ctx, query, input.Name, input.Slug).
-		Scan(&org.ID, &org.Name, &org.Slug, &org.CreatedAt)
+		Scan(&org.ID, &org.Name, &org.Slug, &org.AllowServerKeygen, &org.MinAgentVersion, &org.CreatedAt)
 	if err != nil {
 		if isUniqueViolation(err) {
 			return nil, ErrSlugTaken
@@ -70,14 +84,14 @@ func (s *Storage) CreateOrganization(ctx context.Context, input models.CreateOrg
 
 func (s *Storage) GetOrganization(ctx context.Context, id string) (*models.Organization, error) {
 	query := `
-		SELECT id, name, slug, created_at
+		SELECT id, name, slug, allow_server_keygen, COALESCE(min_agent_version, ''), created_at
 		FROM organizations
 		WHERE id = $1
 	`
 
 	var org models.Organization
 	err := s.db.QueryRowContext(ctx, query, id).
-		Scan(&org.ID, &org.Name, &org.Slug, &org.CreatedAt)
+		Scan(&org.ID, &org.Name, &org.Slug, &org.AllowServerKeygen, &org.MinAgentVersion, &org.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, ErrOrgNotFound
 	}
@@ -90,14 +104,36 @@ func (s *Storage) GetOrganization(ctx context.Context, id string) (*models.Organ
 
 func (s *Storage) GetOrganizationBySlug(ctx context.Context, slug string) (*models.Organization, error) {
 	query := `
-		SELECT id, name, slug, created_at
+		SELECT id, name, slug, allow_server_keygen, COALESCE(min_agent_version, ''), created_at
 		FROM organizations
 		WHERE slug = $1
 	`
 
 	var org models.Organization
 	err := s.db.QueryRowContext(ctx, query, slug).
-		Scan(&org.ID, &org.Name, &org.Slug, &org.CreatedAt)
+		Scan(&org.ID, &org.Name, &org.Slug, &org.AllowServerKeygen, &org.MinAgentVersion, &org.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrOrgNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+func (s *Storage) UpdateOrganization(ctx context.Context, id string, input models.UpdateOrganizationInput) (*models.Organization, error) {
+	query := `
+		UPDATE organizations
+		SET allow_server_keygen = COALESCE($2, allow_server_keygen),
+			min_agent_version = CASE WHEN $3::text IS NULL THEN min_agent_version ELSE NULLIF($3, '') END
+		WHERE id = $1
+		RETURNING id, name, slug, allow_server_keygen, COALESCE(min_agent_version, ''), created_at
+	`
+
+	var org models.Organization
+	err := s.db.QueryRowContext(ctx, query, id, input.AllowServerKeygen, input.MinAgentVersion).
+		Scan(&org.ID, &org.Name, &org.Slug, &org.AllowServerKeygen, &org.MinAgentVersion, &org.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, ErrOrgNotFound
 	}
@@ -137,18 +173,36 @@ func (s *Storage) CreateBootstrapToken(ctx context.Context, orgID, userID string
 		allowedCIDRsJSON = &value
 	}
 
+	tagRulesJSON := "[]"
+	if len(input.TagRules) > 0 {
+		data, err := json.Marshal(input.TagRules)
+		if err != nil {
+			return nil, err
+		}
+		tagRulesJSON = string(data)
+	}
+
+	allowedOSJSON, err := optionalStringArrayJSON(input.AllowedOS)
+	if err != nil {
+		return nil, err
+	}
+	allowedArchJSON, err := optionalStringArrayJSON(input.AllowedArch)
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
 		INSERT INTO bootstrap_tokens (
 			org_id, token_hash, token_prefix, description, tags, allowed_cidrs,
-			expires_at, max_uses, use_count, created_by, created_at, last_used_at, revoked_at
+			expires_at, max_uses, use_count, require_approval, name_template, tag_rules,
+			max_active_agents, allowed_os, allowed_arch, created_by, created_at,
+			last_used_at, revoked_at
 		)
-		VALUES ($1, $2, $3, $4, $5::jsonb, $6::jsonb, $7, $8, 0, $9, NOW(), NULL, NULL)
-		RETURNING id, org_id, token_prefix, description, tags, allowed_cidrs, expires_at,
-			max_uses, use_count, created_by, created_at, last_used_at, revoked_at
-	`
+		VALUES ($1, $2, $3, $4, $5::jsonb, $6::jsonb, $7, $8, 0, $9, $10, $11::jsonb,
+			$12, $13::jsonb, $14::jsonb, $15, NOW(), NULL, NULL)
+		RETURNING ` + bootstrapTokenColumns
 
-	row := bootstrapTokenRow{}
-	err = s.db.QueryRowContext(ctx, query,
+	row, err := scanBootstrapTokenRow(s.db.QueryRowContext(ctx, query,
 		orgID,
 		hash,
 		prefix,
@@ -157,22 +211,14 @@ func (s *Storage) CreateBootstrapToken(ctx context.Context, orgID, userID string
 		allowedCIDRsJSON,
 		input.ExpiresAt,
 		input.MaxUses,
+		input.RequireApproval,
+		nullIfEmpty(input.NameTemplate),
+		tagRulesJSON,
+		input.MaxActiveAgents,
+		allowedOSJSON,
+		allowedArchJSON,
 		nullIfEmpty(userID),
-	).Scan(
-		&row.ID,
-		&row.OrgID,
-		&row.TokenPrefix,
-		&row.Description,
-		&row.TagsJSON,
-		&row.AllowedCIDRsJSON,
-		&row.ExpiresAt,
-		&row.MaxUses,
-		&row.UseCount,
-		&row.CreatedBy,
-		&row.CreatedAt,
-		&row.LastUsedAt,
-		&row.RevokedAt,
-	)
+	))
 	if err != nil {
 		return nil, err
 	}
@@ -189,9 +235,7 @@ func (s *Storage) CreateBootstrapToken(ctx context.Context, orgID, userID string
 }
 
 func (s *Storage) GetBootstrapTokens(ctx context.Context, orgID string) ([]models.BootstrapToken, error) {
-	query := `
-		SELECT id, org_id, token_prefix, token_hash, description, tags, allowed_cidrs,
-			expires_at, max_uses, use_count, created_by, created_at, last_used_at, revoked_at
+	query := `SELECT ` + bootstrapTokenColumns + `
 		FROM bootstrap_tokens
 		WHERE org_id = $1
 		ORDER BY created_at DESC
@@ -205,23 +249,8 @@ func (s *Storage) GetBootstrapTokens(ctx context.Context, orgID string) ([]model
 
 	result := make([]models.BootstrapToken, 0)
 	for rows.Next() {
-		var row bootstrapTokenRow
-		if err := rows.Scan(
-			&row.ID,
-			&row.OrgID,
-			&row.TokenPrefix,
-			&row.TokenHash,
-			&row.Description,
-			&row.TagsJSON,
-			&row.AllowedCIDRsJSON,
-			&row.ExpiresAt,
-			&row.MaxUses,
-			&row.UseCount,
-			&row.CreatedBy,
-			&row.CreatedAt,
-			&row.LastUsedAt,
-			&row.RevokedAt,
-		); err != nil {
+		row, err := scanBootstrapTokenRow(rows)
+		if err != nil {
 			return nil, err
 		}
 
@@ -239,30 +268,13 @@ func (s *Storage) GetBootstrapTokens(ctx context.Context, orgID string) ([]model
 }
 
 func (s *Storage) GetBootstrapToken(ctx context.Context, tokenID string) (*models.BootstrapToken, error) {
-	query := `
-		SELECT id, org_id, token_prefix, token_hash, description, tags, allowed_cidrs,
-			expires_at, max_uses, use_count, created_by, created_at, last_used_at, revoked_at
+	query := `SELECT ` + bootstrapTokenColumns + `
 		FROM bootstrap_tokens
 		WHERE id = $1
 	`
 
-	var row bootstrapTokenRow
-	if err := s.db.QueryRowContext(ctx, query, tokenID).Scan(
-		&row.ID,
-		&row.OrgID,
-		&row.TokenPrefix,
-		&row.TokenHash,
-		&row.Description,
-		&row.TagsJSON,
-		&row.AllowedCIDRsJSON,
-		&row.ExpiresAt,
-		&row.MaxUses,
-		&row.UseCount,
-		&row.CreatedBy,
-		&row.CreatedAt,
-		&row.LastUsedAt,
-		&row.RevokedAt,
-	); err != nil {
+	row, err := scanBootstrapTokenRow(s.db.QueryRowContext(ctx, query, tokenID))
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -282,9 +294,7 @@ func (s *Storage) ValidateBootstrapToken(ctx context.Context, token string, remo
 	}
 
 	prefix := token[:tokenPrefixLength]
-	query := `
-		SELECT id, org_id, token_prefix, token_hash, description, tags, allowed_cidrs,
-			expires_at, max_uses, use_count, created_by, created_at, last_used_at, revoked_at
+	query := `SELECT ` + bootstrapTokenColumns + `
 		FROM bootstrap_tokens
 		WHERE token_prefix = $1
 	`
@@ -296,23 +306,8 @@ func (s *Storage) ValidateBootstrapToken(ctx context.Context, token string, remo
 	defer rows.Close()
 
 	for rows.Next() {
-		var row bootstrapTokenRow
-		if err := rows.Scan(
-			&row.ID,
-			&row.OrgID,
-			&row.TokenPrefix,
-			&row.TokenHash,
-			&row.Description,
-			&row.TagsJSON,
-			&row.AllowedCIDRsJSON,
-			&row.ExpiresAt,
-			&row.MaxUses,
-			&row.UseCount,
-			&row.CreatedBy,
-			&row.CreatedAt,
-			&row.LastUsedAt,
-			&row.RevokedAt,
-		); err != nil {
+		row, err := scanBootstrapTokenRow(rows)
+		if err != nil {
 			return nil, err
 		}
 
@@ -351,6 +346,19 @@ func (s *Storage) ValidateBootstrapToken(ctx context.Context, token string, remo
 	return nil, ErrTokenNotFound
 }
 
+// BootstrapTokenMatches reports whether token is the secret of bootstrap token tokenID.
+func (s *Storage) BootstrapTokenMatches(ctx context.Context, tokenID, token string) (bool, error) {
+	var hash string
+	err := s.db.QueryRowContext(ctx, `SELECT token_hash FROM bootstrap_tokens WHERE id = $1`, tokenID).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return ValidateTokenHash(token, hash), nil
+}
+
 func (s *Storage) IncrementBootstrapTokenUsage(ctx context.Context, tokenID string) error {
 	_, err := s.db.ExecContext(ctx, `
 		UPDATE bootstrap_tokens
@@ -390,6 +398,33 @@ func ValidateTokenHash(token, hash string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(token)) == nil
 }
 
+func scanBootstrapTokenRow(scanner rowScanner) (bootstrapTokenRow, error) {
+	var row bootstrapTokenRow
+	err := scanner.Scan(
+		&row.ID,
+		&row.OrgID,
+		&row.TokenPrefix,
+		&row.TokenHash,
+		&row.Description,
+		&row.TagsJSON,
+		&row.AllowedCIDRsJSON,
+		&row.ExpiresAt,
+		&row.MaxUses,
+		&row.UseCount,
+		&row.RequireApproval,
+		&row.NameTemplate,
+		&row.TagRulesJSON,
+		&row.MaxActiveAgents,
+		&row.AllowedOSJSON,
+		&row.AllowedArchJSON,
+		&row.CreatedBy,
+		&row.CreatedAt,
+		&row.LastUsedAt,
+		&row.RevokedAt,
+	)
+	return row, err
+}
+
 func mapBootstrapTokenRow(row bootstrapTokenRow) (models.BootstrapToken, error) {
 	tags, err := decodeStringArray(row.TagsJSON)
 	if err != nil {
@@ -401,26 +436,55 @@ func mapBootstrapTokenRow(row bootstrapTokenRow) (models.BootstrapToken, error)
 		return models.BootstrapToken{}, err
 	}
 
+	tagRules := make([]models.TagRule, 0)
+	if len(row.TagRulesJSON) > 0 {
+		if err := json.Unmarshal(row.TagRulesJSON, &tagRules); err != nil {
+			return models.BootstrapToken{}, err
+		}
+	}
+
+	allowedOS, err := decodeStringArray(row.AllowedOSJSON)
+	if err != nil {
+		return models.BootstrapToken{}, err
+	}
+
+	allowedArch, err := decodeStringArray(row.AllowedArchJSON)
+	if err != nil {
+		return models.BootstrapToken{}, err
+	}
+
 	var maxUses *int
 	if row.MaxUses.Valid {
 		value := int(row.MaxUses.Int64)
 		maxUses = &value
 	}
 
+	var maxActiveAgents *int
+	if row.MaxActiveAgents.Valid {
+		value := int(row.MaxActiveAgents.Int64)
+		maxActiveAgents = &value
+	}
+
 	bt := models.BootstrapToken{
-		ID:           row.ID,
-		OrgID:        row.OrgID,
-		TokenPrefix:  row.TokenPrefix,
-		Description:  row.Description.String,
-		Tags:         tags,
-		AllowedCIDRs: allowedCIDRs,
-		ExpiresAt:    row.ExpiresAt,
-		MaxUses:      maxUses,
-		UseCount:     row.UseCount,
-		CreatedBy:    row.CreatedBy.String,
-		CreatedAt:    row.CreatedAt,
-		LastUsedAt:   row.LastUsedAt,
-		RevokedAt:    row.RevokedAt,
+		ID:              row.ID,
+		OrgID:           row.OrgID,
+		TokenPrefix:     row.TokenPrefix,
+		Description:     row.Description.String,
+		Tags:            tags,
+		AllowedCIDRs:    allowedCIDRs,
+		ExpiresAt:       row.ExpiresAt,
+		MaxUses:         maxUses,
+		UseCount:        row.UseCount,
+		RequireApproval: row.RequireApproval,
+		NameTemplate:    row.NameTemplate.String,
+		TagRules:        tagRules,
+		MaxActiveAgents: maxActiveAgents,
+		AllowedOS:       allowedOS,
+		AllowedArch:     allowedArch,
+		CreatedBy:       row.CreatedBy.String,
+		CreatedAt:       row.CreatedAt,
+		LastUsedAt:      row.LastUsedAt,
+		RevokedAt:       row.RevokedAt,
 	}
 
 	return bt, nil
@@ -472,3 +536,27 @@ func isUniqueViolation(err error) bool {
 	}
 	return false
 }
+
+// IsDataError reports whether err is a Postgres data exception (class 22,
+// e.g. invalid UTF-8) or integrity violation (class 23): the row itself is
+// bad and retrying it cannot succeed.
+func IsDataError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		class := pqErr.Code.Class()
+		return class == "22" || class == "23"
+	}
+	return false
+}
+
+func optionalStringArrayJSON(values []string) (*string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	value := string(data)
+	return &value, nil
+}
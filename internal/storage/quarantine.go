@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"opspilot-backend/internal/models"
+)
+
+// EnrolledAgentOrgs returns the org of each given agent that is enrolled in
+// one. Unknown agents, agents without an org and rejected agents are missing
+// from the map, so their events are quarantined. Pending agents are enrolled:
+// agents awaiting approval hold no credentials yet, and agents created with
+// POST /agents stay pending until they first connect.
+func (s *Storage) EnrolledAgentOrgs(ctx context.Context, agentIDs []string) (map[string]string, error) {
+	orgs := make(map[string]string, len(agentIDs))
+	if len(agentIDs) == 0 {
+		return orgs, nil
+	}
+
+	placeholders := make([]string, len(agentIDs))
+	args := make([]any, len(agentIDs))
+	for i, id := range agentIDs {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = id
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT agent_id, org_id::text
+		FROM agents
+		WHERE agent_id IN (`+strings.Join(placeholders, ", ")+`) AND org_id IS NOT NULL AND status <> 'rejected'
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var agentID, orgID string
+		if err := rows.Scan(&agentID, &orgID); err != nil {
+			return nil, err
+		}
+		orgs[agentID] = orgID
+	}
+	return orgs, rows.Err()
+}
+
+// ListQuarantinedEvents returns quarantined events of the org's agents, newest
+// first.
+func (s *Storage) ListQuarantinedEvents(ctx context.Context, orgID, agentID string, limit int) ([]models.QuarantinedEvent, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT q.id, q.agent_id, q.subject, q.alert_type, q.message, q.payload, q.received_at
+		FROM quarantined_events q
+		JOIN agents a ON a.agent_id = q.agent_id
+		WHERE a.org_id = $1 AND ($2 = '' OR q.agent_id = $2)
+		ORDER BY q.received_at DESC, q.id DESC
+		LIMIT $3
+	`, orgID, agentID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]models.QuarantinedEvent, 0)
+	for rows.Next() {
+		event, err := scanQuarantinedEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+	return events, rows.Err()
+}
+
+// GetQuarantinedEvent returns sql.ErrNoRows unless the event belongs to one
+// of the org's agents.
+func (s *Storage) GetQuarantinedEvent(ctx context.Context, orgID string, id int64) (*models.QuarantinedEvent, error) {
+	return scanQuarantinedEvent(s.db.QueryRowContext(ctx, `
+		SELECT q.id, q.agent_id, q.subject, q.alert_type, q.message, q.payload, q.received_at
+		FROM quarantined_events q
+		JOIN agents a ON a.agent_id = q.agent_id
+		WHERE q.id = $1 AND a.org_id = $2
+	`, id, orgID))
+}
+
+// ReleaseQuarantinedEvent turns a quarantined event into the given incident.
+// It returns sql.ErrNoRows when the event was already released or discarded.
+func (s *Storage) ReleaseQuarantinedEvent(ctx context.Context, id int64, incident *models.Incident) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM quarantined_events WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := tx.QueryRowContext(ctx, `
+		INSERT INTO incidents (agent_id, type, source, raw_error, context, ai_analysis, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`, incident.AgentID, incident.Type, incident.Source, incident.RawError,
+		incident.ContextJSON, incident.AIAnalysis, incident.Status).
+		Scan(&incident.ID, &incident.CreatedAt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteQuarantinedEvent discards an event of one of the org's agents.
+func (s *Storage) DeleteQuarantinedEvent(ctx context.Context, orgID string, id int64) error {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM quarantined_events q
+		USING agents a
+		WHERE q.id = $1 AND a.agent_id = q.agent_id AND a.org_id = $2
+	`, id, orgID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// PurgeQuarantinedEvents deletes events quarantined longer than maxAge.
+func (s *Storage) PurgeQuarantinedEvents(ctx context.Context, maxAge time.Duration) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM quarantined_events WHERE received_at < $1`, time.Now().Add(-maxAge))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func scanQuarantinedEvent(scanner rowScanner) (*models.QuarantinedEvent, error) {
+	var event models.QuarantinedEvent
+	if err := scanner.Scan(&event.ID, &event.AgentID, &event.Subject, &event.AlertType,
+		&event.Message, &event.Payload, &event.ReceivedAt); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"opspilot-backend/internal/models"
+)
+
+// Partitioned tables managed by the retention worker.
+const (
+	IncidentsTable = "incidents"
+	InventoryTable = "agents_inventory"
+)
+
+const archiveSchema = "archive"
+
+// retentionLockKey identifies the retention advisory lock ("retentio" in ASCII).
+const retentionLockKey int64 = 0x726574656e74696f
+
+// WithRetentionLock runs fn while holding the retention advisory lock on a
+// dedicated connection, so only one replica creates, detaches and drops
+// partitions at a time. It returns false without running fn when another
+// replica holds the lock.
+func (s *Storage) WithRetentionLock(ctx context.Context, fn func()) (bool, error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, retentionLockKey).Scan(&locked); err != nil {
+		return false, err
+	}
+	if !locked {
+		return false, nil
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, retentionLockKey); err != nil {
+			slog.WarnContext(ctx, "Retention release lock", "err", err)
+		}
+	}()
+
+	fn()
+	return true, nil
+}
+
+// GetRetentionPolicy returns the org's policy, or the defaults if none is stored.
+func (s *Storage) GetRetentionPolicy(ctx context.Context, orgID string) (*models.RetentionPolicy, error) {
+	var policy models.RetentionPolicy
+	err := s.db.GetContext(ctx, &policy, `
+		SELECT org_id, resolved_incident_days, inventory_days, updated_by, updated_at
+		FROM retention_policies
+		WHERE org_id = $1
+	`, orgID)
+	if err == sql.ErrNoRows {
+		return &models.RetentionPolicy{
+			OrgID:                orgID,
+			ResolvedIncidentDays: models.DefaultResolvedIncidentDays,
+			InventoryDays:        models.DefaultInventoryDays,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func (s *Storage) UpsertRetentionPolicy(ctx context.Context, orgID, userID string, input models.UpdateRetentionPolicyInput) (*models.RetentionPolicy, error) {
+	var policy models.RetentionPolicy
+	err := s.db.GetContext(ctx, &policy, `
+		INSERT INTO retention_policies (org_id, resolved_incident_days, inventory_days, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (org_id) DO UPDATE SET
+			resolved_incident_days = EXCLUDED.resolved_incident_days,
+			inventory_days = EXCLUDED.inventory_days,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = EXCLUDED.updated_at
+		RETURNING org_id, resolved_incident_days, inventory_days, updated_by, updated_at
+	`, orgID, input.ResolvedIncidentDays, input.InventoryDays, nullIfEmpty(userID))
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// MaxRetentionDays returns the longest resolved-incident and inventory
+// retention over all orgs (including the defaults). Partitions older than
+// these can hold no rows that any org still wants.
+func (s *Storage) MaxRetentionDays(ctx context.Context) (incidentDays, inventoryDays int, err error) {
+	err = s.db.QueryRowContext(ctx, `
+		SELECT GREATEST(COALESCE(MAX(resolved_incident_days), 0), $1),
+			GREATEST(COALESCE(MAX(inventory_days), 0), $2)
+		FROM retention_policies
+	`, models.DefaultResolvedIncidentDays, models.DefaultInventoryDays).Scan(&incidentDays, &inventoryDays)
+	return incidentDays, inventoryDays, err
+}
+
+// EnsureMonthlyPartitions creates the partitions of table for the months of
+// at and the following month.
+func (s *Storage) EnsureMonthlyPartitions(ctx context.Context, table string, at time.Time) error {
+	for _, month := range []time.Time{at, at.AddDate(0, 1, 0)} {
+		if _, err := s.db.ExecContext(ctx, `SELECT ensure_monthly_partition($1, $2)`, table, month.UTC()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListPartitions returns the monthly partitions of table (the default
+// partition is excluded), oldest first.
+func (s *Storage) ListPartitions(ctx context.Context, table string) ([]models.Partition, error) {
+	var names []string
+	err := s.db.SelectContext(ctx, &names, `
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		JOIN pg_namespace n ON n.oid = p.relnamespace
+		WHERE p.relname = $1 AND n.nspname = current_schema()
+		ORDER BY c.relname
+	`, table)
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := make([]models.Partition, 0, len(names))
+	for _, name := range names {
+		from, err := time.Parse("200601", strings.TrimPrefix(name, table+"_"))
+		if err != nil {
+			continue
+		}
+		partitions = append(partitions, models.Partition{Name: name, From: from, To: from.AddDate(0, 1, 0)})
+	}
+	return partitions, nil
+}
+
+// PartitionHasRetainedIncidents reports whether an incidents partition still
+// holds unresolved incidents or incidents resolved after cutoff.
+func (s *Storage) PartitionHasRetainedIncidents(ctx context.Context, partition string, cutoff time.Time) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM `+pq.QuoteIdentifier(partition)+` WHERE resolved_at IS NULL OR resolved_at >= $1)`,
+		cutoff,
+	).Scan(&exists)
+	return exists, err
+}
+
+// RemovePartition drops a partition, or detaches it into the archive schema
+// when archive is set.
+func (s *Storage) RemovePartition(ctx context.Context, table, partition string, archive bool) error {
+	if !archive {
+		_, err := s.db.ExecContext(ctx, `DROP TABLE IF EXISTS `+pq.QuoteIdentifier(partition))
+		return err
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`CREATE SCHEMA IF NOT EXISTS ` + archiveSchema,
+		`ALTER TABLE ` + pq.QuoteIdentifier(table) + ` DETACH PARTITION ` + pq.QuoteIdentifier(partition),
+		`ALTER TABLE ` + pq.QuoteIdentifier(partition) + ` SET SCHEMA ` + archiveSchema,
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// PurgeResolvedIncidents deletes resolved incidents older than their org's
+// retention. Incidents without an agent, or of agents without an org or
+// policy, use the default retention.
+func (s *Storage) PurgeResolvedIncidents(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM incidents i
+		WHERE i.resolved_at IS NOT NULL
+		  AND i.resolved_at < NOW() - make_interval(days => COALESCE((
+			SELECT p.resolved_incident_days
+			FROM agents a
+			JOIN retention_policies p ON p.org_id = a.org_id
+			WHERE a.agent_id = i.agent_id
+		  ), $1))
+	`, models.DefaultResolvedIncidentDays)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PruneIncidentIDs deletes the incident_ids entries of incidents that were
+// purged or whose partition was removed.
+func (s *Storage) PruneIncidentIDs(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM incident_ids ids
+		WHERE NOT EXISTS (SELECT 1 FROM incidents i WHERE i.id = ids.id)
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PurgeInventorySnapshots deletes inventory history older than the org's
+// retention. The latest snapshot lives in agents_inventory_latest and is kept.
+func (s *Storage) PurgeInventorySnapshots(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM agents_inventory inv
+		USING agents a
+		LEFT JOIN retention_policies p ON p.org_id = a.org_id
+		WHERE inv.agent_id = a.agent_id
+		  AND inv.ts < NOW() - make_interval(days => COALESCE(p.inventory_days, $1))
+	`, models.DefaultInventoryDays)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
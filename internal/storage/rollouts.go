@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"opspilot-backend/internal/models"
+)
+
+var (
+	ErrRolloutNoTargets = errors.New("no agents match the rollout")
+	ErrRolloutState     = errors.New("rollout is not in a state that allows this")
+)
+
+const rolloutColumns = `
+	id, org_id, target_version, download_url, tags, batch_size, wave_interval_seconds,
+	health_timeout_seconds, max_failures, status, current_wave, wave_completed_at,
+	error, created_by, created_at, updated_at, finished_at
+`
+
+// CreateRollout creates a running rollout targeting the org's admitted agents
+// that carry all tags and do not already run the target version. Agents are
+// assigned to waves of BatchSize in agent_id order.
+func (s *Storage) CreateRollout(ctx context.Context, orgID, userID string, input models.CreateRolloutInput) (*models.Rollout, error) {
+	tags := input.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	tagsJSON, err := json.Marshal(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var rollout models.Rollout
+	err = tx.GetContext(ctx, &rollout, `
+		INSERT INTO agent_rollouts (
+			org_id, target_version, download_url, tags, batch_size, wave_interval_seconds,
+			health_timeout_seconds, max_failures, created_by
+		)
+		VALUES ($1, $2, $3, $4::jsonb, $5, $6, $7, $8, $9)
+		RETURNING `+rolloutColumns,
+		orgID, input.TargetVersion, nullIfEmpty(input.DownloadURL), string(tagsJSON), input.BatchSize,
+		input.WaveIntervalSeconds, input.HealthTimeoutSeconds, input.MaxFailures, nullIfEmpty(userID))
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO agent_rollout_targets (rollout_id, agent_id, wave, from_version)
+		SELECT $1, agent_id, (row_number() OVER (ORDER BY agent_id) - 1) / $4, agent_version
+		FROM agents
+		WHERE org_id = $2
+			AND COALESCE(tags, '[]'::jsonb) @> $3::jsonb
+			AND agent_version IS DISTINCT FROM $5
+			AND status NOT IN ('pending', 'rejected')
+	`, rollout.ID, orgID, string(tagsJSON), input.BatchSize, input.TargetVersion)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrRolloutNoTargets
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	rollout.Tags = tags
+	return &rollout, nil
+}
+
+func (s *Storage) ListRollouts(ctx context.Context, orgID string, limit int) ([]models.Rollout, error) {
+	rollouts := make([]models.Rollout, 0)
+	query := `SELECT ` + rolloutColumns + ` FROM agent_rollouts WHERE org_id = $1 ORDER BY created_at DESC LIMIT $2`
+	if err := s.db.SelectContext(ctx, &rollouts, query, orgID, limit); err != nil {
+		return nil, err
+	}
+	for i := range rollouts {
+		tags, err := decodeStringArray(rollouts[i].TagsJSON)
+		if err != nil {
+			return nil, err
+		}
+		rollouts[i].Tags = tags
+	}
+	return rollouts, nil
+}
+
+// ListRunningRollouts returns running rollouts of every org.
+func (s *Storage) ListRunningRollouts(ctx context.Context) ([]models.Rollout, error) {
+	rollouts := make([]models.Rollout, 0)
+	query := `SELECT ` + rolloutColumns + ` FROM agent_rollouts WHERE status = $1 ORDER BY created_at`
+	if err := s.db.SelectContext(ctx, &rollouts, query, models.RolloutRunning); err != nil {
+		return nil, err
+	}
+	return rollouts, nil
+}
+
+// GetRollout returns sql.ErrNoRows when the rollout is not in the org.
+func (s *Storage) GetRollout(ctx context.Context, orgID, id string) (*models.Rollout, error) {
+	var rollout models.Rollout
+	query := `SELECT ` + rolloutColumns + ` FROM agent_rollouts WHERE org_id = $1 AND id = $2`
+	if err := s.db.GetContext(ctx, &rollout, query, orgID, id); err != nil {
+		return nil, err
+	}
+	var err error
+	if rollout.Tags, err = decodeStringArray(rollout.TagsJSON); err != nil {
+		return nil, err
+	}
+	return &rollout, nil
+}
+
+// ListRolloutTargets returns the rollout's targets with the agents' current
+// status and version; wave < 0 returns every wave.
+func (s *Storage) ListRolloutTargets(ctx context.Context, rolloutID string, wave int) ([]models.RolloutTarget, error) {
+	targets := make([]models.RolloutTarget, 0)
+	query := `
+		SELECT t.rollout_id, t.agent_id, t.wave, t.status, t.from_version, t.sent_at, t.finished_at, t.error,
+			COALESCE(a.status, '') AS agent_status, COALESCE(a.agent_version, '') AS agent_version
+		FROM agent_rollout_targets t
+		JOIN agents a ON a.agent_id = t.agent_id
+		WHERE t.rollout_id = $1 AND ($2 < 0 OR t.wave = $2)
+		ORDER BY t.wave, t.agent_id
+	`
+	if err := s.db.SelectContext(ctx, &targets, query, rolloutID, wave); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// SetRolloutStatus moves a rollout from one of the given statuses to status.
+// It returns ErrRolloutState if the rollout is in another status.
+func (s *Storage) SetRolloutStatus(ctx context.Context, orgID, id, status string, from ...string) (*models.Rollout, error) {
+	fromJSON, err := json.Marshal(from)
+	if err != nil {
+		return nil, err
+	}
+
+	var rollout models.Rollout
+	err = s.db.GetContext(ctx, &rollout, `
+		UPDATE agent_rollouts SET
+			status = $3,
+			updated_at = now(),
+			finished_at = CASE WHEN $3 IN ('completed', 'failed', 'cancelled') THEN now() ELSE finished_at END
+		WHERE org_id = $1 AND id = $2 AND $4::jsonb ? status
+		RETURNING `+rolloutColumns,
+		orgID, id, status, string(fromJSON))
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, getErr := s.GetRollout(ctx, orgID, id); getErr != nil {
+			return nil, getErr
+		}
+		return nil, ErrRolloutState
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rollout.Tags, err = decodeStringArray(rollout.TagsJSON); err != nil {
+		return nil, err
+	}
+	return &rollout, nil
+}
+
+// FinishRollout ends a running rollout as completed or failed.
+func (s *Storage) FinishRollout(ctx context.Context, id, status, reason string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE agent_rollouts
+		SET status = $2, error = $3, updated_at = now(), finished_at = now()
+		WHERE id = $1 AND status = 'running'
+	`, id, status, nullIfEmpty(reason))
+	return err
+}
+
+// StartRolloutWave moves a running rollout from wave from to wave to. It
+// reports false when another worker already did.
+func (s *Storage) StartRolloutWave(ctx context.Context, id string, from, to int) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE agent_rollouts
+		SET current_wave = $3, wave_completed_at = NULL, updated_at = now()
+		WHERE id = $1 AND status = 'running' AND current_wave = $2
+	`, id, from, to)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (s *Storage) CompleteRolloutWave(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE agent_rollouts SET wave_completed_at = now(), updated_at = now()
+		WHERE id = $1 AND wave_completed_at IS NULL
+	`, id)
+	return err
+}
+
+// NextRolloutWave returns the lowest wave that still has pending targets, or
+// -1 when there is none.
+func (s *Storage) NextRolloutWave(ctx context.Context, id string) (int, error) {
+	var wave sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT MIN(wave) FROM agent_rollout_targets WHERE rollout_id = $1 AND status = 'pending'
+	`, id).Scan(&wave)
+	if err != nil {
+		return 0, err
+	}
+	if !wave.Valid {
+		return -1, nil
+	}
+	return int(wave.Int64), nil
+}
+
+func (s *Storage) CountRolloutFailures(ctx context.Context, id string) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM agent_rollout_targets WHERE rollout_id = $1 AND status = 'failed'
+	`, id).Scan(&n)
+	return n, err
+}
+
+// UpdateRolloutTarget records a target's progress; sent_at is set when it
+// becomes sent and finished_at when it succeeds, fails or is skipped.
+func (s *Storage) UpdateRolloutTarget(ctx context.Context, rolloutID, agentID, status, reason string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE agent_rollout_targets SET
+			status = $3,
+			error = $4,
+			sent_at = CASE WHEN $3 = 'sent' THEN now() ELSE sent_at END,
+			finished_at = CASE WHEN $3 IN ('succeeded', 'failed', 'skipped') THEN now() ELSE finished_at END
+		WHERE rollout_id = $1 AND agent_id = $2
+	`, rolloutID, agentID, status, nullIfEmpty(reason))
+	return err
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"opspilot-backend/internal/models"
+)
+
+func (s *Storage) RecordSecurityEvent(ctx context.Context, event models.SecurityEvent) error {
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now().UTC()
+	}
+
+	var details []byte
+	if event.Details != nil {
+		data, err := json.Marshal(event.Details)
+		if err != nil {
+			return err
+		}
+		details = data
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO security_events (
+			id, org_id, agent_id, bootstrap_token_id, type, remote_ip, details, created_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, event.ID, nullIfEmpty(event.OrgID), nullIfEmpty(event.AgentID), nullIfEmpty(event.BootstrapTokenID),
+		event.Type, nullIfEmpty(event.RemoteIP), details, event.CreatedAt)
+	return err
+}
+
+func (s *Storage) ListSecurityEvents(ctx context.Context, orgID, eventType string, limit int) ([]models.SecurityEvent, error) {
+	query := `
+		SELECT id, COALESCE(org_id::text, ''), COALESCE(agent_id, ''),
+			COALESCE(bootstrap_token_id::text, ''), type, COALESCE(remote_ip::text, ''),
+			details, created_at
+		FROM security_events
+		WHERE org_id = $1 AND ($2 = '' OR type = $2)
+		ORDER BY created_at DESC
+		LIMIT $3
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, orgID, eventType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]models.SecurityEvent, 0)
+	for rows.Next() {
+		var event models.SecurityEvent
+		var details []byte
+		if err := rows.Scan(
+			&event.ID,
+			&event.OrgID,
+			&event.AgentID,
+			&event.BootstrapTokenID,
+			&event.Type,
+			&event.RemoteIP,
+			&details,
+			&event.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if len(details) > 0 {
+			_ = json.Unmarshal(details, &event.Details)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
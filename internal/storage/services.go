@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"opspilot-backend/internal/models"
+)
+
+// ServiceObservation is one candidate of an agent's inventory, already
+// clustered into a service by its Key.
+type ServiceObservation struct {
+	Key           string
+	Name          string
+	Type          string
+	CandidateKey  string
+	SourceSystemd string
+	SourceDocker  string
+	ListenPorts   []int
+}
+
+const serviceSelect = `
+	SELECT s.id, s.org_id, s.key, s.name, s.type, s.owner, s.runbook_url, s.description,
+		COUNT(DISTINCT si.agent_id) FILTER (WHERE si.removed_at IS NULL) AS host_count,
+		COUNT(si.agent_id) FILTER (WHERE si.removed_at IS NULL) AS instance_count,
+		s.first_seen_at, s.last_seen_at, s.updated_by, s.updated_at
+	FROM services s
+	LEFT JOIN service_instances si ON si.service_id = s.id
+`
+
+// SyncServiceInstances records the services observed in an agent's latest
+// inventory. Instances of the agent that were not observed are marked
+// removed; observed ones are (re)activated.
+func (s *Storage) SyncServiceInstances(ctx context.Context, orgID, agentID string, observed []ServiceObservation) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, o := range observed {
+		var serviceID string
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO services (org_id, key, name, type)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (org_id, key) DO UPDATE SET last_seen_at = now()
+			RETURNING id
+		`, orgID, o.Key, o.Name, o.Type).Scan(&serviceID)
+		if err != nil {
+			return err
+		}
+
+		ports := o.ListenPorts
+		if ports == nil {
+			ports = []int{}
+		}
+		portsJSON, err := json.Marshal(ports)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO service_instances (
+				service_id, agent_id, candidate_key, source_systemd, source_docker, listen_ports
+			)
+			VALUES ($1, $2, $3, $4, $5, $6::jsonb)
+			ON CONFLICT (service_id, agent_id, candidate_key) DO UPDATE SET
+				source_systemd = EXCLUDED.source_systemd,
+				source_docker = EXCLUDED.source_docker,
+				listen_ports = EXCLUDED.listen_ports,
+				last_seen_at = now(),
+				removed_at = NULL
+		`, serviceID, agentID, o.CandidateKey, o.SourceSystemd, o.SourceDocker, string(portsJSON))
+		if err != nil {
+			return err
+		}
+	}
+
+	// now() is the transaction start, so everything touched above has
+	// last_seen_at = now().
+	_, err = tx.ExecContext(ctx, `
+		UPDATE service_instances SET removed_at = now()
+		WHERE agent_id = $1 AND removed_at IS NULL AND last_seen_at < now()
+	`, agentID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListServices returns the org's services, most widely deployed first.
+func (s *Storage) ListServices(ctx context.Context, orgID string) ([]models.Service, error) {
+	services := make([]models.Service, 0)
+	query := serviceSelect + `
+		WHERE s.org_id = $1
+		GROUP BY s.id
+		ORDER BY host_count DESC, s.name
+	`
+	if err := s.db.SelectContext(ctx, &services, query, orgID); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+// GetService returns sql.ErrNoRows when the service is not in the org.
+func (s *Storage) GetService(ctx context.Context, orgID, id string) (*models.Service, error) {
+	var service models.Service
+	query := serviceSelect + `
+		WHERE s.org_id = $1 AND s.id = $2
+		GROUP BY s.id
+	`
+	if err := s.db.GetContext(ctx, &service, query, orgID, id); err != nil {
+		return nil, err
+	}
+	return &service, nil
+}
+
+// UpdateService sets the owner/runbook/description annotations of a service.
+func (s *Storage) UpdateService(ctx context.Context, orgID, id, userID string, input models.UpdateServiceInput) (*models.Service, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE services SET
+			owner = CASE WHEN $3::boolean THEN NULLIF($4, '') ELSE owner END,
+			runbook_url = CASE WHEN $5::boolean THEN NULLIF($6, '') ELSE runbook_url END,
+			description = CASE WHEN $7::boolean THEN NULLIF($8, '') ELSE description END,
+			updated_by = $9,
+			updated_at = now()
+		WHERE org_id = $1 AND id = $2
+	`, orgID, id,
+		input.Owner != nil, ptrValue(input.Owner),
+		input.RunbookURL != nil, ptrValue(input.RunbookURL),
+		input.Description != nil, ptrValue(input.Description),
+		nullIfEmpty(userID))
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return s.GetService(ctx, orgID, id)
+}
+
+// ListServiceInstances returns the agents a service runs (or ran) on.
+func (s *Storage) ListServiceInstances(ctx context.Context, serviceID string, includeRemoved bool) ([]models.ServiceInstance, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT si.service_id, si.agent_id, COALESCE(a.hostname, ''), si.candidate_key,
+			si.source_systemd, si.source_docker, si.listen_ports,
+			si.first_seen_at, si.last_seen_at, si.removed_at
+		FROM service_instances si
+		JOIN agents a ON a.agent_id = si.agent_id
+		WHERE si.service_id = $1 AND ($2 OR si.removed_at IS NULL)
+		ORDER BY si.removed_at IS NOT NULL, a.hostname, si.candidate_key
+	`, serviceID, includeRemoved)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	instances := make([]models.ServiceInstance, 0)
+	for rows.Next() {
+		var instance models.ServiceInstance
+		var portsJSON []byte
+		if err := rows.Scan(
+			&instance.ServiceID, &instance.AgentID, &instance.Hostname, &instance.CandidateKey,
+			&instance.SourceSystemd, &instance.SourceDocker, &portsJSON,
+			&instance.FirstSeenAt, &instance.LastSeenAt, &instance.RemovedAt,
+		); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(portsJSON, &instance.ListenPorts); err != nil {
+			return nil, err
+		}
+		instances = append(instances, instance)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+// ListServiceIncidents returns incidents raised on the service's hosts whose
+// source names one of its instances (systemd unit, container or service name).
+func (s *Storage) ListServiceIncidents(ctx context.Context, serviceID string, limit int) ([]models.Incident, error) {
+	incidents := make([]models.Incident, 0)
+	query := `
+		SELECT i.id, i.agent_id, i.type, i.source, i.raw_error, i.context, i.ai_analysis,
+			i.is_critical, i.suggested_action, i.status, i.created_at, i.resolved_at
+		FROM incidents i
+		WHERE EXISTS (
+			SELECT 1
+			FROM service_instances si
+			JOIN services s ON s.id = si.service_id
+			WHERE si.service_id = $1
+				AND si.agent_id = i.agent_id
+				AND lower(i.source) IN (lower(NULLIF(si.source_systemd, '')), lower(NULLIF(si.source_docker, '')), s.key, lower(s.name))
+		)
+		ORDER BY i.created_at DESC
+		LIMIT $2
+	`
+	if err := s.db.SelectContext(ctx, &incidents, query, serviceID, limit); err != nil {
+		return nil, err
+	}
+
+	for i := range incidents {
+		if len(incidents[i].ContextJSON) > 0 {
+			json.Unmarshal(incidents[i].ContextJSON, &incidents[i].Context)
+		}
+		if len(incidents[i].SuggestedActionJSON) > 0 {
+			var action models.SuggestedAction
+			if json.Unmarshal(incidents[i].SuggestedActionJSON, &action) == nil {
+				incidents[i].SuggestedAction = &action
+			}
+		}
+	}
+	return incidents, nil
+}
@@ -1,8 +1,11 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -20,13 +23,21 @@ type rowScanner interface {
 	Scan(dest ...any) error
 }
 
-// UpdateAgentMetaAndHostname updates meta JSON and hostname for an agent.
-func (s *Storage) UpdateAgentMetaAndHostname(agentID string, meta []byte, hostname string) error {
+// UpdateAgentMetaAndHostname merges meta JSON into the agent's meta and
+// updates its hostname. Keys missing from meta or null keep their stored values.
+func (s *Storage) UpdateAgentMetaAndHostname(ctx context.Context, agentID string, meta []byte, hostname string) error {
 	if meta == nil {
 		meta = []byte("{}")
 	}
-	query := `UPDATE agents SET meta = $1, hostname = $2, last_seen_at = NOW() WHERE agent_id = $3`
-	_, err := s.db.Exec(query, meta, hostname, agentID)
+	query := `
+		UPDATE agents
+		SET meta = COALESCE(meta, '{}'::jsonb) || jsonb_strip_nulls($1::jsonb), hostname = $2, last_seen_at = NOW()
+		WHERE agent_id = $3
+	`
+	_, err := s.db.ExecContext(ctx, query, meta, hostname, agentID)
+	if err == nil && s.cache != nil {
+		_ = s.cache.Del(agentCacheKey(agentID))
+	}
 	return err
 }
 
@@ -34,7 +45,7 @@ func NewStorage(db *sqlx.DB, cacheClient cache.Client) *Storage {
 	return &Storage{db: db, cache: cacheClient}
 }
 
-func (s *Storage) CreateAgent(agent *models.Agent) error {
+func (s *Storage) CreateAgent(ctx context.Context, agent *models.Agent) error {
 	query := `
 		INSERT INTO agents (
 			id, agent_id, org_id, name, hostname, status, last_seen_at, tags,
@@ -49,7 +60,7 @@ func (s *Storage) CreateAgent(agent *models.Agent) error {
 			status = EXCLUDED.status,
 			last_seen_at = EXCLUDED.last_seen_at,
 			tags = COALESCE(EXCLUDED.tags, agents.tags),
-			hardware_fingerprint = COALESCE(NULLIF(EXCLUDED.hardware_fingerprint, ''), agents.hardware_fingerprint),
+			hardware_fingerprint = COALESCE(NULLIF(agents.hardware_fingerprint, ''), EXCLUDED.hardware_fingerprint),
 			enrolled_via = COALESCE(EXCLUDED.enrolled_via, agents.enrolled_via),
 			enrolled_at = COALESCE(EXCLUDED.enrolled_at, agents.enrolled_at),
 			enrolled_ip = COALESCE(EXCLUDED.enrolled_ip, agents.enrolled_ip),
@@ -80,7 +91,7 @@ func (s *Storage) CreateAgent(agent *models.Agent) error {
 		enrolledIP = nullIfEmpty(*agent.EnrolledIP)
 	}
 
-	_, err = s.db.Exec(query,
+	_, err = s.db.ExecContext(ctx, query,
 		agent.ID,
 		agent.AgentID,
 		nullIfEmpty(agent.OrgID),
@@ -98,7 +109,7 @@ func (s *Storage) CreateAgent(agent *models.Agent) error {
 	return err
 }
 
-func (s *Storage) GetAgentByAgentID(agentID string) (*models.Agent, error) {
+func (s *Storage) GetAgentByAgentID(ctx context.Context, agentID string) (*models.Agent, error) {
 	if s.cache != nil {
 		if cached, err := s.cache.Get(agentCacheKey(agentID)); err == nil && cached != "" {
 			var agent models.Agent
@@ -117,7 +128,7 @@ func (s *Storage) GetAgentByAgentID(agentID string) (*models.Agent, error) {
 		FROM agents
 		WHERE agent_id = $1
 	`
-	agent, err := scanAgentRow(s.db.QueryRow(query, agentID))
+	agent, err := scanAgentRow(s.db.QueryRowContext(ctx, query, agentID))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -133,16 +144,16 @@ func (s *Storage) GetAgentByAgentID(agentID string) (*models.Agent, error) {
 	return &agent, nil
 }
 
-func (s *Storage) ListAgentIDs() ([]string, error) {
+func (s *Storage) ListAgentIDs(ctx context.Context) ([]string, error) {
 	ids := make([]string, 0)
 	query := `SELECT agent_id FROM agents`
-	if err := s.db.Select(&ids, query); err != nil {
+	if err := s.db.SelectContext(ctx, &ids, query); err != nil {
 		return nil, err
 	}
 	return ids, nil
 }
 
-func (s *Storage) GetAgent(id string) (*models.Agent, error) {
+func (s *Storage) GetAgent(ctx context.Context, id string) (*models.Agent, error) {
 	query := `
 		SELECT id, agent_id, org_id,
 		       COALESCE(name, '') AS name,
@@ -152,38 +163,47 @@ func (s *Storage) GetAgent(id string) (*models.Agent, error) {
 		FROM agents
 		WHERE id = $1
 	`
-	agent, err := scanAgentRow(s.db.QueryRow(query, id))
+	agent, err := scanAgentRow(s.db.QueryRowContext(ctx, query, id))
 	if err != nil {
 		return nil, err
 	}
 	return &agent, nil
 }
 
-func (s *Storage) UpdateAgentStatus(agentID, status string) error {
+func (s *Storage) UpdateAgentStatus(ctx context.Context, agentID, status string) error {
 	query := `UPDATE agents SET status = $1, last_seen_at = NOW() WHERE agent_id = $2`
-	_, err := s.db.Exec(query, status, agentID)
+	_, err := s.db.ExecContext(ctx, query, status, agentID)
 	return err
 }
 
-func (s *Storage) MarkAgentOffline(agentID string, lastSeen time.Time) error {
+func (s *Storage) MarkAgentOffline(ctx context.Context, agentID string, lastSeen time.Time) error {
 	query := `UPDATE agents SET status = 'offline', last_seen_at = $2 WHERE agent_id = $1`
-	_, err := s.db.Exec(query, agentID, lastSeen)
+	_, err := s.db.ExecContext(ctx, query, agentID, lastSeen)
 	if s.cache != nil {
 		_ = s.cache.Del(agentCacheKey(agentID))
 	}
 	return err
 }
 
-func (s *Storage) MarkAgentOnline(agentID string, at time.Time) error {
+func (s *Storage) MarkAgentOnline(ctx context.Context, agentID string, at time.Time) error {
 	query := `UPDATE agents SET status = 'online', last_seen_at = $2 WHERE agent_id = $1`
-	_, err := s.db.Exec(query, agentID, at)
+	_, err := s.db.ExecContext(ctx, query, agentID, at)
 	if s.cache != nil {
 		_ = s.cache.Del(agentCacheKey(agentID))
 	}
 	return err
 }
 
-func (s *Storage) CreateIncident(incident *models.Incident) error {
+// CountOnlineAgents returns the number of online agents of all orgs.
+func (s *Storage) CountOnlineAgents(ctx context.Context) (int, error) {
+	var count int
+	if err := s.db.GetContext(ctx, &count, `SELECT COUNT(*) FROM agents WHERE status = 'online'`); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *Storage) CreateIncident(ctx context.Context, incident *models.Incident) error {
 	contextJSON := incident.ContextJSON
 	if contextJSON == nil && incident.Context != nil {
 		contextJSON, _ = json.Marshal(incident.Context)
@@ -194,36 +214,211 @@ func (s *Storage) CreateIncident(incident *models.Incident) error {
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING id, created_at
 	`
-	err := s.db.QueryRow(query, incident.AgentID, incident.Type, incident.Source,
+	err := s.db.QueryRowContext(ctx, query, incident.AgentID, incident.Type, incident.Source,
 		incident.RawError, contextJSON, incident.AIAnalysis, incident.Status).
 		Scan(&incident.ID, &incident.CreatedAt)
 	return err
 }
 
-func (s *Storage) InsertInventorySnapshot(agentID, hash string, payload []byte) error {
+// SaveEvents stores a batch of ingested events in one transaction: incidents
+// of enrolled agents and quarantined events of unknown ones. IDs and
+// timestamps are set on the incidents.
+func (s *Storage) SaveEvents(ctx context.Context, incidents []*models.Incident, quarantined []models.QuarantinedEvent) error {
+	if len(incidents) == 0 && len(quarantined) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(incidents) > 0 {
+		// INSERT ... RETURNING does not promise VALUES order, so the ids are
+		// drawn up front and each returned row is matched back on its id.
+		var ids []int
+		if err := tx.SelectContext(ctx, &ids, `
+			SELECT nextval('incidents_id_seq') FROM generate_series(1, $1) ORDER BY 1
+		`, len(incidents)); err != nil {
+			return err
+		}
+		if len(ids) != len(incidents) {
+			return fmt.Errorf("allocated %d incident ids for %d incidents", len(ids), len(incidents))
+		}
+
+		byID := make(map[int]*models.Incident, len(incidents))
+		values := make([]string, 0, len(incidents))
+		args := make([]any, 0, len(incidents)*8)
+		for i, incident := range incidents {
+			contextJSON := incident.ContextJSON
+			if contextJSON == nil && incident.Context != nil {
+				contextJSON, _ = json.Marshal(incident.Context)
+			}
+			byID[ids[i]] = incident
+			n := len(args)
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8))
+			args = append(args, ids[i], incident.AgentID, incident.Type, incident.Source,
+				incident.RawError, contextJSON, incident.AIAnalysis, incident.Status)
+		}
+		rows, err := tx.QueryContext(ctx, `
+			INSERT INTO incidents (id, agent_id, type, source, raw_error, context, ai_analysis, status)
+			VALUES `+strings.Join(values, ", ")+`
+			RETURNING id, created_at
+		`, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int
+			var createdAt time.Time
+			if err := rows.Scan(&id, &createdAt); err != nil {
+				rows.Close()
+				return err
+			}
+			if incident, ok := byID[id]; ok {
+				incident.ID = id
+				incident.CreatedAt = createdAt
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	if len(quarantined) > 0 {
+		values := make([]string, 0, len(quarantined))
+		args := make([]any, 0, len(quarantined)*5)
+		for _, event := range quarantined {
+			n := len(args)
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5))
+			args = append(args, event.AgentID, event.Subject, event.AlertType, event.Message, event.Payload)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO quarantined_events (agent_id, subject, alert_type, message, payload)
+			VALUES `+strings.Join(values, ", "), args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// InsertInventorySnapshot stores a snapshot when its hash differs from the
+// agent's latest one; agents_inventory_latest always holds the newest snapshot.
+// It reports whether the snapshot was stored and the payload it replaced
+// (nil for the agent's first snapshot).
+func (s *Storage) InsertInventorySnapshot(ctx context.Context, agentID, hash string, payload []byte) ([]byte, bool, error) {
 	query := `
-		INSERT INTO agents_inventory (agent_id, hash, payload)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (agent_id, hash) DO NOTHING
+		WITH previous AS (
+			SELECT payload FROM agents_inventory_latest WHERE agent_id = $1
+		), latest AS (
+			INSERT INTO agents_inventory_latest (agent_id, ts, hash, payload)
+			VALUES ($1, now(), $2, $3)
+			ON CONFLICT (agent_id) DO UPDATE
+			SET ts = EXCLUDED.ts, hash = EXCLUDED.hash, payload = EXCLUDED.payload
+			WHERE agents_inventory_latest.hash <> EXCLUDED.hash
+			RETURNING agent_id
+		), history AS (
+			INSERT INTO agents_inventory (agent_id, hash, payload)
+			SELECT $1, $2, $3 FROM latest
+			RETURNING id
+		)
+		SELECT (SELECT payload FROM previous), EXISTS (SELECT 1 FROM history)
 	`
-	_, err := s.db.Exec(query, agentID, hash, payload)
-	return err
+	var previous []byte
+	var stored bool
+	if err := s.db.QueryRowContext(ctx, query, agentID, hash, payload).Scan(&previous, &stored); err != nil {
+		return nil, false, err
+	}
+	return previous, stored, nil
+}
+
+// InventorySnapshot is one agent's inventory snapshot for
+// InsertInventorySnapshots.
+type InventorySnapshot struct {
+	AgentID string
+	Hash    string
+	Payload []byte
+}
+
+// InsertInventorySnapshots is InsertInventorySnapshot for several agents in
+// one statement; each agent may appear only once. The returned map holds the
+// agents whose snapshot was stored, with the payload it replaced.
+func (s *Storage) InsertInventorySnapshots(ctx context.Context, snapshots []InventorySnapshot) (map[string][]byte, error) {
+	if len(snapshots) == 0 {
+		return nil, nil
+	}
+
+	values := make([]string, 0, len(snapshots))
+	args := make([]any, 0, len(snapshots)*3)
+	for _, snapshot := range snapshots {
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d::text, $%d::text, $%d::jsonb)", n+1, n+2, n+3))
+		args = append(args, snapshot.AgentID, snapshot.Hash, snapshot.Payload)
+	}
+	// Rows are upserted in agent_id order so concurrent batches lock
+	// agents_inventory_latest rows in the same order.
+	query := `
+		WITH input (agent_id, hash, payload) AS (
+			VALUES ` + strings.Join(values, ", ") + `
+		), previous AS (
+			SELECT l.agent_id, l.payload
+			FROM agents_inventory_latest l
+			JOIN input i ON i.agent_id = l.agent_id
+		), latest AS (
+			INSERT INTO agents_inventory_latest (agent_id, ts, hash, payload)
+			SELECT agent_id, now(), hash, payload FROM input ORDER BY agent_id
+			ON CONFLICT (agent_id) DO UPDATE
+			SET ts = EXCLUDED.ts, hash = EXCLUDED.hash, payload = EXCLUDED.payload
+			WHERE agents_inventory_latest.hash <> EXCLUDED.hash
+			RETURNING agent_id
+		), history AS (
+			INSERT INTO agents_inventory (agent_id, hash, payload)
+			SELECT i.agent_id, i.hash, i.payload
+			FROM input i
+			JOIN latest l ON l.agent_id = i.agent_id
+			RETURNING agent_id
+		)
+		SELECT h.agent_id, p.payload
+		FROM history h
+		LEFT JOIN previous p ON p.agent_id = h.agent_id
+	`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stored := make(map[string][]byte)
+	for rows.Next() {
+		var agentID string
+		var previous []byte
+		if err := rows.Scan(&agentID, &previous); err != nil {
+			return nil, err
+		}
+		stored[agentID] = previous
+	}
+	return stored, rows.Err()
 }
 
 func agentCacheKey(agentID string) string {
 	return "ops:agent:by_id:" + agentID
 }
 
-func (s *Storage) GetIncidents(agentID string, limit int) ([]models.Incident, error) {
+func (s *Storage) GetIncidents(ctx context.Context, agentID string, limit int) ([]models.Incident, error) {
 	incidents := make([]models.Incident, 0)
 	query := `
-		SELECT id, agent_id, type, source, raw_error, context, ai_analysis, is_critical, suggested_action, status, created_at
+		SELECT id, agent_id, type, source, raw_error, context, ai_analysis, is_critical, suggested_action, status, created_at, resolved_at
 		FROM incidents
 		WHERE agent_id = $1
 		ORDER BY created_at DESC
 		LIMIT $2
 	`
-	err := s.db.Select(&incidents, query, agentID, limit)
+	err := s.db.SelectContext(ctx, &incidents, query, agentID, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -244,30 +439,28 @@ func (s *Storage) GetIncidents(agentID string, limit int) ([]models.Incident, er
 	return incidents, nil
 }
 
-func (s *Storage) GetLatestInventory(agentID string) (time.Time, []byte, error) {
+func (s *Storage) GetLatestInventory(ctx context.Context, agentID string) (time.Time, []byte, error) {
 	query := `
 		SELECT ts, payload
-		FROM agents_inventory
+		FROM agents_inventory_latest
 		WHERE agent_id = $1
-		ORDER BY ts DESC
-		LIMIT 1
 	`
 	var ts time.Time
 	var payload []byte
-	if err := s.db.QueryRow(query, agentID).Scan(&ts, &payload); err != nil {
+	if err := s.db.QueryRowContext(ctx, query, agentID).Scan(&ts, &payload); err != nil {
 		return time.Time{}, nil, err
 	}
 	return ts, payload, nil
 }
 
-func (s *Storage) GetIncidentByID(id string) (*models.Incident, error) {
+func (s *Storage) GetIncidentByID(ctx context.Context, id string) (*models.Incident, error) {
 	var incident models.Incident
 	query := `
-		SELECT id, agent_id, type, source, raw_error, context, ai_analysis, is_critical, suggested_action, status, created_at
+		SELECT id, agent_id, type, source, raw_error, context, ai_analysis, is_critical, suggested_action, status, created_at, resolved_at
 		FROM incidents
 		WHERE id = $1
 	`
-	err := s.db.Get(&incident, query, id)
+	err := s.db.GetContext(ctx, &incident, query, id)
 	if err != nil {
 		return nil, err
 	}
@@ -286,7 +479,7 @@ func (s *Storage) GetIncidentByID(id string) (*models.Incident, error) {
 	return &incident, nil
 }
 
-func (s *Storage) UpdateIncident(incident *models.Incident) error {
+func (s *Storage) UpdateIncident(ctx context.Context, incident *models.Incident) error {
 	var actionJSON []byte
 	if incident.SuggestedAction != nil {
 		actionJSON, _ = json.Marshal(incident.SuggestedAction)
@@ -303,11 +496,28 @@ func (s *Storage) UpdateIncident(incident *models.Incident) error {
 	} else {
 		suggestedAction = nil
 	}
-	_, err := s.db.Exec(query, incident.AIAnalysis, incident.IsCritical, suggestedAction, incident.Status, incident.ID)
+	_, err := s.db.ExecContext(ctx, query, incident.AIAnalysis, incident.IsCritical, suggestedAction, incident.Status, incident.ID)
 	return err
 }
 
-func (s *Storage) GetAgentByID(id string) (*models.Agent, error) {
+// ResolveIncident marks an incident resolved; resolved incidents are subject
+// to the org's retention policy.
+func (s *Storage) ResolveIncident(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE incidents
+		SET status = 'resolved', resolved_at = NOW()
+		WHERE id = $1 AND resolved_at IS NULL
+	`, id)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Storage) GetAgentByID(ctx context.Context, id string) (*models.Agent, error) {
 	query := `
 		SELECT id, agent_id, org_id,
 		       COALESCE(name, '') AS name,
@@ -317,15 +527,15 @@ func (s *Storage) GetAgentByID(id string) (*models.Agent, error) {
 		FROM agents
 		WHERE id = $1
 	`
-	agent, err := scanAgentRow(s.db.QueryRow(query, id))
+	agent, err := scanAgentRow(s.db.QueryRowContext(ctx, query, id))
 	if err != nil {
 		return nil, err
 	}
 	return &agent, nil
 }
 
-func (s *Storage) MarkStaleAgentsOffline(threshold time.Duration) error {
-	_, err := s.db.Exec(`
+func (s *Storage) MarkStaleAgentsOffline(ctx context.Context, threshold time.Duration) error {
+	_, err := s.db.ExecContext(ctx, `
 		UPDATE agents SET status = 'offline'
 		WHERE status = 'online'
 		AND last_seen_at < NOW() - $1::interval
@@ -333,8 +543,8 @@ func (s *Storage) MarkStaleAgentsOffline(threshold time.Duration) error {
 	return err
 }
 
-func (s *Storage) Ping() error {
-	return s.db.Ping()
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
 }
 
 func scanAgentRow(scanner rowScanner) (models.Agent, error) {
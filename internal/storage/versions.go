@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+
+	"opspilot-backend/internal/models"
+)
+
+// RecordAgentVersion stores the agent's current version and appends a
+// history row when it differs from the stored one.
+func (s *Storage) RecordAgentVersion(ctx context.Context, agentID, version, source string) error {
+	if version == "" {
+		return nil
+	}
+
+	result, err := s.db.ExecContext(ctx, `
+		WITH previous AS (
+			SELECT agent_version FROM agents WHERE agent_id = $1
+		), updated AS (
+			UPDATE agents SET agent_version = $2
+			WHERE agent_id = $1 AND agent_version IS DISTINCT FROM $2
+			RETURNING agent_id
+		)
+		INSERT INTO agent_version_history (agent_id, version, previous_version, source)
+		SELECT $1, $2, (SELECT agent_version FROM previous), $3 FROM updated
+	`, agentID, version, source)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 && s.cache != nil {
+		_ = s.cache.Del(agentCacheKey(agentID))
+	}
+	return nil
+}
+
+func (s *Storage) ListAgentVersionHistory(ctx context.Context, agentID string, limit int) ([]models.AgentVersionRecord, error) {
+	records := make([]models.AgentVersionRecord, 0)
+	query := `
+		SELECT agent_id, version, previous_version, source, seen_at
+		FROM agent_version_history
+		WHERE agent_id = $1
+		ORDER BY seen_at DESC, id DESC
+		LIMIT $2
+	`
+	if err := s.db.SelectContext(ctx, &records, query, agentID, limit); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// ListAgentVersions returns the org's agents with their current version
+// ("" when unknown).
+func (s *Storage) ListAgentVersions(ctx context.Context, orgID string) ([]models.AgentVersionInfo, error) {
+	agents := make([]models.AgentVersionInfo, 0)
+	query := `
+		SELECT agent_id, COALESCE(hostname, '') AS hostname, COALESCE(status, '') AS status,
+			COALESCE(agent_version, '') AS version
+		FROM agents
+		WHERE org_id = $1
+		ORDER BY agent_id
+	`
+	if err := s.db.SelectContext(ctx, &agents, query, orgID); err != nil {
+		return nil, err
+	}
+	return agents, nil
+}
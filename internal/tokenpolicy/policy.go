@@ -0,0 +1,145 @@
+// Package tokenpolicy applies the enrollment policy of bootstrap tokens:
+// name templates, tag rules and allowed OS/arch lists. The active agent
+// limit needs the database and is enforced by storage.EnrollAgent.
+package tokenpolicy
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"opspilot-backend/internal/models"
+)
+
+var (
+	ErrInvalid        = errors.New("invalid bootstrap token policy")
+	ErrOSNotAllowed   = errors.New("bootstrap token os not allowed")
+	ErrArchNotAllowed = errors.New("bootstrap token arch not allowed")
+)
+
+// Placeholders accepted in bootstrap token name templates.
+var nameTemplatePlaceholders = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+var allowedNamePlaceholders = map[string]bool{
+	"hostname": true,
+	"os":       true,
+	"arch":     true,
+	"agent_id": true,
+}
+
+// Validate checks the policy part of a bootstrap token request: template
+// placeholders, tag rule fields and regexes.
+func Validate(input models.CreateBootstrapTokenInput) error {
+	for _, match := range nameTemplatePlaceholders.FindAllStringSubmatch(input.NameTemplate, -1) {
+		if !allowedNamePlaceholders[match[1]] {
+			return fmt.Errorf("%w: unknown name_template placeholder {%s}", ErrInvalid, match[1])
+		}
+	}
+
+	for i, rule := range input.TagRules {
+		switch rule.Field {
+		case "os", "arch", "hostname":
+		default:
+			return fmt.Errorf("%w: tag_rules[%d]: field must be os, arch or hostname", ErrInvalid, i)
+		}
+		if strings.TrimSpace(rule.Tag) == "" {
+			return fmt.Errorf("%w: tag_rules[%d]: tag is required", ErrInvalid, i)
+		}
+		if _, err := regexp.Compile(rule.Match); err != nil {
+			return fmt.Errorf("%w: tag_rules[%d]: %v", ErrInvalid, i, err)
+		}
+	}
+
+	if input.MaxActiveAgents != nil && *input.MaxActiveAgents < 1 {
+		return fmt.Errorf("%w: max_active_agents must be at least 1", ErrInvalid)
+	}
+
+	return nil
+}
+
+// CheckPlatform enforces the token's allowed OS/arch lists for an enrollment
+// request.
+func CheckPlatform(bt *models.BootstrapToken, req models.EnrollAgentRequest) error {
+	if len(bt.AllowedOS) > 0 && !containsFold(bt.AllowedOS, req.OS) {
+		return ErrOSNotAllowed
+	}
+	if len(bt.AllowedArch) > 0 && !containsFold(bt.AllowedArch, req.Arch) {
+		return ErrArchNotAllowed
+	}
+	return nil
+}
+
+// AgentName renders a token name template such as "web-{hostname}".
+func AgentName(template string, req models.EnrollAgentRequest) string {
+	if template == "" {
+		return ""
+	}
+
+	values := map[string]string{
+		"hostname": req.Hostname,
+		"os":       req.OS,
+		"arch":     req.Arch,
+		"agent_id": req.AgentID,
+	}
+	name := nameTemplatePlaceholders.ReplaceAllStringFunc(template, func(placeholder string) string {
+		return values[strings.Trim(placeholder, "{}")]
+	})
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	return name
+}
+
+// DeriveTags returns the token's static tags plus the tags produced by its
+// tag rules for this enrollment, without duplicates.
+func DeriveTags(bt *models.BootstrapToken, req models.EnrollAgentRequest) []string {
+	tags := make([]string, 0, len(bt.Tags)+len(bt.TagRules))
+	seen := make(map[string]bool)
+	add := func(tag string) {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	for _, tag := range bt.Tags {
+		add(tag)
+	}
+
+	for _, rule := range bt.TagRules {
+		var value string
+		switch rule.Field {
+		case "os":
+			value = req.OS
+		case "arch":
+			value = req.Arch
+		case "hostname":
+			value = req.Hostname
+		default:
+			continue
+		}
+
+		re, err := regexp.Compile(rule.Match)
+		if err != nil {
+			continue
+		}
+		submatch := re.FindStringSubmatchIndex(value)
+		if submatch == nil {
+			continue
+		}
+		add(string(re.ExpandString(nil, rule.Tag, value, submatch)))
+	}
+
+	return tags
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
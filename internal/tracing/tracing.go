@@ -0,0 +1,118 @@
+// Package tracing sets up OpenTelemetry tracing and carries trace context
+// across NATS messages.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const serviceName = "opspilot-backend"
+
+// Tracer is the backend's tracer. Spans are no-ops until Setup installs an
+// exporter.
+var Tracer = otel.Tracer(serviceName)
+
+// Setup installs the OTLP/HTTP exporter when OTEL_EXPORTER_OTLP_ENDPOINT (or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) is set; the rest of the exporter and
+// the sampler are configured by the standard OTEL_* variables. Without an
+// endpoint tracing stays disabled. The returned function flushes pending
+// spans on shutdown.
+func Setup(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("create OTLP exporter: %w", err)
+	}
+
+	name := strings.TrimSpace(os.Getenv("OTEL_SERVICE_NAME"))
+	if name == "" {
+		name = serviceName
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(name)))
+	if err != nil {
+		return nil, fmt.Errorf("build resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// HasParent reports whether ctx carries a span, so work outside a traced
+// request or message does not start root spans of its own.
+func HasParent(ctx context.Context) bool {
+	return trace.SpanContextFromContext(ctx).IsValid()
+}
+
+// InjectNATS writes the trace context of ctx into msg's headers.
+func InjectNATS(ctx context.Context, msg *nats.Msg) {
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, natsCarrier(msg.Header))
+}
+
+// ExtractNATS returns ctx with the trace context found in msg's headers.
+func ExtractNATS(ctx context.Context, msg *nats.Msg) context.Context {
+	if msg.Header == nil {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, natsCarrier(msg.Header))
+}
+
+// natsCarrier keeps header keys as given ("traceparent"); NATS headers are
+// case-sensitive, unlike HTTP ones.
+type natsCarrier nats.Header
+
+func (c natsCarrier) Get(key string) string {
+	if v := nats.Header(c).Get(key); v != "" {
+		return v
+	}
+	// Accept canonical-case keys from HTTP-style clients.
+	if values := c[httpCanonical(key)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c natsCarrier) Set(key, value string) {
+	nats.Header(c).Set(key, value)
+}
+
+func (c natsCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func httpCanonical(key string) string {
+	if key == "" {
+		return key
+	}
+	return strings.ToUpper(key[:1]) + strings.ToLower(key[1:])
+}
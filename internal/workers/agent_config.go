@@ -0,0 +1,31 @@
+package workers
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"opspilot-backend/internal/agentconfig"
+)
+
+// StartAgentConfigWorker periodically republishes the desired configs of
+// active agents, org by org, so new agents and tag changes are picked up.
+// Unchanged configs keep their version.
+func StartAgentConfigWorker(ctx context.Context, publisher *agentconfig.Publisher, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if failed := publisher.PublishAll(ctx); failed > 0 {
+					slog.WarnContext(ctx, "Agent config worker: agents failed to publish", "failed", failed)
+				}
+			}
+		}
+	}()
+	slog.InfoContext(ctx, "Agent config worker started", "interval", interval)
+}
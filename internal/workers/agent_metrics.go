@@ -0,0 +1,39 @@
+package workers
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"opspilot-backend/internal/metrics"
+	"opspilot-backend/internal/storage"
+)
+
+// StartAgentMetricsWorker refreshes the online agents gauge.
+func StartAgentMetricsWorker(ctx context.Context, store *storage.Storage, interval time.Duration) {
+	go func() {
+		refreshOnlineAgents(ctx, store)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				refreshOnlineAgents(ctx, store)
+			}
+		}
+	}()
+	slog.InfoContext(ctx, "Agent metrics worker started", "interval", interval)
+}
+
+func refreshOnlineAgents(ctx context.Context, store *storage.Storage) {
+	count, err := store.CountOnlineAgents(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Agent metrics worker count error", "err", err)
+		return
+	}
+	metrics.OnlineAgents.Set(float64(count))
+}
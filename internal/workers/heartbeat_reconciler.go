@@ -2,7 +2,7 @@ package workers
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -22,17 +22,17 @@ func StartHeartbeatReconciler(ctx context.Context, cacheClient cache.Client, sto
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				reconcileOnce(cacheClient, store)
+				reconcileOnce(ctx, cacheClient, store)
 			}
 		}
 	}()
-	log.Println("INFO Heartbeat reconciler started")
+	slog.InfoContext(ctx, "Heartbeat reconciler started")
 }
 
-func reconcileOnce(cacheClient cache.Client, store *storage.Storage) {
-	agentIDs, err := store.ListAgentIDs()
+func reconcileOnce(ctx context.Context, cacheClient cache.Client, store *storage.Storage) {
+	agentIDs, err := store.ListAgentIDs(ctx)
 	if err != nil {
-		log.Printf("WARN Heartbeat reconciler list agents error: %v", err)
+		slog.WarnContext(ctx, "Heartbeat reconciler list agents error", "err", err)
 		return
 	}
 
@@ -40,13 +40,13 @@ func reconcileOnce(cacheClient cache.Client, store *storage.Storage) {
 	for _, agentID := range agentIDs {
 		_, err := cacheClient.GetLastSeen(agentID)
 		if err == redis.Nil {
-			if err := store.MarkAgentOffline(agentID, now); err != nil {
-				log.Printf("WARN Heartbeat reconciler mark offline error for %s: %v", agentID, err)
+			if err := store.MarkAgentOffline(ctx, agentID, now); err != nil {
+				slog.WarnContext(ctx, "Heartbeat reconciler mark offline error", "agent_id", agentID, "err", err)
 			}
 			continue
 		}
 		if err != nil {
-			log.Printf("WARN Heartbeat reconciler cache error for %s: %v", agentID, err)
+			slog.WarnContext(ctx, "Heartbeat reconciler cache error", "agent_id", agentID, "err", err)
 		}
 	}
 }
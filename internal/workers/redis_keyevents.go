@@ -2,7 +2,7 @@ package workers
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -19,7 +19,7 @@ const lastSeenPrefix = "ops:agent:last_seen:"
 func StartRedisKeyeventWorker(ctx context.Context, cacheClient cache.Client, store *storage.Storage) bool {
 	pubsub, err := cacheClient.SubscribeExpired()
 	if err != nil {
-		log.Printf("WARN Redis keyevent subscribe failed: %v", err)
+		slog.WarnContext(ctx, "Redis keyevent subscribe failed", "err", err)
 		return false
 	}
 
@@ -34,16 +34,16 @@ func StartRedisKeyeventWorker(ctx context.Context, cacheClient cache.Client, sto
 				if !ok || msg == nil {
 					return
 				}
-				handleExpired(cacheClient, store, msg)
+				handleExpired(ctx, cacheClient, store, msg)
 			}
 		}
 	}()
 
-	log.Println("INFO Redis keyevent worker started")
+	slog.InfoContext(ctx, "Redis keyevent worker started")
 	return true
 }
 
-func handleExpired(cacheClient cache.Client, store *storage.Storage, msg *redis.Message) {
+func handleExpired(ctx context.Context, cacheClient cache.Client, store *storage.Storage, msg *redis.Message) {
 	if msg == nil {
 		return
 	}
@@ -59,12 +59,12 @@ func handleExpired(cacheClient cache.Client, store *storage.Storage, msg *redis.
 	}
 
 	lastSeenAt := time.UnixMilli(lastSeenMs)
-	if err := store.MarkAgentOffline(agentID, lastSeenAt); err != nil {
-		log.Printf("WARN MarkAgentOffline failed for %s: %v", agentID, err)
+	if err := store.MarkAgentOffline(ctx, agentID, lastSeenAt); err != nil {
+		slog.WarnContext(ctx, "MarkAgentOffline failed", "agent_id", agentID, "err", err)
 		return
 	}
 
 	if err := cacheClient.SetStatus(agentID, "offline"); err != nil {
-		log.Printf("WARN SetStatus offline failed for %s: %v", agentID, err)
+		slog.WarnContext(ctx, "SetStatus offline failed", "agent_id", agentID, "err", err)
 	}
 }
@@ -0,0 +1,121 @@
+package workers
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"opspilot-backend/internal/storage"
+)
+
+// quarantineRetention is how long events of unenrolled agents are kept.
+const quarantineRetention = 7 * 24 * time.Hour
+
+// StartRetentionWorker keeps monthly partitions created ahead of time and
+// applies retention policies: whole partitions past every org's retention are
+// dropped (or moved to the archive schema), then rows past each org's own
+// retention are deleted. Each run holds a Postgres advisory lock; replicas
+// that do not get it skip the run.
+func StartRetentionWorker(ctx context.Context, store *storage.Storage, interval time.Duration, archive bool) {
+	go func() {
+		runRetentionLocked(ctx, store, archive)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				runRetentionLocked(ctx, store, archive)
+			}
+		}
+	}()
+	slog.InfoContext(ctx, "Retention worker started", "interval", interval, "archive", archive)
+}
+
+func runRetentionLocked(ctx context.Context, store *storage.Storage, archive bool) {
+	ran, err := store.WithRetentionLock(ctx, func() { runRetention(ctx, store, archive) })
+	if err != nil {
+		slog.ErrorContext(ctx, "Retention lock", "err", err)
+		return
+	}
+	if !ran {
+		slog.DebugContext(ctx, "Retention run skipped, another replica holds the lock")
+	}
+}
+
+func runRetention(ctx context.Context, store *storage.Storage, archive bool) {
+	now := time.Now().UTC()
+
+	for _, table := range []string{storage.IncidentsTable, storage.InventoryTable} {
+		if err := store.EnsureMonthlyPartitions(ctx, table, now); err != nil {
+			slog.ErrorContext(ctx, "Retention ensure partitions", "table", table, "err", err)
+		}
+	}
+
+	incidentDays, inventoryDays, err := store.MaxRetentionDays(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Retention load policies", "err", err)
+		return
+	}
+
+	removeExpiredPartitions(ctx, store, storage.IncidentsTable, now.AddDate(0, 0, -incidentDays), archive)
+	removeExpiredPartitions(ctx, store, storage.InventoryTable, now.AddDate(0, 0, -inventoryDays), archive)
+
+	if n, err := store.PurgeResolvedIncidents(ctx); err != nil {
+		slog.ErrorContext(ctx, "Retention purge incidents", "err", err)
+	} else if n > 0 {
+		slog.InfoContext(ctx, "Retention purged resolved incidents", "count", n)
+	}
+
+	if n, err := store.PruneIncidentIDs(ctx); err != nil {
+		slog.ErrorContext(ctx, "Retention prune incident ids", "err", err)
+	} else if n > 0 {
+		slog.InfoContext(ctx, "Retention pruned incident ids", "count", n)
+	}
+
+	if n, err := store.PurgeInventorySnapshots(ctx); err != nil {
+		slog.ErrorContext(ctx, "Retention purge inventory", "err", err)
+	} else if n > 0 {
+		slog.InfoContext(ctx, "Retention purged inventory snapshots", "count", n)
+	}
+
+	if n, err := store.PurgeQuarantinedEvents(ctx, quarantineRetention); err != nil {
+		slog.ErrorContext(ctx, "Retention purge quarantined events", "err", err)
+	} else if n > 0 {
+		slog.InfoContext(ctx, "Retention purged quarantined events", "count", n)
+	}
+}
+
+func removeExpiredPartitions(ctx context.Context, store *storage.Storage, table string, cutoff time.Time, archive bool) {
+	partitions, err := store.ListPartitions(ctx, table)
+	if err != nil {
+		slog.ErrorContext(ctx, "Retention list partitions", "table", table, "err", err)
+		return
+	}
+
+	for _, partition := range partitions {
+		if !partition.To.Before(cutoff) {
+			continue
+		}
+
+		if table == storage.IncidentsTable {
+			retained, err := store.PartitionHasRetainedIncidents(ctx, partition.Name, cutoff)
+			if err != nil {
+				slog.ErrorContext(ctx, "Retention check", "partition", partition.Name, "err", err)
+				continue
+			}
+			if retained {
+				continue
+			}
+		}
+
+		if err := store.RemovePartition(ctx, table, partition.Name, archive); err != nil {
+			slog.ErrorContext(ctx, "Retention remove", "partition", partition.Name, "err", err)
+			continue
+		}
+		slog.InfoContext(ctx, "Retention removed partition", "partition", partition.Name, "archived", archive)
+	}
+}
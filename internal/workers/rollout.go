@@ -0,0 +1,203 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"opspilot-backend/internal/models"
+	"opspilot-backend/internal/rpc"
+	"opspilot-backend/internal/storage"
+)
+
+const (
+	// updateActionTimeoutMS bounds how long an agent may take to accept
+	// update_agent; the update itself is confirmed by the health check.
+	updateActionTimeoutMS = 5000
+	// rolloutSendWorkers caps the update_agent requests in flight per wave.
+	rolloutSendWorkers = 16
+)
+
+// StartRolloutWorker drives running agent rollouts: it sends update_agent to
+// one wave at a time, waits until every agent of the wave reports the target
+// version and is online (or the health timeout passes), and starts the next
+// wave after the rollout's wave interval.
+func StartRolloutWorker(ctx context.Context, store *storage.Storage, rpcClient *rpc.Client, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				runRollouts(ctx, store, rpcClient)
+			}
+		}
+	}()
+	slog.InfoContext(ctx, "Rollout worker started", "interval", interval)
+}
+
+func runRollouts(ctx context.Context, store *storage.Storage, rpcClient *rpc.Client) {
+	rollouts, err := store.ListRunningRollouts(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Rollout list", "err", err)
+		return
+	}
+	for i := range rollouts {
+		if err := stepRollout(ctx, store, rpcClient, &rollouts[i]); err != nil {
+			slog.ErrorContext(ctx, "Rollout step failed", "rollout_id", rollouts[i].ID, "org_id", rollouts[i].OrgID, "err", err)
+		}
+	}
+}
+
+func stepRollout(ctx context.Context, store *storage.Storage, rpcClient *rpc.Client, rollout *models.Rollout) error {
+	now := time.Now()
+
+	if rollout.CurrentWave >= 0 && rollout.WaveCompletedAt == nil {
+		done, err := checkRolloutWave(ctx, store, rollout, now)
+		if err != nil || !done {
+			return err
+		}
+
+		failures, err := store.CountRolloutFailures(ctx, rollout.ID)
+		if err != nil {
+			return err
+		}
+		if failures > rollout.MaxFailures {
+			slog.WarnContext(ctx, "Rollout failed", "rollout_id", rollout.ID, "org_id", rollout.OrgID, "failures", failures, "max_failures", rollout.MaxFailures)
+			return store.FinishRollout(ctx, rollout.ID, models.RolloutFailed,
+				fmt.Sprintf("%d agents failed to update (max_failures=%d)", failures, rollout.MaxFailures))
+		}
+		slog.InfoContext(ctx, "Rollout wave healthy", "rollout_id", rollout.ID, "org_id", rollout.OrgID, "wave", rollout.CurrentWave)
+		return store.CompleteRolloutWave(ctx, rollout.ID)
+	}
+
+	if rollout.WaveCompletedAt != nil &&
+		now.Before(rollout.WaveCompletedAt.Add(time.Duration(rollout.WaveIntervalSeconds)*time.Second)) {
+		return nil
+	}
+
+	next, err := store.NextRolloutWave(ctx, rollout.ID)
+	if err != nil {
+		return err
+	}
+	if next < 0 {
+		slog.InfoContext(ctx, "Rollout completed", "rollout_id", rollout.ID, "org_id", rollout.OrgID, "version", rollout.TargetVersion)
+		return store.FinishRollout(ctx, rollout.ID, models.RolloutCompleted, "")
+	}
+
+	started, err := store.StartRolloutWave(ctx, rollout.ID, rollout.CurrentWave, next)
+	if err != nil || !started {
+		return err
+	}
+	slog.InfoContext(ctx, "Rollout wave started", "rollout_id", rollout.ID, "org_id", rollout.OrgID, "wave", next, "version", rollout.TargetVersion)
+	return sendRolloutWave(ctx, store, rpcClient, rollout, next)
+}
+
+// checkRolloutWave resolves sent targets of the current wave and reports
+// whether none is still waiting.
+func checkRolloutWave(ctx context.Context, store *storage.Storage, rollout *models.Rollout, now time.Time) (bool, error) {
+	targets, err := store.ListRolloutTargets(ctx, rollout.ID, rollout.CurrentWave)
+	if err != nil {
+		return false, err
+	}
+
+	timeout := time.Duration(rollout.HealthTimeoutSeconds) * time.Second
+	done := true
+	for _, target := range targets {
+		if target.Status != models.RolloutTargetSent {
+			continue
+		}
+
+		switch {
+		case target.AgentVersion == rollout.TargetVersion && target.AgentStatus == "online":
+			err = store.UpdateRolloutTarget(ctx, rollout.ID, target.AgentID, models.RolloutTargetSucceeded, "")
+		case target.SentAt != nil && now.After(target.SentAt.Add(timeout)):
+			reason := fmt.Sprintf("health check timed out: version=%q status=%q", target.AgentVersion, target.AgentStatus)
+			slog.WarnContext(ctx, "Rollout agent failed", "rollout_id", rollout.ID, "org_id", rollout.OrgID, "agent_id", target.AgentID, "reason", reason)
+			err = store.UpdateRolloutTarget(ctx, rollout.ID, target.AgentID, models.RolloutTargetFailed, reason)
+		default:
+			done = false
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return done, nil
+}
+
+func sendRolloutWave(ctx context.Context, store *storage.Storage, rpcClient *rpc.Client, rollout *models.Rollout, wave int) error {
+	targets, err := store.ListRolloutTargets(ctx, rollout.ID, wave)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]string{"version": rollout.TargetVersion}
+	if rollout.DownloadURL != nil {
+		args["url"] = *rollout.DownloadURL
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, rolloutSendWorkers)
+	for _, target := range targets {
+		if target.Status != models.RolloutTargetPending {
+			continue
+		}
+		if target.AgentVersion == rollout.TargetVersion {
+			if err := store.UpdateRolloutTarget(ctx, rollout.ID, target.AgentID, models.RolloutTargetSkipped, "already on target version"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(target models.RolloutTarget) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := sendRolloutUpdate(ctx, store, rpcClient, rollout, target, args); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(target)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// sendRolloutUpdate sends update_agent to one target and records whether the
+// agent accepted it. Agents that are not online fail without a request.
+func sendRolloutUpdate(ctx context.Context, store *storage.Storage, rpcClient *rpc.Client, rollout *models.Rollout, target models.RolloutTarget, args map[string]string) error {
+	status, reason := models.RolloutTargetSent, ""
+	if target.AgentStatus != "online" {
+		status, reason = models.RolloutTargetFailed, rpc.ErrAgentOffline.Error()
+	} else {
+		resp, err := rpcClient.ExecAction(ctx, target.AgentID, models.UpdateAgentAction, args, updateActionTimeoutMS)
+		switch {
+		case err != nil:
+			status, reason = models.RolloutTargetFailed, err.Error()
+		case !resp.Success:
+			status, reason = models.RolloutTargetFailed, resp.Error
+			if reason == "" {
+				reason = "agent rejected update_agent"
+			}
+		}
+	}
+	if status == models.RolloutTargetFailed {
+		slog.WarnContext(ctx, "Rollout update_agent failed", "rollout_id", rollout.ID, "org_id", rollout.OrgID, "agent_id", target.AgentID, "reason", reason)
+	}
+	return store.UpdateRolloutTarget(ctx, rollout.ID, target.AgentID, status, reason)
+}